@@ -0,0 +1,17 @@
+package access
+
+import "context"
+
+type roleKey struct{}
+
+// WithRole returns a copy of the context carrying the role, so the locks and leases taken with it
+// record who is holding them
+func WithRole(ctx context.Context, role Role) context.Context {
+	return context.WithValue(ctx, roleKey{}, role)
+}
+
+// RoleFromContext returns the role carried by the context, if it has one
+func RoleFromContext(ctx context.Context) (role Role, ok bool) {
+	role, ok = ctx.Value(roleKey{}).(Role)
+	return role, ok
+}
@@ -0,0 +1,327 @@
+package whatnot
+
+/*
+Anti-entropy reconciliation repairs the drift that replication alone cannot prevent, when mutations are lost
+to a full queue, a broken stream or a network partition. Every instance periodically compares digests of its
+Namespaces with each of its peers, descending only into subtrees whose hashes differ, and takes whichever side
+changed most recently. Repairs are announced to watchers as ChangeReconciled events.
+*/
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/databeast/whatnot/peerpb"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultAntiEntropyInterval = time.Second * 30
+	// deleted paths are remembered for this long, so reconciliation does not bring them back
+	defaultTombstoneRetention = time.Minute * 10
+)
+
+// Reconcile compares every Namespace with every peer immediately, rather than waiting for the next
+// anti-entropy round, and repairs any differences found
+func (m *NameSpaceManager) Reconcile(ctx context.Context) error {
+	if m.peering == nil {
+		return errors.Errorf("peer synchronization is not enabled on this manager")
+	}
+	m.peering.mu.Lock()
+	var peers []*peerConn
+	for _, p := range m.peering.peers {
+		peers = append(peers, p)
+	}
+	m.peering.mu.Unlock()
+
+	for _, p := range peers {
+		if err := p.reconcile(ctx); err != nil {
+			return errors.Wrapf(err, "reconciling with %s", p.target)
+		}
+	}
+	return nil
+}
+
+// antiEntropy reconciles with the peer every interval, until the connection is closed
+func (p *peerConn) antiEntropy() {
+	for p.wait(p.sync.reconcileEvery) {
+		ctx, cancel := context.WithTimeout(p.ctx, p.sync.reconcileEvery)
+		if err := p.reconcile(ctx); err != nil {
+			p.Debugf("anti-entropy with %s failed: %s", p.target, err.Error())
+		}
+		cancel()
+	}
+}
+
+// reconcile repairs every Namespace we share with the peer
+func (p *peerConn) reconcile(ctx context.Context) error {
+	m := p.sync.manager
+	m.mu.Lock()
+	var names []string
+	for name := range m.namespaces {
+		names = append(names, name)
+	}
+	m.mu.Unlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		ns, err := m.FetchNamespace(name)
+		if err != nil {
+			continue // unregistered since we listed it
+		}
+		if err = p.reconcileElement(ctx, ns, ns.root, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileElement repairs a single element against the peer's copy, then every child whose subtree differs
+func (p *peerConn) reconcileElement(ctx context.Context, ns *Namespace, local *PathElement, path []string) error {
+	remote, err := p.client.Digest(ctx, &peerpb.DigestRequest{Namespace: ns.name, Path: path})
+	if err != nil {
+		return err
+	}
+	if !remote.Exists {
+		return nil // the peer will learn of it when it reconciles with us
+	}
+
+	children := local.childDigests()
+	local.mu.Lock()
+	value, version := encodeValue(local.resval.Val), local.version
+	local.mu.Unlock()
+	if bytes.Equal(local.hashWith(value, children), remote.Hash) {
+		return nil
+	}
+
+	note := fmt.Sprintf("reconciled with %s", p.target)
+	// divergence is not necessarily concurrency, so the most recent version always wins here
+	if local != ns.root && !bytes.Equal(value, remote.Value) && version.Before(versionFromProto(remote.Version)) {
+		if err = local.reconcileValue(remote.Value, versionFromProto(remote.Version), note); err != nil {
+			return err
+		}
+	}
+
+	ours := make(map[string]*peerpb.ChildDigest)
+	for _, c := range children {
+		ours[c.Section] = c
+	}
+	// children deleted by the peer after they last changed here
+	for _, tomb := range remote.Tombstones {
+		c, ok := ours[tomb.Section]
+		if !ok || tomb.Deleted <= c.Latest {
+			continue
+		}
+		if elem := local.fetchSubElement(SubPath(tomb.Section)); elem != nil {
+			if err = elem.delete(); err != nil {
+				return err
+			}
+		}
+		delete(ours, tomb.Section)
+	}
+
+	for _, rc := range remote.Children {
+		c, ok := ours[rc.Section]
+		if ok && bytes.Equal(c.Hash, rc.Hash) {
+			continue
+		}
+		childPath := append(append([]string{}, path...), rc.Section)
+		var elem *PathElement
+		if ok {
+			elem = local.fetchSubElement(SubPath(rc.Section))
+		} else {
+			deleted, buried := ns.tombstone(absolutePathFromStrings(childPath).ToPathString())
+			if buried && deleted >= rc.Latest {
+				continue // we deleted it since, the peer will remove it when it reconciles with us
+			}
+			if elem, err = local.Add(SubPath(rc.Section)); err != nil {
+				return err
+			}
+			// take the peer's age for it, so we do not appear to have created it after any deletion elsewhere
+			elem.mu.Lock()
+			elem.created = rc.Latest
+			elem.mu.Unlock()
+			elem.changedDigest()
+			elem.selfnotify <- elementChange{id: randid.Uint64(), elem: elem, change: ChangeReconciled, note: note}
+		}
+		if elem == nil {
+			continue // removed while we were comparing
+		}
+		if err = p.reconcileElement(ctx, ns, elem, childPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileValue replaces the value with a peer's newer one
+func (p *PathElement) reconcileValue(encoded []byte, version Version, note string) error {
+	var val interface{}
+	if len(encoded) > 0 {
+		if err := json.Unmarshal(encoded, &val); err != nil {
+			return errors.Wrap(err, "undecodable element value")
+		}
+	}
+	p.clock().observe(version)
+	p.storeValue(ElementValue{Val: val}, version)
+	p.selfnotify <- elementChange{id: randid.Uint64(), elem: p, change: ChangeReconciled, note: note, version: version}
+	return nil
+}
+
+// Digest implements the PeerSync gRPC service, describing a single element to a reconciling peer
+func (s *peerSync) Digest(ctx context.Context, req *peerpb.DigestRequest) (*peerpb.DigestReply, error) {
+	ns, err := s.manager.FetchNamespace(req.Namespace)
+	if err != nil {
+		return &peerpb.DigestReply{}, nil
+	}
+	elem := ns.root
+	if len(req.Path) > 0 {
+		elem = ns.FetchAbsolutePath(absolutePathFromStrings(req.Path).ToPathString())
+	}
+	if elem == nil {
+		return &peerpb.DigestReply{}, nil
+	}
+
+	children := elem.childDigests()
+	elem.mu.Lock()
+	value, version := encodeValue(elem.resval.Val), elem.version
+	elem.mu.Unlock()
+	return &peerpb.DigestReply{
+		Exists:     true,
+		Hash:       elem.hashWith(value, children),
+		Value:      value,
+		Version:    version.toProto(),
+		Children:   children,
+		Tombstones: ns.tombstonesBeneath(absolutePathFromStrings(req.Path).ToPathString()),
+	}, nil
+}
+
+// subtreeDigest is the hash of an element and everything beneath it, with the time of the latest change amongst them
+type subtreeDigest struct {
+	hash   []byte
+	latest int64
+}
+
+// digest hashes this element's value and everything beneath it, along with the time of the latest change amongst them
+// the result is cached until the element or anything beneath it changes, so peers can compare unchanged subtrees cheaply
+func (p *PathElement) digest() (hash []byte, latest int64) {
+	p.mu.Lock()
+	if p.digested != nil {
+		d := p.digested
+		p.mu.Unlock()
+		return d.hash, d.latest
+	}
+	gen := p.digestgen
+	p.mu.Unlock()
+
+	children := p.childDigests()
+	p.mu.Lock()
+	value := encodeValue(p.resval.Val)
+	latest = p.created
+	if p.version.Wall > latest {
+		latest = p.version.Wall
+	}
+	p.mu.Unlock()
+	for _, c := range children {
+		if c.Latest > latest {
+			latest = c.Latest
+		}
+	}
+	hash = p.hashWith(value, children)
+
+	p.mu.Lock()
+	// something changed while we were hashing, so what we have may already be out of date
+	if p.digestgen == gen {
+		p.digested = &subtreeDigest{hash: hash, latest: latest}
+	}
+	p.mu.Unlock()
+	return hash, latest
+}
+
+// changedDigest discards the cached digests of this element and every element above it
+func (p *PathElement) changedDigest() {
+	for e := p; e != nil; e = e.parent {
+		e.mu.Lock()
+		e.digested = nil
+		e.digestgen++
+		e.mu.Unlock()
+	}
+}
+
+// childDigests digests every element directly beneath this one, in path order
+func (p *PathElement) childDigests() (digests []*peerpb.ChildDigest) {
+	for _, c := range p.sortedChildren() {
+		hash, latest := c.digest()
+		digests = append(digests, &peerpb.ChildDigest{Section: string(c.section), Hash: hash, Latest: latest})
+	}
+	return digests
+}
+
+// hashWith combines this element's encoded value with the digests of its children
+func (p *PathElement) hashWith(value []byte, children []*peerpb.ChildDigest) []byte {
+	h := sha256.New()
+	h.Write([]byte(p.section))
+	h.Write([]byte{0})
+	h.Write(value)
+	for _, c := range children {
+		h.Write([]byte{0})
+		h.Write([]byte(c.Section))
+		h.Write(c.Hash)
+	}
+	return h.Sum(nil)
+}
+
+// encodeValue is the JSON encoding of a value that reconciliation compares, empty for no value at all
+func encodeValue(val interface{}) []byte {
+	if val == nil {
+		return nil
+	}
+	encoded, err := json.Marshal(val)
+	if err != nil {
+		return nil
+	}
+	return encoded
+}
+
+// bury remembers that the path was deleted at the given unix nanosecond time
+func (ns *Namespace) bury(path PathString, deleted int64) {
+	ns.tombmu.Lock()
+	ns.tombstones[path] = deleted
+	ns.tombmu.Unlock()
+}
+
+// tombstone returns when the path was deleted, if it was deleted recently
+func (ns *Namespace) tombstone(path PathString) (deleted int64, ok bool) {
+	ns.tombmu.Lock()
+	defer ns.tombmu.Unlock()
+	deleted, ok = ns.tombstones[path]
+	return deleted, ok
+}
+
+// tombstonesBeneath lists the recently deleted children of the given path, forgetting any that have expired
+func (ns *Namespace) tombstonesBeneath(parent PathString) (tombs []*peerpb.Tombstone) {
+	expired := time.Now().Add(-defaultTombstoneRetention).UnixNano()
+	ns.tombmu.Lock()
+	defer ns.tombmu.Unlock()
+	for path, deleted := range ns.tombstones {
+		if deleted < expired {
+			delete(ns.tombstones, path)
+			continue
+		}
+		split := strings.LastIndex(string(path), pathDelimeter)
+		dir := path[:split]
+		if dir == "" {
+			dir = pathDelimeter
+		}
+		if dir == parent {
+			tombs = append(tombs, &peerpb.Tombstone{Section: string(path[split+1:]), Deleted: deleted})
+		}
+	}
+	return tombs
+}
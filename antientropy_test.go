@@ -0,0 +1,155 @@
+package whatnot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/databeast/whatnot/access"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAntiEntropy(t *testing.T) {
+	t.Run("Divergent value is repaired with the newer value", divergentValueIsRepaired)
+	t.Run("Older value does not overwrite a newer one", olderValueIsNotTaken)
+	t.Run("Missing elements are created", missingElementsAreCreated)
+	t.Run("Deleted elements are removed and not resurrected", deletedElementsStayDeleted)
+	t.Run("Identical namespaces need no repair", identicalNamespacesAreUntouched)
+	t.Run("Cached digests follow changes beneath them", cachedDigestsFollowChanges)
+}
+
+func reconcileNow(t *testing.T, nsm *NameSpaceManager) {
+	ctx, cancel := context.WithTimeout(context.Background(), peerSyncTimeout)
+	defer cancel()
+	assert.Nil(t, nsm.Reconcile(ctx), "reconciliation returned error")
+}
+
+// createDivergentPair creates two peers that both hold the given path, before they are made to diverge
+func createDivergentPair(t *testing.T, path PathString) (managers []*NameSpaceManager, elems []*PathElement) {
+	managers, namespaces := createTestCluster(t, 2)
+	if !assert.Nil(t, namespaces[0].RegisterAbsolutePath(path.ToAbsolutePath()), "registering path failed") {
+		t.FailNow()
+	}
+	assert.Eventually(t, func() bool {
+		return namespaces[1].FetchAbsolutePath(path) != nil
+	}, peerSyncTimeout, time.Millisecond*10, "path was not replicated")
+	return managers, []*PathElement{namespaces[0].FetchAbsolutePath(path), namespaces[1].FetchAbsolutePath(path)}
+}
+
+func divergentValueIsRepaired(t *testing.T) {
+	managers, elems := createDivergentPair(t, "/entropy/value")
+	sub := elems[1].SubscribeToEvents(false)
+
+	// changes made without replication, as if the mutation had been lost
+	elems[1].setValue(ElementValue{Val: "stale"}, ChangeEdited, access.Role{}, elems[1].clock().now())
+	elems[0].setValue(ElementValue{Val: "fresh"}, ChangeEdited, access.Role{}, elems[0].clock().now())
+
+	reconcileNow(t, managers[1])
+	assert.Equal(t, "fresh", elems[1].GetValue().Val, "newer value was not taken")
+
+	timeout := time.After(peerSyncTimeout)
+	for {
+		select {
+		case e := <-sub.Events():
+			if e.Change == ChangeReconciled {
+				assert.NotEmpty(t, e.Note, "reconciliation event has no note")
+				return
+			}
+		case <-timeout:
+			t.Error("no reconciliation event was received")
+			return
+		}
+	}
+}
+
+func olderValueIsNotTaken(t *testing.T) {
+	managers, elems := createDivergentPair(t, "/entropy/older")
+
+	elems[0].setValue(ElementValue{Val: "older"}, ChangeEdited, access.Role{}, elems[0].clock().now())
+	elems[1].setValue(ElementValue{Val: "newer"}, ChangeEdited, access.Role{}, elems[1].clock().now())
+
+	reconcileNow(t, managers[1])
+	assert.Equal(t, "newer", elems[1].GetValue().Val, "newer value was overwritten")
+
+	reconcileNow(t, managers[0])
+	assert.Equal(t, "newer", elems[0].GetValue().Val, "peer did not take the newer value")
+}
+
+func missingElementsAreCreated(t *testing.T) {
+	managers, namespaces := createTestCluster(t, 2)
+	_ = namespaces[0].registerAbsolutePath(PathString("/entropy/missing/deep").ToAbsolutePath())
+	elem := namespaces[0].FetchAbsolutePath("/entropy/missing/deep")
+	elem.setValue(ElementValue{Val: "found"}, ChangeEdited, access.Role{}, elem.clock().now())
+
+	reconcileNow(t, managers[1])
+	repaired := namespaces[1].FetchAbsolutePath("/entropy/missing/deep")
+	if assert.NotNil(t, repaired, "missing element was not created") {
+		assert.Equal(t, "found", repaired.GetValue().Val, "missing element value was not repaired")
+	}
+}
+
+func deletedElementsStayDeleted(t *testing.T) {
+	managers, elems := createDivergentPair(t, "/entropy/deleted")
+	ns1 := elems[1].namespace
+
+	if !assert.Nil(t, elems[0].delete(), "deleting element failed") {
+		return
+	}
+
+	// the instance that still has it must not push it back
+	reconcileNow(t, managers[0])
+	assert.Nil(t, elems[0].namespace.FetchAbsolutePath("/entropy/deleted"), "deleted element was resurrected")
+
+	reconcileNow(t, managers[1])
+	assert.Nil(t, ns1.FetchAbsolutePath("/entropy/deleted"), "deletion was not repaired")
+}
+
+func identicalNamespacesAreUntouched(t *testing.T) {
+	managers, elems := createDivergentPair(t, "/entropy/same")
+	elems[0].SetValue(ElementValue{Val: "same"}, ChangeEdited, access.Role{})
+	assert.Eventually(t, func() bool {
+		return elems[1].GetValue().Val == "same"
+	}, peerSyncTimeout, time.Millisecond*10, "value was not replicated")
+
+	elems[1].mu.Lock()
+	version := elems[1].version
+	elems[1].mu.Unlock()
+
+	reconcileNow(t, managers[1])
+	elems[1].mu.Lock()
+	defer elems[1].mu.Unlock()
+	assert.Equal(t, version, elems[1].version, "identical element was rewritten")
+}
+
+func cachedDigestsFollowChanges(t *testing.T) {
+	// no test logger, as the peers of the tests around this one would go on logging to it once it has finished
+	nsm, err := NewNamespaceManager()
+	if !assert.Nil(t, err, "NewNamespaceManager returned error") {
+		t.FailNow()
+	}
+	gns := NewNamespace(testNameSpace)
+	if !assert.Nil(t, nsm.RegisterNamespace(gns), "RegisterNamespace returned error") {
+		t.FailNow()
+	}
+	elem, _ := gns.FetchOrCreateAbsolutePath("/entropy/cached/deep")
+	top := gns.FetchAbsolutePath("/entropy")
+
+	before, _ := top.digest()
+	top.mu.Lock()
+	assert.NotNil(t, top.digested, "digest was not cached")
+	top.mu.Unlock()
+	again, _ := top.digest()
+	assert.Equal(t, before, again, "unchanged subtree hashed differently")
+
+	elem.SetValue(ElementValue{Val: "changed"}, ChangeEdited, access.Role{})
+	changed, _ := top.digest()
+	assert.NotEqual(t, before, changed, "value change beneath was not seen")
+
+	added, _ := elem.Add("added")
+	withChild, _ := top.digest()
+	assert.NotEqual(t, changed, withChild, "added element beneath was not seen")
+
+	assert.Nil(t, added.delete())
+	removed, _ := top.digest()
+	assert.Equal(t, changed, removed, "deleted element beneath was still hashed")
+}
@@ -0,0 +1,6 @@
+/*
+Package apipb contains the generated gRPC service that a standalone whatnot server offers its clients
+*/
+package apipb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative whatnot.proto
@@ -0,0 +1,680 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: whatnot.proto
+
+package apipb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Whatnot_ListNamespaces_FullMethodName      = "/whatnot.api.Whatnot/ListNamespaces"
+	Whatnot_RegisterNamespace_FullMethodName   = "/whatnot.api.Whatnot/RegisterNamespace"
+	Whatnot_FetchNamespace_FullMethodName      = "/whatnot.api.Whatnot/FetchNamespace"
+	Whatnot_FetchPath_FullMethodName           = "/whatnot.api.Whatnot/FetchPath"
+	Whatnot_SetValue_FullMethodName            = "/whatnot.api.Whatnot/SetValue"
+	Whatnot_DeletePath_FullMethodName          = "/whatnot.api.Whatnot/DeletePath"
+	Whatnot_Lock_FullMethodName                = "/whatnot.api.Whatnot/Lock"
+	Whatnot_KeepAlive_FullMethodName           = "/whatnot.api.Whatnot/KeepAlive"
+	Whatnot_FetchLease_FullMethodName          = "/whatnot.api.Whatnot/FetchLease"
+	Whatnot_Release_FullMethodName             = "/whatnot.api.Whatnot/Release"
+	Whatnot_CreateSemaphorePool_FullMethodName = "/whatnot.api.Whatnot/CreateSemaphorePool"
+	Whatnot_Claim_FullMethodName               = "/whatnot.api.Whatnot/Claim"
+	Whatnot_ReturnClaim_FullMethodName         = "/whatnot.api.Whatnot/ReturnClaim"
+	Whatnot_Watch_FullMethodName               = "/whatnot.api.Whatnot/Watch"
+)
+
+// WhatnotClient is the client API for Whatnot service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type WhatnotClient interface {
+	// ListNamespaces lists every registered Namespace
+	ListNamespaces(ctx context.Context, in *ListNamespacesRequest, opts ...grpc.CallOption) (*ListNamespacesReply, error)
+	// RegisterNamespace registers a new, empty Namespace
+	RegisterNamespace(ctx context.Context, in *NamespaceRequest, opts ...grpc.CallOption) (*NamespaceReply, error)
+	// FetchNamespace fails with NOT_FOUND unless the Namespace is registered
+	FetchNamespace(ctx context.Context, in *NamespaceRequest, opts ...grpc.CallOption) (*NamespaceReply, error)
+	// FetchPath describes an element, its value and its children, creating it if requested
+	FetchPath(ctx context.Context, in *PathRequest, opts ...grpc.CallOption) (*Element, error)
+	// SetValue replaces the value of an element, creating it if needed
+	SetValue(ctx context.Context, in *SetValueRequest, opts ...grpc.CallOption) (*Element, error)
+	// DeletePath removes an element and everything beneath it
+	DeletePath(ctx context.Context, in *PathRequest, opts ...grpc.CallOption) (*DeleteReply, error)
+	// Lock waits for and acquires a lease on an element, or on it and everything beneath it
+	Lock(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*Lease, error)
+	// KeepAlive renews a lease with every request received, replying with its new expiry
+	// a lease that is not renewed is released once its ttl passes
+	KeepAlive(ctx context.Context, opts ...grpc.CallOption) (Whatnot_KeepAliveClient, error)
+	// FetchLease describes a lease that is still held
+	FetchLease(ctx context.Context, in *LeaseRequest, opts ...grpc.CallOption) (*Lease, error)
+	// Release ends a lease early
+	Release(ctx context.Context, in *LeaseRequest, opts ...grpc.CallOption) (*ReleaseReply, error)
+	// CreateSemaphorePool attaches a semaphore pool to an element
+	CreateSemaphorePool(ctx context.Context, in *CreatePoolRequest, opts ...grpc.CallOption) (*CreatePoolReply, error)
+	// Claim waits for slots of an element's semaphore pool, for as long as the call's deadline allows
+	Claim(ctx context.Context, in *ClaimRequest, opts ...grpc.CallOption) (*SemaphoreClaim, error)
+	// ReturnClaim gives claimed slots back to their pool
+	ReturnClaim(ctx context.Context, in *ReturnClaimRequest, opts ...grpc.CallOption) (*ReturnClaimReply, error)
+	// Watch streams the events of an element, and optionally of everything beneath it
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Whatnot_WatchClient, error)
+}
+
+type whatnotClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewWhatnotClient(cc grpc.ClientConnInterface) WhatnotClient {
+	return &whatnotClient{cc}
+}
+
+func (c *whatnotClient) ListNamespaces(ctx context.Context, in *ListNamespacesRequest, opts ...grpc.CallOption) (*ListNamespacesReply, error) {
+	out := new(ListNamespacesReply)
+	err := c.cc.Invoke(ctx, Whatnot_ListNamespaces_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *whatnotClient) RegisterNamespace(ctx context.Context, in *NamespaceRequest, opts ...grpc.CallOption) (*NamespaceReply, error) {
+	out := new(NamespaceReply)
+	err := c.cc.Invoke(ctx, Whatnot_RegisterNamespace_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *whatnotClient) FetchNamespace(ctx context.Context, in *NamespaceRequest, opts ...grpc.CallOption) (*NamespaceReply, error) {
+	out := new(NamespaceReply)
+	err := c.cc.Invoke(ctx, Whatnot_FetchNamespace_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *whatnotClient) FetchPath(ctx context.Context, in *PathRequest, opts ...grpc.CallOption) (*Element, error) {
+	out := new(Element)
+	err := c.cc.Invoke(ctx, Whatnot_FetchPath_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *whatnotClient) SetValue(ctx context.Context, in *SetValueRequest, opts ...grpc.CallOption) (*Element, error) {
+	out := new(Element)
+	err := c.cc.Invoke(ctx, Whatnot_SetValue_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *whatnotClient) DeletePath(ctx context.Context, in *PathRequest, opts ...grpc.CallOption) (*DeleteReply, error) {
+	out := new(DeleteReply)
+	err := c.cc.Invoke(ctx, Whatnot_DeletePath_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *whatnotClient) Lock(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*Lease, error) {
+	out := new(Lease)
+	err := c.cc.Invoke(ctx, Whatnot_Lock_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *whatnotClient) KeepAlive(ctx context.Context, opts ...grpc.CallOption) (Whatnot_KeepAliveClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Whatnot_ServiceDesc.Streams[0], Whatnot_KeepAlive_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &whatnotKeepAliveClient{stream}
+	return x, nil
+}
+
+type Whatnot_KeepAliveClient interface {
+	Send(*KeepAliveRequest) error
+	Recv() (*Lease, error)
+	grpc.ClientStream
+}
+
+type whatnotKeepAliveClient struct {
+	grpc.ClientStream
+}
+
+func (x *whatnotKeepAliveClient) Send(m *KeepAliveRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *whatnotKeepAliveClient) Recv() (*Lease, error) {
+	m := new(Lease)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *whatnotClient) FetchLease(ctx context.Context, in *LeaseRequest, opts ...grpc.CallOption) (*Lease, error) {
+	out := new(Lease)
+	err := c.cc.Invoke(ctx, Whatnot_FetchLease_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *whatnotClient) Release(ctx context.Context, in *LeaseRequest, opts ...grpc.CallOption) (*ReleaseReply, error) {
+	out := new(ReleaseReply)
+	err := c.cc.Invoke(ctx, Whatnot_Release_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *whatnotClient) CreateSemaphorePool(ctx context.Context, in *CreatePoolRequest, opts ...grpc.CallOption) (*CreatePoolReply, error) {
+	out := new(CreatePoolReply)
+	err := c.cc.Invoke(ctx, Whatnot_CreateSemaphorePool_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *whatnotClient) Claim(ctx context.Context, in *ClaimRequest, opts ...grpc.CallOption) (*SemaphoreClaim, error) {
+	out := new(SemaphoreClaim)
+	err := c.cc.Invoke(ctx, Whatnot_Claim_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *whatnotClient) ReturnClaim(ctx context.Context, in *ReturnClaimRequest, opts ...grpc.CallOption) (*ReturnClaimReply, error) {
+	out := new(ReturnClaimReply)
+	err := c.cc.Invoke(ctx, Whatnot_ReturnClaim_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *whatnotClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Whatnot_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Whatnot_ServiceDesc.Streams[1], Whatnot_Watch_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &whatnotWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Whatnot_WatchClient interface {
+	Recv() (*WatchEvent, error)
+	grpc.ClientStream
+}
+
+type whatnotWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *whatnotWatchClient) Recv() (*WatchEvent, error) {
+	m := new(WatchEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WhatnotServer is the server API for Whatnot service.
+// All implementations must embed UnimplementedWhatnotServer
+// for forward compatibility
+type WhatnotServer interface {
+	// ListNamespaces lists every registered Namespace
+	ListNamespaces(context.Context, *ListNamespacesRequest) (*ListNamespacesReply, error)
+	// RegisterNamespace registers a new, empty Namespace
+	RegisterNamespace(context.Context, *NamespaceRequest) (*NamespaceReply, error)
+	// FetchNamespace fails with NOT_FOUND unless the Namespace is registered
+	FetchNamespace(context.Context, *NamespaceRequest) (*NamespaceReply, error)
+	// FetchPath describes an element, its value and its children, creating it if requested
+	FetchPath(context.Context, *PathRequest) (*Element, error)
+	// SetValue replaces the value of an element, creating it if needed
+	SetValue(context.Context, *SetValueRequest) (*Element, error)
+	// DeletePath removes an element and everything beneath it
+	DeletePath(context.Context, *PathRequest) (*DeleteReply, error)
+	// Lock waits for and acquires a lease on an element, or on it and everything beneath it
+	Lock(context.Context, *LockRequest) (*Lease, error)
+	// KeepAlive renews a lease with every request received, replying with its new expiry
+	// a lease that is not renewed is released once its ttl passes
+	KeepAlive(Whatnot_KeepAliveServer) error
+	// FetchLease describes a lease that is still held
+	FetchLease(context.Context, *LeaseRequest) (*Lease, error)
+	// Release ends a lease early
+	Release(context.Context, *LeaseRequest) (*ReleaseReply, error)
+	// CreateSemaphorePool attaches a semaphore pool to an element
+	CreateSemaphorePool(context.Context, *CreatePoolRequest) (*CreatePoolReply, error)
+	// Claim waits for slots of an element's semaphore pool, for as long as the call's deadline allows
+	Claim(context.Context, *ClaimRequest) (*SemaphoreClaim, error)
+	// ReturnClaim gives claimed slots back to their pool
+	ReturnClaim(context.Context, *ReturnClaimRequest) (*ReturnClaimReply, error)
+	// Watch streams the events of an element, and optionally of everything beneath it
+	Watch(*WatchRequest, Whatnot_WatchServer) error
+	mustEmbedUnimplementedWhatnotServer()
+}
+
+// UnimplementedWhatnotServer must be embedded to have forward compatible implementations.
+type UnimplementedWhatnotServer struct {
+}
+
+func (UnimplementedWhatnotServer) ListNamespaces(context.Context, *ListNamespacesRequest) (*ListNamespacesReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListNamespaces not implemented")
+}
+func (UnimplementedWhatnotServer) RegisterNamespace(context.Context, *NamespaceRequest) (*NamespaceReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterNamespace not implemented")
+}
+func (UnimplementedWhatnotServer) FetchNamespace(context.Context, *NamespaceRequest) (*NamespaceReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FetchNamespace not implemented")
+}
+func (UnimplementedWhatnotServer) FetchPath(context.Context, *PathRequest) (*Element, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FetchPath not implemented")
+}
+func (UnimplementedWhatnotServer) SetValue(context.Context, *SetValueRequest) (*Element, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetValue not implemented")
+}
+func (UnimplementedWhatnotServer) DeletePath(context.Context, *PathRequest) (*DeleteReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeletePath not implemented")
+}
+func (UnimplementedWhatnotServer) Lock(context.Context, *LockRequest) (*Lease, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Lock not implemented")
+}
+func (UnimplementedWhatnotServer) KeepAlive(Whatnot_KeepAliveServer) error {
+	return status.Errorf(codes.Unimplemented, "method KeepAlive not implemented")
+}
+func (UnimplementedWhatnotServer) FetchLease(context.Context, *LeaseRequest) (*Lease, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FetchLease not implemented")
+}
+func (UnimplementedWhatnotServer) Release(context.Context, *LeaseRequest) (*ReleaseReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Release not implemented")
+}
+func (UnimplementedWhatnotServer) CreateSemaphorePool(context.Context, *CreatePoolRequest) (*CreatePoolReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateSemaphorePool not implemented")
+}
+func (UnimplementedWhatnotServer) Claim(context.Context, *ClaimRequest) (*SemaphoreClaim, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Claim not implemented")
+}
+func (UnimplementedWhatnotServer) ReturnClaim(context.Context, *ReturnClaimRequest) (*ReturnClaimReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReturnClaim not implemented")
+}
+func (UnimplementedWhatnotServer) Watch(*WatchRequest, Whatnot_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedWhatnotServer) mustEmbedUnimplementedWhatnotServer() {}
+
+// UnsafeWhatnotServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to WhatnotServer will
+// result in compilation errors.
+type UnsafeWhatnotServer interface {
+	mustEmbedUnimplementedWhatnotServer()
+}
+
+func RegisterWhatnotServer(s grpc.ServiceRegistrar, srv WhatnotServer) {
+	s.RegisterService(&Whatnot_ServiceDesc, srv)
+}
+
+func _Whatnot_ListNamespaces_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListNamespacesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WhatnotServer).ListNamespaces(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Whatnot_ListNamespaces_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WhatnotServer).ListNamespaces(ctx, req.(*ListNamespacesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Whatnot_RegisterNamespace_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NamespaceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WhatnotServer).RegisterNamespace(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Whatnot_RegisterNamespace_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WhatnotServer).RegisterNamespace(ctx, req.(*NamespaceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Whatnot_FetchNamespace_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NamespaceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WhatnotServer).FetchNamespace(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Whatnot_FetchNamespace_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WhatnotServer).FetchNamespace(ctx, req.(*NamespaceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Whatnot_FetchPath_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PathRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WhatnotServer).FetchPath(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Whatnot_FetchPath_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WhatnotServer).FetchPath(ctx, req.(*PathRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Whatnot_SetValue_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetValueRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WhatnotServer).SetValue(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Whatnot_SetValue_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WhatnotServer).SetValue(ctx, req.(*SetValueRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Whatnot_DeletePath_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PathRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WhatnotServer).DeletePath(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Whatnot_DeletePath_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WhatnotServer).DeletePath(ctx, req.(*PathRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Whatnot_Lock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WhatnotServer).Lock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Whatnot_Lock_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WhatnotServer).Lock(ctx, req.(*LockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Whatnot_KeepAlive_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(WhatnotServer).KeepAlive(&whatnotKeepAliveServer{stream})
+}
+
+type Whatnot_KeepAliveServer interface {
+	Send(*Lease) error
+	Recv() (*KeepAliveRequest, error)
+	grpc.ServerStream
+}
+
+type whatnotKeepAliveServer struct {
+	grpc.ServerStream
+}
+
+func (x *whatnotKeepAliveServer) Send(m *Lease) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *whatnotKeepAliveServer) Recv() (*KeepAliveRequest, error) {
+	m := new(KeepAliveRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Whatnot_FetchLease_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LeaseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WhatnotServer).FetchLease(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Whatnot_FetchLease_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WhatnotServer).FetchLease(ctx, req.(*LeaseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Whatnot_Release_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LeaseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WhatnotServer).Release(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Whatnot_Release_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WhatnotServer).Release(ctx, req.(*LeaseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Whatnot_CreateSemaphorePool_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreatePoolRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WhatnotServer).CreateSemaphorePool(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Whatnot_CreateSemaphorePool_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WhatnotServer).CreateSemaphorePool(ctx, req.(*CreatePoolRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Whatnot_Claim_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClaimRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WhatnotServer).Claim(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Whatnot_Claim_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WhatnotServer).Claim(ctx, req.(*ClaimRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Whatnot_ReturnClaim_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReturnClaimRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WhatnotServer).ReturnClaim(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Whatnot_ReturnClaim_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WhatnotServer).ReturnClaim(ctx, req.(*ReturnClaimRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Whatnot_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WhatnotServer).Watch(m, &whatnotWatchServer{stream})
+}
+
+type Whatnot_WatchServer interface {
+	Send(*WatchEvent) error
+	grpc.ServerStream
+}
+
+type whatnotWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *whatnotWatchServer) Send(m *WatchEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Whatnot_ServiceDesc is the grpc.ServiceDesc for Whatnot service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Whatnot_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "whatnot.api.Whatnot",
+	HandlerType: (*WhatnotServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListNamespaces",
+			Handler:    _Whatnot_ListNamespaces_Handler,
+		},
+		{
+			MethodName: "RegisterNamespace",
+			Handler:    _Whatnot_RegisterNamespace_Handler,
+		},
+		{
+			MethodName: "FetchNamespace",
+			Handler:    _Whatnot_FetchNamespace_Handler,
+		},
+		{
+			MethodName: "FetchPath",
+			Handler:    _Whatnot_FetchPath_Handler,
+		},
+		{
+			MethodName: "SetValue",
+			Handler:    _Whatnot_SetValue_Handler,
+		},
+		{
+			MethodName: "DeletePath",
+			Handler:    _Whatnot_DeletePath_Handler,
+		},
+		{
+			MethodName: "Lock",
+			Handler:    _Whatnot_Lock_Handler,
+		},
+		{
+			MethodName: "FetchLease",
+			Handler:    _Whatnot_FetchLease_Handler,
+		},
+		{
+			MethodName: "Release",
+			Handler:    _Whatnot_Release_Handler,
+		},
+		{
+			MethodName: "CreateSemaphorePool",
+			Handler:    _Whatnot_CreateSemaphorePool_Handler,
+		},
+		{
+			MethodName: "Claim",
+			Handler:    _Whatnot_Claim_Handler,
+		},
+		{
+			MethodName: "ReturnClaim",
+			Handler:    _Whatnot_ReturnClaim_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "KeepAlive",
+			Handler:       _Whatnot_KeepAlive_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Watch",
+			Handler:       _Whatnot_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "whatnot.proto",
+}
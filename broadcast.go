@@ -102,6 +102,9 @@ func (t *EventMultiplexer) BroadcastAsync(evt WatchEvent) {
 func (t *EventMultiplexer) run(broadcastchan <-chan WatchEvent) {
 	for msg := range broadcastchan {
 		func() {
+			// subscribers come and go from other goroutines, and sends never block, so hold the lock throughout
+			t.lock.Lock()
+			defer t.lock.Unlock()
 			// send our broadcast event to every subscriber
 			for ch, rec := range t.connections {
 				if rec == false {
@@ -115,11 +118,9 @@ func (t *EventMultiplexer) run(broadcastchan <-chan WatchEvent) {
 					t.Debugf("transmitted event %d to broadcast subscriber", msg.id)
 				default:
 					// cannot send message, listener has closed the channel
-					t.lock.Lock()
 					t.Debugf("%s removing disconnected subscriber", t.onElement.AbsolutePath().ToPathString())
 					delete(t.connections, ch)
 					close(ch)
-					t.lock.Unlock()
 				}
 			}
 		}()
@@ -196,12 +197,13 @@ func (p *PathElement) watchChildren() {
 
 			// Broadcast the change out to all subscribers
 			p.subscriberNotify.Broadcast <- WatchEvent{
-				id:     e.id,
-				elem:   e.elem,
-				TS:     time.Now(),
-				Note:   "",
-				Change: e.change,
-				Actor:  e.actor,
+				id:      e.id,
+				elem:    e.elem,
+				TS:      time.Now(),
+				Note:    e.note,
+				Change:  e.change,
+				Actor:   e.actor,
+				Version: e.version,
 			}
 			// TODO: needs close handler
 		}
@@ -0,0 +1,92 @@
+/*
+Package client connects to a standalone whatnot server over its gRPC API.
+
+Its Namespace and PathElement mirror those of the whatnot package, so that code can move between an embedded
+NameSpaceManager and a remote server with few changes. The differences are the ones a network forces: methods
+that reach the server also report its errors, and elements are handles addressed by path rather than the elements
+themselves, so an element deleted by another client is only discovered when it is next used.
+*/
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/databeast/whatnot/apipb"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// callTimeout bounds every call that would return immediately on an embedded instance
+// calls that wait, for a lease or a semaphore claim, are bounded by their contexts instead
+const callTimeout = time.Second * 30
+
+// Client is a connection to a whatnot server
+type Client struct {
+	conn *grpc.ClientConn // only set when the client dialled the connection itself
+	api  apipb.WhatnotClient
+}
+
+// Dial connects to a whatnot server's gRPC API
+// without any options the connection is made without transport security
+func Dial(target string, opts ...grpc.DialOption) (*Client, error) {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	conn, err := grpc.Dial(target, opts...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "dialling whatnot server %s", target)
+	}
+	c := New(conn)
+	c.conn = conn
+	return c, nil
+}
+
+// New creates a client over an existing connection, which remains the caller's to close
+func New(conn grpc.ClientConnInterface) *Client {
+	return &Client{api: apipb.NewWhatnotClient(conn)}
+}
+
+// Close closes the connection, if the client dialled it
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+func (c *Client) call() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), callTimeout)
+}
+
+// Namespaces lists the names of every namespace registered on the server
+func (c *Client) Namespaces() ([]string, error) {
+	ctx, cancel := c.call()
+	defer cancel()
+	reply, err := c.api.ListNamespaces(ctx, &apipb.ListNamespacesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return reply.Namespaces, nil
+}
+
+// RegisterNamespace registers a new, empty namespace on the server
+func (c *Client) RegisterNamespace(name string) (*Namespace, error) {
+	ctx, cancel := c.call()
+	defer cancel()
+	if _, err := c.api.RegisterNamespace(ctx, &apipb.NamespaceRequest{Name: name}); err != nil {
+		return nil, err
+	}
+	return &Namespace{client: c, name: name}, nil
+}
+
+// FetchNamespace fetches a namespace registered on the server
+func (c *Client) FetchNamespace(name string) (*Namespace, error) {
+	ctx, cancel := c.call()
+	defer cancel()
+	if _, err := c.api.FetchNamespace(ctx, &apipb.NamespaceRequest{Name: name}); err != nil {
+		return nil, err
+	}
+	return &Namespace{client: c, name: name}, nil
+}
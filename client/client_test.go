@@ -0,0 +1,271 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/databeast/whatnot"
+	"github.com/databeast/whatnot/access"
+	"github.com/databeast/whatnot/server"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestClient(t *testing.T) {
+	t.Run("Namespaces can be listed, registered and fetched", namespacesRegistered)
+	t.Run("Values round trip through the server", valuesRoundTrip)
+	t.Run("Paths and children are listed", pathsListed)
+	t.Run("Leases are exclusive until released", leasesExclusive)
+	t.Run("Leases expire unless kept alive", leasesKeptAlive)
+	t.Run("Semaphore claims are limited to the pool size", semaphoreClaims)
+	t.Run("Watches stream element events", watchesStream)
+}
+
+// createTestClient connects a client to an in-process server over an in-memory listener
+func createTestClient(t *testing.T) (*server.Server, *Client) {
+	cfg := server.DefaultConfig()
+	cfg.Namespaces = []string{"testing"}
+	srv, err := server.New(cfg)
+	if !assert.Nil(t, err, "creating server failed") {
+		t.FailNow()
+	}
+	lis := bufconn.Listen(1024 * 1024)
+	go func() { _ = srv.ServeGRPC(lis) }()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if !assert.Nil(t, err, "dialling server failed") {
+		t.FailNow()
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	})
+	return srv, New(conn)
+}
+
+func testNamespace(t *testing.T, c *Client) *Namespace {
+	ns, err := c.FetchNamespace("testing")
+	if !assert.Nil(t, err, "fetching namespace failed") {
+		t.FailNow()
+	}
+	return ns
+}
+
+func namespacesRegistered(t *testing.T) {
+	_, c := createTestClient(t)
+
+	_, err := c.RegisterNamespace("orders")
+	assert.Nil(t, err, "registering namespace failed")
+	_, err = c.RegisterNamespace("orders")
+	assert.Equal(t, codes.AlreadyExists, status.Code(err), "namespace was registered twice")
+
+	names, err := c.Namespaces()
+	assert.Nil(t, err, "listing namespaces failed")
+	assert.ElementsMatch(t, []string{"testing", "orders"}, names)
+
+	_, err = c.FetchNamespace("missing")
+	assert.Equal(t, codes.NotFound, status.Code(err), "unknown namespace was fetched")
+}
+
+func valuesRoundTrip(t *testing.T) {
+	srv, c := createTestClient(t)
+	ns := testNamespace(t, c)
+
+	elem, err := ns.FetchOrCreateAbsolutePath("/config/limits")
+	if !assert.Nil(t, err, "creating path failed") {
+		return
+	}
+	assert.Equal(t, whatnot.SubPath("limits"), elem.SubPath())
+	assert.Nil(t, elem.SetValue(whatnot.ElementValue{Val: map[string]interface{}{"max": 10.0}}), "setting value failed")
+
+	current, err := elem.GetVersionedValue()
+	assert.Nil(t, err, "reading value failed")
+	assert.Equal(t, map[string]interface{}{"max": 10.0}, current.Value.Val)
+	assert.NotZero(t, current.Version.Wall, "value has no version")
+
+	// the value is the server's own
+	local, _ := srv.Manager().FetchNamespace("testing")
+	assert.Equal(t, current.Version, local.FetchAbsolutePath("/config/limits").GetVersionedValue().Version)
+
+	assert.Nil(t, elem.Delete(), "deleting element failed")
+	missing, err := ns.FetchAbsolutePath("/config/limits")
+	assert.Nil(t, err, "fetching a deleted path failed")
+	assert.Nil(t, missing, "deleted path was found")
+}
+
+func pathsListed(t *testing.T) {
+	_, c := createTestClient(t)
+	ns := testNamespace(t, c)
+
+	for _, path := range []whatnot.PathString{"/jobs/nightly", "/jobs/hourly", "/hosts/a"} {
+		_, err := ns.FetchOrCreateAbsolutePath(path)
+		assert.Nil(t, err, "creating path failed")
+	}
+	jobs, _ := ns.FetchAbsolutePath("/jobs")
+	children, err := jobs.Children()
+	assert.Nil(t, err, "listing children failed")
+	var names []whatnot.SubPath
+	for _, child := range children {
+		names = append(names, child.SubPath())
+	}
+	assert.ElementsMatch(t, []whatnot.SubPath{"nightly", "hourly"}, names)
+
+	all, err := ns.FetchAllAbsolutePaths()
+	assert.Nil(t, err, "listing paths failed")
+	var paths []whatnot.PathString
+	for _, path := range all {
+		paths = append(paths, path.ToPathString())
+	}
+	assert.ElementsMatch(t, []whatnot.PathString{"/jobs/nightly", "/jobs/hourly", "/hosts/a"}, paths)
+}
+
+func leasesExclusive(t *testing.T) {
+	_, c := createTestClient(t)
+	ns := testNamespace(t, c)
+	elem, _ := ns.FetchOrCreateAbsolutePath("/jobs/nightly")
+
+	first, release := elem.LockWithLease(time.Minute)
+	if !assert.Nil(t, first.Err(), "acquiring lease failed") {
+		return
+	}
+	deadline, ok := first.Deadline()
+	assert.True(t, ok && deadline.After(time.Now()), "lease has no deadline")
+
+	acquired := make(chan *Lease)
+	go func() {
+		second, _ := elem.LockWithLease(time.Minute)
+		acquired <- second
+	}()
+	select {
+	case <-acquired:
+		t.Error("second lease was granted while the first was held")
+		return
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	release()
+	select {
+	case second := <-acquired:
+		assert.Nil(t, second.Err(), "second lease failed")
+		second.Release()
+	case <-time.After(time.Second * 5):
+		t.Error("second lease was not granted after the first was released")
+	}
+	assert.Equal(t, context.Canceled, first.Err(), "released lease reports the wrong error")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	abandoned, _ := elem.ContextLockWithLease(ctx, time.Minute)
+	assert.NotNil(t, abandoned.Err(), "lease was granted to a cancelled context")
+
+	untimed, _ := elem.LockWithLease(0)
+	assert.Equal(t, codes.InvalidArgument, status.Code(untimed.Err()), "lease without a ttl was not refused as invalid")
+}
+
+func leasesKeptAlive(t *testing.T) {
+	_, c := createTestClient(t)
+	ns := testNamespace(t, c)
+	elem, _ := ns.FetchOrCreateAbsolutePath("/jobs/renewed")
+
+	kept, release := elem.LockWithLease(time.Millisecond * 300)
+	defer release()
+	stop, err := kept.KeepAlive()
+	if !assert.Nil(t, err, "keeping lease alive failed") {
+		return
+	}
+	defer stop()
+	lapsed, _ := elem.Parent().LockWithLease(time.Millisecond * 300)
+	assert.Nil(t, lapsed.Err(), "acquiring lease failed")
+
+	select {
+	case <-lapsed.Done():
+		assert.Equal(t, context.DeadlineExceeded, lapsed.Err(), "expired lease reports the wrong error")
+	case <-time.After(time.Second * 5):
+		t.Error("lease that was not renewed did not expire")
+	}
+	assert.Nil(t, kept.Err(), "lease that was kept alive expired")
+}
+
+func semaphoreClaims(t *testing.T) {
+	_, c := createTestClient(t)
+	ns := testNamespace(t, c)
+	elem, _ := ns.FetchOrCreateAbsolutePath("/workers")
+	if !assert.Nil(t, elem.CreateSemaphorePool(false, false, whatnot.SemaphorePoolOpts{PoolSize: 2}), "creating pool failed") {
+		return
+	}
+
+	claim, err := elem.SemaphorePool().Claim(context.Background(), 2)
+	if !assert.Nil(t, err, "claiming slots failed") {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	_, err = elem.SemaphorePool().Claim(ctx, 1)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err), "claim beyond the pool size was granted")
+
+	assert.Nil(t, claim.Return(), "returning claim failed")
+	again, err := elem.SemaphorePool().Claim(context.Background(), 1)
+	assert.Nil(t, err, "claiming returned slots failed")
+	assert.Nil(t, again.Return(), "returning claim failed")
+
+	missing, _ := ns.FetchOrCreateAbsolutePath("/unpooled")
+	_, err = missing.SemaphorePool().Claim(context.Background(), 1)
+	assert.Equal(t, codes.NotFound, status.Code(err), "claim on an element without a pool was granted")
+}
+
+func watchesStream(t *testing.T) {
+	srv, c := createTestClient(t)
+	ns := testNamespace(t, c)
+	elem, _ := ns.FetchOrCreateAbsolutePath("/watched")
+
+	sub, err := elem.SubscribeToEvents(true)
+	if !assert.Nil(t, err, "subscribing failed") {
+		return
+	}
+	child, _ := ns.FetchOrCreateAbsolutePath("/watched/child")
+	_ = child.SetValue(whatnot.ElementValue{Val: "changed"})
+
+	timeout := time.After(time.Second * 5)
+	for received := false; !received; {
+		select {
+		case e := <-sub.Events():
+			received = e.Change == whatnot.ChangeEdited && e.OnElement().AbsolutePath().ToPathString() == "/watched/child"
+		case <-timeout:
+			t.Error("value change was not streamed")
+			return
+		}
+	}
+
+	// events caused by someone with a role name them, such as a lease taken on the server with one
+	local, _ := srv.Manager().FetchNamespace("testing")
+	watched := local.FetchAbsolutePath("/watched")
+	lease, release := watched.ContextLockWithLease(access.WithRole(context.Background(), access.Role{Name: "worker"}), time.Minute)
+	defer release()
+	assert.Nil(t, lease.Err())
+	for received := false; !received; {
+		select {
+		case e := <-sub.Events():
+			if received = e.Change == whatnot.ChangeLocked; received {
+				assert.Equal(t, "worker", e.Actor.Name, "event did not name who caused it")
+			}
+		case <-timeout:
+			t.Error("lock was not streamed")
+			return
+		}
+	}
+
+	elem.UnSubscribeFromEvents(sub)
+	assert.Eventually(t, func() bool { return srv.Watching() == 0 }, time.Second*5, time.Millisecond*10, "watch was not closed on unsubscribing")
+	assert.Nil(t, sub.Err(), "unsubscribed watch reports an error")
+}
@@ -0,0 +1,134 @@
+package client
+
+import (
+	"github.com/databeast/whatnot"
+	"github.com/databeast/whatnot/apipb"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// PathElement is a handle to an element of a namespace on the server
+type PathElement struct {
+	ns   *Namespace
+	path whatnot.AbsolutePath
+}
+
+// SubPath returns the name of this Path Element, without the parent section of the path
+func (p *PathElement) SubPath() whatnot.SubPath {
+	if len(p.path) == 0 {
+		return ""
+	}
+	return p.path[len(p.path)-1]
+}
+
+// AbsolutePath returns the full Path of this Element
+func (p *PathElement) AbsolutePath() whatnot.AbsolutePath {
+	return p.path
+}
+
+// Parent returns the parent of this element, or nil for the root of the namespace
+func (p *PathElement) Parent() *PathElement {
+	if len(p.path) == 0 {
+		return nil
+	}
+	return &PathElement{ns: p.ns, path: p.path[:len(p.path)-1]}
+}
+
+func (p *PathElement) fetch(create bool, recursive bool) (*apipb.Element, error) {
+	ctx, cancel := p.ns.client.call()
+	defer cancel()
+	req := p.ns.pathRequest(p.path)
+	req.Create, req.Recursive = create, recursive
+	return p.ns.client.api.FetchPath(ctx, req)
+}
+
+// Add creates a sub element of this element, or returns it if it already exists
+func (p *PathElement) Add(path whatnot.SubPath) (*PathElement, error) {
+	return p.FetchOrCreateSubPath(whatnot.PathString(path))
+}
+
+// FetchOrCreateSubPath returns the element at the relative path beneath this one, creating any of it that does not exist
+func (p *PathElement) FetchOrCreateSubPath(subPath whatnot.PathString) (*PathElement, error) {
+	return p.ns.FetchOrCreateAbsolutePath(p.path.ToPathString() + "/" + subPath)
+}
+
+// FetchSubPath returns the element at the relative path beneath this one, or an error if it does not exist
+func (p *PathElement) FetchSubPath(subPath whatnot.PathString) (*PathElement, error) {
+	elem, err := p.ns.FetchAbsolutePath(p.path.ToPathString() + "/" + subPath)
+	if err == nil && elem == nil {
+		err = errors.Errorf("subpath %q does not exist beneath %s", subPath, p.path.ToPathString())
+	}
+	return elem, err
+}
+
+// Children are the elements directly beneath this one
+func (p *PathElement) Children() ([]*PathElement, error) {
+	reply, err := p.fetch(false, false)
+	if err != nil {
+		return nil, err
+	}
+	children := make([]*PathElement, 0, len(reply.Children))
+	for _, name := range reply.Children {
+		children = append(children, &PathElement{ns: p.ns, path: append(append(whatnot.AbsolutePath{}, p.path...), whatnot.SubPath(name))})
+	}
+	return children, nil
+}
+
+// FetchAllSubPaths returns every path beneath this element that has no children of its own, relative to this element
+func (p *PathElement) FetchAllSubPaths() (allpaths [][]whatnot.SubPath, err error) {
+	reply, err := p.fetch(false, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range reply.Paths {
+		allpaths = append(allpaths, cleanPath(whatnot.PathString(path))[len(p.path):])
+	}
+	return allpaths, nil
+}
+
+// Delete removes this element and everything beneath it
+func (p *PathElement) Delete() error {
+	ctx, cancel := p.ns.client.call()
+	defer cancel()
+	_, err := p.ns.client.api.DeletePath(ctx, p.ns.pathRequest(p.path))
+	return err
+}
+
+// SetValue replaces the value of this element, creating the element if it does not exist
+// the value must be representable as JSON, as it is for the server's HTTP API
+func (p *PathElement) SetValue(value whatnot.ElementValue) error {
+	encoded, err := structpb.NewValue(value.Val)
+	if err != nil {
+		return errors.Wrap(err, "value cannot be sent to the server")
+	}
+	ctx, cancel := p.ns.client.call()
+	defer cancel()
+	_, err = p.ns.client.api.SetValue(ctx, &apipb.SetValueRequest{
+		Namespace: p.ns.name,
+		Path:      string(p.path.ToPathString()),
+		Value:     encoded,
+	})
+	return err
+}
+
+// GetValue returns the current value of this element
+func (p *PathElement) GetValue() (whatnot.ElementValue, error) {
+	current, err := p.GetVersionedValue()
+	return current.Value, err
+}
+
+// GetVersionedValue returns the current value of this element, with the version that wrote it
+func (p *PathElement) GetVersionedValue() (whatnot.VersionedValue, error) {
+	reply, err := p.fetch(false, false)
+	if err != nil {
+		return whatnot.VersionedValue{}, err
+	}
+	return whatnot.VersionedValue{
+		Value:   whatnot.ElementValue{Val: reply.Value.AsInterface()},
+		Version: decodeVersion(reply.Version),
+	}, nil
+}
+
+func decodeVersion(v *apipb.Version) whatnot.Version {
+	return whatnot.Version{Wall: v.GetWall(), Logical: v.GetLogical(), Node: v.GetNode()}
+}
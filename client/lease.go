@@ -0,0 +1,213 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/databeast/whatnot"
+	"github.com/databeast/whatnot/apipb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Lease is a lease held on the server, implementing the Context interface as whatnot.LeaseContext does
+// its Deadline moves back as the lease is renewed, and it is done once the lease is released or expires
+type Lease struct {
+	client *Client
+	id     string
+	ttl    time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      *sync.Mutex
+	expires time.Time
+	expire  *time.Timer
+	cause   error // reported by Err in place of context.Canceled, once the lease has ended
+}
+
+// LockWithLease waits for a lease on this element, that lasts for ttl unless it is renewed with KeepAlive
+func (p *PathElement) LockWithLease(ttl time.Duration) (ctx *Lease, release func()) {
+	return p.ContextLockWithLease(context.Background(), ttl)
+}
+
+// ContextLockWithLease waits for a lease on this element, ending the lease if the parent context ends
+// should the parent end before the lease is granted, or the server refuse it, the lease is returned already done
+func (p *PathElement) ContextLockWithLease(octx context.Context, ttl time.Duration) (ctx *Lease, release func()) {
+	return p.lockWithLease(octx, ttl, false)
+}
+
+// LockPrefixWithLease waits for a lease on this element and every element beneath it
+func (p *PathElement) LockPrefixWithLease(ttl time.Duration) (ctx *Lease, release func()) {
+	return p.ContextLockPrefixWithLease(context.Background(), ttl)
+}
+
+// ContextLockPrefixWithLease waits for a lease on this element and every element beneath it, ending the lease if the parent context ends
+func (p *PathElement) ContextLockPrefixWithLease(octx context.Context, ttl time.Duration) (ctx *Lease, release func()) {
+	return p.lockWithLease(octx, ttl, true)
+}
+
+func (p *PathElement) lockWithLease(octx context.Context, ttl time.Duration, prefix bool) (*Lease, func()) {
+	lease := &Lease{client: p.ns.client, ttl: ttl, mu: &sync.Mutex{}}
+	lease.ctx, lease.cancel = context.WithCancel(octx)
+	granted, err := p.ns.client.api.Lock(lease.ctx, &apipb.LockRequest{
+		Namespace: p.ns.name,
+		Path:      string(p.path.ToPathString()),
+		Ttl:       durationpb.New(ttl),
+		Prefix:    prefix,
+	})
+	if err != nil {
+		lease.end(err)
+		return lease, lease.Release
+	}
+	lease.id = granted.Id
+	lease.expires = granted.Expires.AsTime()
+	lease.expire = time.AfterFunc(time.Until(lease.expires), func() { lease.end(context.DeadlineExceeded) })
+	go func() {
+		<-lease.ctx.Done()
+		lease.expire.Stop()
+		// the parent context ended, rather than the lease being released, expiring or lost
+		if lease.end(lease.ctx.Err()) {
+			lease.releaseOnServer()
+		}
+	}()
+	return lease, lease.Release
+}
+
+// end finishes the lease for the given reason, reporting if it had not already finished for another
+func (l *Lease) end(cause error) (ended bool) {
+	l.mu.Lock()
+	if l.cause == nil {
+		l.cause, ended = cause, true
+	}
+	l.mu.Unlock()
+	l.cancel()
+	return ended
+}
+
+// releaseOnServer releases the lease on the server, which has already released leases that expired or were lost
+func (l *Lease) releaseOnServer() {
+	ctx, cancel := l.client.call()
+	defer cancel()
+	_, _ = l.client.api.Release(ctx, &apipb.LeaseRequest{Id: l.id})
+}
+
+// ID of the lease on the server, empty if it was never granted
+func (l *Lease) ID() string {
+	return l.id
+}
+
+// Release ends the lease, returning once the server has released it
+func (l *Lease) Release() {
+	if l.end(context.Canceled) && l.id != "" {
+		l.releaseOnServer()
+	}
+}
+
+// Deadline implements the Context interface, reporting when the lease expires unless it is renewed
+func (l *Lease) Deadline() (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.expires, !l.expires.IsZero()
+}
+
+// Done implements the Context interface
+func (l *Lease) Done() <-chan struct{} {
+	return l.ctx.Done()
+}
+
+// Err implements the Context interface
+// leases that were never granted report why, and leases that expired report context.DeadlineExceeded
+func (l *Lease) Err() error {
+	err := l.ctx.Err()
+	if err == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cause != nil {
+		return l.cause
+	}
+	return err
+}
+
+// Value implements the Context interface
+func (l *Lease) Value(key interface{}) interface{} {
+	return l.ctx.Value(key)
+}
+
+// Renew pushes the lease's deadline back to ttl from now, as whatnot.LeaseContext.Renew does
+func (l *Lease) Renew(ttl time.Duration) error {
+	if err := l.Err(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(l.ctx, callTimeout)
+	defer cancel()
+	stream, err := l.client.api.KeepAlive(ctx)
+	if err != nil {
+		return err
+	}
+	if err = stream.Send(&apipb.KeepAliveRequest{Id: l.id, Ttl: durationpb.New(ttl)}); err != nil {
+		return err
+	}
+	renewed, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	l.renewed(renewed.Expires.AsTime())
+	return stream.CloseSend()
+}
+
+// KeepAlive renews the lease in the background by its original ttl, every third of that ttl, until the lease ends
+// or stop is called. If the server can no longer renew it, the lease ends with the server's error
+func (l *Lease) KeepAlive() (stop func(), err error) {
+	if err = l.Err(); err != nil {
+		return nil, err
+	}
+	ctx, stop := context.WithCancel(l.ctx)
+	stream, err := l.client.api.KeepAlive(ctx)
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	every := l.ttl / 3
+	if every < whatnot.MinKeepAliveInterval {
+		every = whatnot.MinKeepAliveInterval
+	}
+	go func() {
+		defer stop()
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			if err := stream.Send(&apipb.KeepAliveRequest{Id: l.id, Ttl: durationpb.New(l.ttl)}); err != nil {
+				return // the stream ends with the lease, or when stopped
+			}
+			renewed, err := stream.Recv()
+			if err != nil {
+				if status.Code(err) != codes.Canceled {
+					l.end(err)
+				}
+				return
+			}
+			l.renewed(renewed.Expires.AsTime())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return stop, nil
+}
+
+func (l *Lease) renewed(expires time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ctx.Err() != nil {
+		return
+	}
+	l.expires = expires
+	l.expire.Reset(time.Until(expires))
+}
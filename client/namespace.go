@@ -0,0 +1,83 @@
+package client
+
+import (
+	"github.com/databeast/whatnot"
+	"github.com/databeast/whatnot/apipb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Namespace is a namespace registered on the server
+type Namespace struct {
+	client *Client
+	name   string
+}
+
+// Name of the namespace
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// Root is the element at the top of the Namespace, that every path begins beneath
+func (ns *Namespace) Root() *PathElement {
+	return &PathElement{ns: ns}
+}
+
+func (ns *Namespace) element(path whatnot.PathString) *PathElement {
+	return &PathElement{ns: ns, path: cleanPath(path)}
+}
+
+// RegisterAbsolutePath constructs a complete path in the Namespace
+func (ns *Namespace) RegisterAbsolutePath(path whatnot.AbsolutePath) error {
+	_, err := ns.FetchOrCreateAbsolutePath(path.ToPathString())
+	return err
+}
+
+// FetchOrCreateAbsolutePath returns the element at the end of the path, creating any of the path that does not exist
+func (ns *Namespace) FetchOrCreateAbsolutePath(path whatnot.PathString) (*PathElement, error) {
+	elem := ns.element(path)
+	if _, err := elem.fetch(true, false); err != nil {
+		return nil, err
+	}
+	return elem, nil
+}
+
+// FetchAbsolutePath returns the element at the end of the path, or nil if it does not exist
+// errors other than the path not existing are returned with it
+func (ns *Namespace) FetchAbsolutePath(path whatnot.PathString) (*PathElement, error) {
+	elem := ns.element(path)
+	if _, err := elem.fetch(false, false); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return elem, nil
+}
+
+// FetchAllAbsolutePaths returns every path in the namespace that has no children of its own
+func (ns *Namespace) FetchAllAbsolutePaths() (allpaths []whatnot.AbsolutePath, err error) {
+	all, err := ns.Root().FetchAllSubPaths()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range all {
+		allpaths = append(allpaths, a)
+	}
+	return allpaths, nil
+}
+
+// cleanPath is the absolute path of an element however its slashes were given
+func cleanPath(path whatnot.PathString) whatnot.AbsolutePath {
+	var sections whatnot.AbsolutePath
+	for _, section := range path.ToRelativePath() {
+		if section != "" {
+			sections = append(sections, section)
+		}
+	}
+	return sections
+}
+
+func (ns *Namespace) pathRequest(path whatnot.AbsolutePath) *apipb.PathRequest {
+	return &apipb.PathRequest{Namespace: ns.name, Path: string(path.ToPathString())}
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+
+	"github.com/databeast/whatnot"
+	"github.com/databeast/whatnot/apipb"
+)
+
+// SemaphorePool is a handle to the semaphore pool of an element
+type SemaphorePool struct {
+	elem *PathElement
+}
+
+// SemaphoreClaim is a number of slots claimed from a semaphore pool, held until they are returned
+type SemaphoreClaim struct {
+	client *Client
+	id     string
+	Slots  int64
+}
+
+// CreateSemaphorePool attaches a semaphore pool to this element, creating the element if it does not exist
+func (p *PathElement) CreateSemaphorePool(prefix bool, purge bool, opts whatnot.SemaphorePoolOpts) error {
+	ctx, cancel := p.ns.client.call()
+	defer cancel()
+	_, err := p.ns.client.api.CreateSemaphorePool(ctx, &apipb.CreatePoolRequest{
+		Namespace: p.ns.name,
+		Path:      string(p.path.ToPathString()),
+		Size:      opts.PoolSize,
+		Prefix:    prefix,
+		Replace:   purge,
+	})
+	return err
+}
+
+// SemaphorePool is the semaphore pool of this element
+// unlike the embedded library it is never nil, claims report if the element has no pool
+func (p *PathElement) SemaphorePool() *SemaphorePool {
+	return &SemaphorePool{elem: p}
+}
+
+// Claim waits for slots of the pool to become free, for as long as the context allows
+func (p *SemaphorePool) Claim(ctx context.Context, slots int64) (claim *SemaphoreClaim, err error) {
+	client := p.elem.ns.client
+	granted, err := client.api.Claim(ctx, &apipb.ClaimRequest{
+		Namespace: p.elem.ns.name,
+		Path:      string(p.elem.path.ToPathString()),
+		Slots:     slots,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SemaphoreClaim{client: client, id: granted.Id, Slots: granted.Slots}, nil
+}
+
+// Return gives the claimed slots back to their pool
+func (c *SemaphoreClaim) Return() error {
+	ctx, cancel := c.client.call()
+	defer cancel()
+	_, err := c.client.api.ReturnClaim(ctx, &apipb.ReturnClaimRequest{Id: c.id})
+	return err
+}
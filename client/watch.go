@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/databeast/whatnot"
+	"github.com/databeast/whatnot/access"
+	"github.com/databeast/whatnot/apipb"
+)
+
+// events buffered for a subscription while earlier ones are consumed
+const watchBuffer = 64
+
+// WatchEvent describes an event on an element or optionally any of its children, as whatnot.WatchEvent does
+type WatchEvent struct {
+	elem    *PathElement
+	TS      time.Time
+	Change  whatnot.ChangeType
+	Note    string
+	Actor   access.Role // whoever caused the event, with only the name of their role known to the server
+	Version whatnot.Version
+}
+
+// OnElement is the element the event occurred on
+func (e WatchEvent) OnElement() *PathElement {
+	return e.elem
+}
+
+// ElementWatchSubscription streams the events of an element from the server
+type ElementWatchSubscription struct {
+	events chan WatchEvent
+	cancel context.CancelFunc
+
+	mu  *sync.Mutex
+	err error
+}
+
+// SubscribeToEvents watches the events of this element, and optionally every element beneath it
+// events occurring once it returns are not missed, unless the subscriber falls too far behind
+func (p *PathElement) SubscribeToEvents(prefix bool) (*ElementWatchSubscription, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := p.ns.client.api.Watch(ctx, &apipb.WatchRequest{
+		Namespace: p.ns.name,
+		Path:      string(p.path.ToPathString()),
+		Recursive: prefix,
+	})
+	if err == nil {
+		// the server sends its headers once it has subscribed
+		_, err = stream.Header()
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	sub := &ElementWatchSubscription{
+		events: make(chan WatchEvent, watchBuffer),
+		cancel: cancel,
+		mu:     &sync.Mutex{},
+	}
+	go func() {
+		defer close(sub.events)
+		for {
+			e, err := stream.Recv()
+			if err != nil {
+				if ctx.Err() == nil {
+					sub.mu.Lock()
+					sub.err = err
+					sub.mu.Unlock()
+				}
+				return
+			}
+			select {
+			case sub.events <- WatchEvent{
+				elem:    p.ns.element(whatnot.PathString(e.Path)),
+				TS:      e.Time.AsTime(),
+				Change:  whatnot.ParseChange(e.Change),
+				Note:    e.Note,
+				Actor:   access.Role{Name: e.Actor},
+				Version: decodeVersion(e.Version),
+			}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+// UnSubscribeFromEvents ends the subscription, closing its channel of events
+func (p *PathElement) UnSubscribeFromEvents(sub *ElementWatchSubscription) {
+	sub.cancel()
+}
+
+// Events returns the channel of events, closed once the subscription ends
+func (m *ElementWatchSubscription) Events() <-chan WatchEvent {
+	return m.events
+}
+
+// Err reports why the subscription ended, if it was not unsubscribed
+func (m *ElementWatchSubscription) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
@@ -0,0 +1,51 @@
+// Command whatnot-server runs whatnot as a standalone service, serving its HTTP and gRPC APIs
+// configure it with flags, a JSON file given with -config, or both
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/databeast/whatnot/server"
+)
+
+func main() {
+	cfg, err := server.ParseFlags(os.Args[0], os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	} else if err != nil {
+		log.Fatalf("configuring whatnot server: %s", err)
+	}
+	srv, err := server.New(cfg)
+	if err != nil {
+		log.Fatalf("starting whatnot server: %s", err)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-stop
+		log.Printf("shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("shutdown was not clean: %s", err)
+		}
+	}()
+
+	log.Printf("whatnot server listening on %s", cfg.Listen)
+	if cfg.GRPCListen != "" {
+		log.Printf("whatnot gRPC API listening on %s", cfg.GRPCListen)
+	}
+	if err = srv.ListenAndServe(); err != nil {
+		log.Fatalf("serving whatnot API: %s", err)
+	}
+	<-stopped
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/databeast/whatnot"
+	"github.com/databeast/whatnot/client"
+	"github.com/pkg/errors"
+)
+
+// listPaths prints every namespace, or the paths directly beneath an element, or with -r every path beneath it
+func listPaths(c *client.Client, args []string, stdout io.Writer, stderr io.Writer) (int, error) {
+	fs := subcommandFlags("ls", "[-r] [<namespace>[/<path>]]", stderr)
+	recursive := fs.Bool("r", false, "list every path beneath the element that has no children of its own")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage, nil
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return exitUsage, nil
+	}
+	if fs.NArg() == 0 {
+		names, err := c.Namespaces()
+		if err != nil {
+			return exitFailed, err
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintln(stdout, name)
+		}
+		return 0, nil
+	}
+
+	ns, elem, err := target(c, fs.Arg(0))
+	if err != nil {
+		return exitFailed, err
+	}
+	var paths []string
+	if *recursive && elem.Parent() == nil {
+		all, err := ns.FetchAllAbsolutePaths()
+		if err != nil {
+			return exitFailed, err
+		}
+		for _, path := range all {
+			paths = append(paths, string(path.ToPathString()))
+		}
+	} else if *recursive {
+		all, err := elem.FetchAllSubPaths()
+		if err != nil {
+			return exitFailed, err
+		}
+		for _, sub := range all {
+			paths = append(paths, string(append(elem.AbsolutePath(), sub...).ToPathString()))
+		}
+	} else {
+		children, err := elem.Children()
+		if err != nil {
+			return exitFailed, err
+		}
+		for _, child := range children {
+			paths = append(paths, string(child.AbsolutePath().ToPathString()))
+		}
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintln(stdout, path)
+	}
+	return 0, nil
+}
+
+// getValue prints the value of an element as JSON
+func getValue(c *client.Client, args []string, stdout io.Writer, stderr io.Writer) (int, error) {
+	fs := subcommandFlags("get", "<namespace>/<path>", stderr)
+	if parseArgs(fs, args, 1) != nil {
+		return exitUsage, nil
+	}
+	_, elem, err := target(c, fs.Arg(0))
+	if err != nil {
+		return exitFailed, err
+	}
+	value, err := elem.GetValue()
+	if err != nil {
+		return exitFailed, err
+	}
+	encoded, err := json.Marshal(value.Val)
+	if err != nil {
+		return exitFailed, err
+	}
+	fmt.Fprintln(stdout, string(encoded))
+	return 0, nil
+}
+
+// putValue sets the value of an element, creating it if needed
+// the value is parsed as JSON, so that numbers, objects and lists keep their types, anything else is kept as a string
+func putValue(c *client.Client, args []string, stdout io.Writer, stderr io.Writer) (int, error) {
+	fs := subcommandFlags("put", "<namespace>/<path> <value>", stderr)
+	if parseArgs(fs, args, 2) != nil {
+		return exitUsage, nil
+	}
+	var value interface{}
+	if json.Unmarshal([]byte(fs.Arg(1)), &value) != nil {
+		value = fs.Arg(1)
+	}
+	elem, err := createTarget(c, fs.Arg(0))
+	if err != nil {
+		return exitFailed, err
+	}
+	if err = elem.SetValue(whatnot.ElementValue{Val: value}); err != nil {
+		return exitFailed, err
+	}
+	return 0, nil
+}
+
+// removePath deletes an element and everything beneath it
+func removePath(c *client.Client, args []string, stdout io.Writer, stderr io.Writer) (int, error) {
+	fs := subcommandFlags("rm", "<namespace>/<path>", stderr)
+	if parseArgs(fs, args, 1) != nil {
+		return exitUsage, nil
+	}
+	_, elem, err := target(c, fs.Arg(0))
+	if err != nil {
+		return exitFailed, err
+	}
+	if elem.Parent() == nil {
+		return exitFailed, errors.New("the root of a namespace cannot be removed")
+	}
+	if err = elem.Delete(); err != nil {
+		return exitFailed, err
+	}
+	return 0, nil
+}
+
+// lockAndRun runs a command while holding a lease on an element, renewing it until the command exits
+// should the lease be lost, the command is killed rather than left running unprotected
+func lockAndRun(c *client.Client, args []string, stdout io.Writer, stderr io.Writer) (int, error) {
+	own, child := splitCommand(args)
+	fs := subcommandFlags("lock", "[-ttl 30s] [-prefix] <namespace>/<path> -- <command> [arguments]", stderr)
+	ttl := fs.Duration("ttl", time.Second*30, "lease ttl, renewed every third of it while the command runs")
+	prefix := fs.Bool("prefix", false, "also lock every element beneath this one")
+	if parseArgs(fs, own, 1) != nil {
+		return exitUsage, nil
+	}
+	if len(child) == 0 || *ttl <= 0 {
+		fs.Usage()
+		return exitUsage, nil
+	}
+	elem, err := createTarget(c, fs.Arg(0))
+	if err != nil {
+		return exitFailed, err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var lease *client.Lease
+	var release func()
+	if *prefix {
+		lease, release = elem.ContextLockPrefixWithLease(ctx, *ttl)
+	} else {
+		lease, release = elem.ContextLockWithLease(ctx, *ttl)
+	}
+	defer release()
+	if err = lease.Err(); err != nil {
+		return exitFailed, errors.Wrap(err, "lease was not granted")
+	}
+	stopRenewing, err := lease.KeepAlive()
+	if err != nil {
+		return exitFailed, errors.Wrap(err, "lease cannot be renewed")
+	}
+	defer stopRenewing()
+	return runChild(lease, child, stdout, stderr)
+}
+
+// semaphore creates semaphore pools, or runs a command while holding slots of one
+func semaphore(c *client.Client, args []string, stdout io.Writer, stderr io.Writer) (int, error) {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage, nil
+	}
+	switch args[0] {
+	case "create":
+		return createPool(c, args[1:], stderr)
+	case "run":
+		return claimAndRun(c, args[1:], stdout, stderr)
+	}
+	fmt.Fprintf(stderr, "unknown sem command %q\n", args[0])
+	return exitUsage, nil
+}
+
+func createPool(c *client.Client, args []string, stderr io.Writer) (int, error) {
+	fs := subcommandFlags("sem create", "-size <n> [-prefix] [-replace] <namespace>/<path>", stderr)
+	size := fs.Int64("size", 0, "number of slots in the pool")
+	prefix := fs.Bool("prefix", false, "share the pool with every element beneath this one")
+	replace := fs.Bool("replace", false, "discard any existing pool on the element")
+	if parseArgs(fs, args, 1) != nil {
+		return exitUsage, nil
+	}
+	if *size <= 0 {
+		fs.Usage()
+		return exitUsage, nil
+	}
+	elem, err := createTarget(c, fs.Arg(0))
+	if err != nil {
+		return exitFailed, err
+	}
+	if err = elem.CreateSemaphorePool(*prefix, *replace, whatnot.SemaphorePoolOpts{PoolSize: *size, Prefix: *prefix}); err != nil {
+		return exitFailed, err
+	}
+	return 0, nil
+}
+
+func claimAndRun(c *client.Client, args []string, stdout io.Writer, stderr io.Writer) (int, error) {
+	own, child := splitCommand(args)
+	fs := subcommandFlags("sem run", "[-slots 1] [-wait 0] <namespace>/<path> -- <command> [arguments]", stderr)
+	slots := fs.Int64("slots", 1, "number of slots to claim")
+	wait := fs.Duration("wait", 0, "how long to wait for free slots, forever if 0")
+	if parseArgs(fs, own, 1) != nil {
+		return exitUsage, nil
+	}
+	if len(child) == 0 {
+		fs.Usage()
+		return exitUsage, nil
+	}
+	_, elem, err := target(c, fs.Arg(0))
+	if err != nil {
+		return exitFailed, err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	claimCtx := ctx
+	if *wait > 0 {
+		var cancel context.CancelFunc
+		claimCtx, cancel = context.WithTimeout(ctx, *wait)
+		defer cancel()
+	}
+	claim, err := elem.SemaphorePool().Claim(claimCtx, *slots)
+	if err != nil {
+		return exitFailed, errors.Wrap(err, "slots were not claimed")
+	}
+	defer func() {
+		if err := claim.Return(); err != nil {
+			fmt.Fprintf(stderr, "whatnotctl sem run: returning claim: %s\n", err)
+		}
+	}()
+	return runChild(ctx, child, stdout, stderr)
+}
+
+// watchEvents prints the events of an element, one per line, until interrupted
+func watchEvents(c *client.Client, args []string, stdout io.Writer, stderr io.Writer) (int, error) {
+	fs := subcommandFlags("watch", "[-r] <namespace>[/<path>]", stderr)
+	recursive := fs.Bool("r", false, "also print the events of every element beneath this one")
+	if parseArgs(fs, args, 1) != nil {
+		return exitUsage, nil
+	}
+	_, elem, err := target(c, fs.Arg(0))
+	if err != nil {
+		return exitFailed, err
+	}
+	sub, err := elem.SubscribeToEvents(*recursive)
+	if err != nil {
+		return exitFailed, err
+	}
+	defer elem.UnSubscribeFromEvents(sub)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, nil
+		case e, ok := <-sub.Events():
+			if !ok {
+				return exitFailed, errors.Wrap(sub.Err(), "watch ended")
+			}
+			line := fmt.Sprintf("%s %-10s %s", e.TS.UTC().Format(time.RFC3339Nano), e.Change, e.OnElement().AbsolutePath().ToPathString())
+			if e.Actor.Name != "" {
+				line += " by " + e.Actor.Name
+			}
+			if e.Note != "" {
+				line += " " + e.Note
+			}
+			fmt.Fprintln(stdout, line)
+		}
+	}
+}
+
+// runChild runs a command until it exits or the context ends, returning its exit code
+// interrupts reach the command directly, as it shares the terminal's process group
+func runChild(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) (int, error) {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, stdout, stderr
+	err := cmd.Run()
+	if ctxErr := ctx.Err(); ctxErr != nil && err != nil {
+		return exitFailed, errors.Wrapf(ctxErr, "%s was stopped", args[0])
+	}
+	var exited *exec.ExitError
+	if errors.As(err, &exited) {
+		return exited.ExitCode(), nil
+	}
+	if err != nil {
+		return exitFailed, err
+	}
+	return 0, nil
+}
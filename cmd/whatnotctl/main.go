@@ -0,0 +1,184 @@
+// Command whatnotctl inspects and changes the namespaces of a running whatnot server, and holds its leases and
+// semaphore claims around other commands, for debugging and for shell scripts
+//
+//	whatnotctl [-server host:8421] <command> [flags] <namespace>/<path> ...
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/databeast/whatnot"
+	"github.com/databeast/whatnot/client"
+	"github.com/pkg/errors"
+)
+
+// defaultServer is used unless -server or WHATNOT_SERVER give another address
+const defaultServer = "localhost:8421"
+
+// exit codes beside those of the commands run under a lease or claim
+const (
+	exitFailed = 1
+	exitUsage  = 2
+)
+
+const usage = `usage: whatnotctl [-server host:port] <command> [flags] [arguments]
+
+commands:
+  ls [-r] [<namespace>[/<path>]]               list namespaces, or the paths beneath an element
+  get <namespace>/<path>                       print the value of an element as JSON
+  put <namespace>/<path> <value>               set the value of an element, parsed as JSON or else kept as a string
+  rm <namespace>/<path>                        delete an element and everything beneath it
+  lock [-ttl 30s] [-prefix] <namespace>/<path> -- <command> [arguments]
+                                               run a command while holding a lease on the element
+  watch [-r] <namespace>[/<path>]              print the events of an element until interrupted
+  sem create -size <n> [-prefix] [-replace] <namespace>/<path>
+                                               create a semaphore pool on the element
+  sem run [-slots 1] [-wait 0] <namespace>/<path> -- <command> [arguments]
+                                               run a command while holding slots of the element's pool
+`
+
+// dial connects to the server, replaced by tests with an in-process server
+var dial = func(target string) (*client.Client, error) {
+	return client.Dial(target)
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// command runs a subcommand with its arguments, returning the exit code
+type command func(c *client.Client, args []string, stdout io.Writer, stderr io.Writer) (int, error)
+
+var commands = map[string]command{
+	"ls":    listPaths,
+	"get":   getValue,
+	"put":   putValue,
+	"rm":    removePath,
+	"lock":  lockAndRun,
+	"watch": watchEvents,
+	"sem":   semaphore,
+}
+
+// run parses the global flags and runs the requested command, returning the process exit code
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("whatnotctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, usage) }
+	server := defaultServer
+	if env := os.Getenv("WHATNOT_SERVER"); env != "" {
+		server = env
+	}
+	fs.StringVar(&server, "server", server, "address of the whatnot server's gRPC API")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "whatnotctl: unknown command %q\n", fs.Arg(0))
+		fs.Usage()
+		return exitUsage
+	}
+
+	c, err := dial(server)
+	if err != nil {
+		fmt.Fprintf(stderr, "whatnotctl: %s\n", err)
+		return exitFailed
+	}
+	defer c.Close()
+	code, err := cmd(c, fs.Args()[1:], stdout, stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "whatnotctl %s: %s\n", fs.Arg(0), err)
+	}
+	return code
+}
+
+// parseTarget splits a <namespace>/<path> argument, the path is the namespace's root if none is given
+func parseTarget(arg string) (namespace string, path whatnot.PathString, err error) {
+	arg = strings.TrimPrefix(arg, "/")
+	split := strings.Index(arg, "/")
+	if split < 0 {
+		split = len(arg)
+	}
+	if split == 0 {
+		return "", "", errors.Errorf("%q does not name a namespace, expected <namespace>/<path>", arg)
+	}
+	return arg[:split], whatnot.PathString("/" + strings.Trim(arg[split:], "/")), nil
+}
+
+// target resolves a <namespace>/<path> argument to an existing element, or to the root of the namespace
+func target(c *client.Client, arg string) (*client.Namespace, *client.PathElement, error) {
+	name, path, err := parseTarget(arg)
+	if err != nil {
+		return nil, nil, err
+	}
+	ns, err := c.FetchNamespace(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if path == "/" {
+		return ns, ns.Root(), nil
+	}
+	elem, err := ns.FetchAbsolutePath(path)
+	if err == nil && elem == nil {
+		err = errors.Errorf("no such path %s in namespace %s", path, name)
+	}
+	return ns, elem, err
+}
+
+// createTarget resolves a <namespace>/<path> argument to an element, creating it if it does not exist
+func createTarget(c *client.Client, arg string) (*client.PathElement, error) {
+	name, path, err := parseTarget(arg)
+	if err != nil {
+		return nil, err
+	}
+	ns, err := c.FetchNamespace(name)
+	if err != nil {
+		return nil, err
+	}
+	return ns.FetchOrCreateAbsolutePath(path)
+}
+
+// splitCommand separates the arguments of a command run under a lease or claim, given after --
+func splitCommand(args []string) (own []string, child []string) {
+	for i, arg := range args {
+		if arg == "--" {
+			return args[:i], args[i+1:]
+		}
+	}
+	return args, nil
+}
+
+// subcommandFlags creates the flag set of a command, with its usage line printed on errors
+func subcommandFlags(name string, synopsis string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: whatnotctl %s %s\n", name, synopsis)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses a command's flags, requiring exactly the given number of positional arguments
+// usage has already been printed when it fails
+func parseArgs(fs *flag.FlagSet, args []string, positional int) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != positional {
+		fs.Usage()
+		return errors.Errorf("%d arguments are required", positional)
+	}
+	return nil
+}
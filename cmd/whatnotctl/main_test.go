@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/databeast/whatnot/client"
+	"github.com/databeast/whatnot/server"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestCommands(t *testing.T) {
+	t.Run("Values are put, read, listed and removed", valuesPutAndListed)
+	t.Run("Lock holds a lease while its command runs", lockHoldsLease)
+	t.Run("Sem run holds a claim while its command runs", semHoldsClaim)
+	t.Run("Invalid arguments print usage", invalidArgumentsPrintUsage)
+}
+
+// createTestServer points dial at an in-process server over an in-memory listener
+func createTestServer(t *testing.T) *server.Server {
+	cfg := server.DefaultConfig()
+	cfg.Namespaces = []string{"testing"}
+	srv, err := server.New(cfg)
+	if !assert.Nil(t, err, "creating server failed") {
+		t.FailNow()
+	}
+	lis := bufconn.Listen(1024 * 1024)
+	go func() { _ = srv.ServeGRPC(lis) }()
+
+	dial = func(string) (*client.Client, error) {
+		return client.Dial("bufnet",
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	})
+	return srv
+}
+
+// ctl runs whatnotctl, returning its exit code and output
+func ctl(args ...string) (code int, stdout string, stderr string) {
+	var out, errs bytes.Buffer
+	code = run(args, &out, &errs)
+	return code, out.String(), errs.String()
+}
+
+func valuesPutAndListed(t *testing.T) {
+	createTestServer(t)
+
+	code, _, stderr := ctl("put", "testing/config/limits", `{"max": 10}`)
+	assert.Equal(t, 0, code, stderr)
+	ctl("put", "testing/config/name", "plain words")
+	ctl("put", "testing/hosts/a", "1")
+
+	_, stdout, _ := ctl("get", "testing/config/limits")
+	assert.Equal(t, `{"max":10}`+"\n", stdout)
+	_, stdout, _ = ctl("get", "testing/config/name")
+	assert.Equal(t, `"plain words"`+"\n", stdout, "non-JSON value was not kept as a string")
+
+	_, stdout, _ = ctl("ls")
+	assert.Equal(t, "testing\n", stdout)
+	_, stdout, _ = ctl("ls", "testing")
+	assert.Equal(t, "/config\n/hosts\n", stdout)
+	_, stdout, _ = ctl("ls", "-r", "testing")
+	assert.Equal(t, "/config/limits\n/config/name\n/hosts/a\n", stdout)
+	_, stdout, _ = ctl("ls", "-r", "testing/config")
+	assert.Equal(t, "/config/limits\n/config/name\n", stdout)
+
+	code, _, stderr = ctl("rm", "testing/config")
+	assert.Equal(t, 0, code, stderr)
+	code, _, stderr = ctl("get", "testing/config/limits")
+	assert.Equal(t, exitFailed, code, "removed value was read")
+	assert.Contains(t, stderr, "no such path")
+}
+
+func lockHoldsLease(t *testing.T) {
+	srv := createTestServer(t)
+
+	code, _, stderr := ctl("lock", "-ttl", "5s", "testing/jobs/nightly", "--", "sh", "-c", "exit 3")
+	assert.Equal(t, 3, code, "command's exit code was not kept: "+stderr)
+
+	// the lease was released with the command, rather than left to expire
+	ns, _ := srv.Manager().FetchNamespace("testing")
+	lease, release := ns.FetchAbsolutePath("/jobs/nightly").LockWithLease(time.Minute)
+	defer release()
+	if !assert.Nil(t, lease.Err(), "lease was not granted") {
+		return
+	}
+
+	held := make(chan int)
+	go func() {
+		code, _, _ := ctl("lock", "testing/jobs/nightly", "--", "true")
+		held <- code
+	}()
+	select {
+	case <-held:
+		t.Error("command was run without holding the lease")
+		return
+	case <-time.After(time.Millisecond * 200):
+	}
+	release()
+	select {
+	case code := <-held:
+		assert.Equal(t, 0, code)
+	case <-time.After(time.Second * 5):
+		t.Error("command was not run once the lease was free")
+	}
+}
+
+func semHoldsClaim(t *testing.T) {
+	srv := createTestServer(t)
+
+	code, _, stderr := ctl("sem", "create", "-size", "1", "testing/workers")
+	assert.Equal(t, 0, code, stderr)
+	code, stdout, stderr := ctl("sem", "run", "testing/workers", "--", "echo", "working")
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, "working\n", stdout)
+
+	// the claim was returned with the command
+	ns, _ := srv.Manager().FetchNamespace("testing")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	claim, err := ns.FetchAbsolutePath("/workers").SemaphorePool().Claim(ctx, 1)
+	if !assert.Nil(t, err, "claim was not returned") {
+		return
+	}
+	code, _, stderr = ctl("sem", "run", "-wait", "100ms", "testing/workers", "--", "true")
+	assert.Equal(t, exitFailed, code, "command was run without a free slot")
+	assert.Contains(t, stderr, "slots were not claimed")
+	assert.Nil(t, claim.Return())
+}
+
+func invalidArgumentsPrintUsage(t *testing.T) {
+	createTestServer(t)
+
+	for _, args := range [][]string{
+		{},
+		{"frobnicate"},
+		{"get"},
+		{"lock", "testing/path"},
+		{"sem", "create", "testing/path"},
+	} {
+		code, _, stderr := ctl(args...)
+		assert.Equal(t, exitUsage, code, "%v was accepted", args)
+		assert.True(t, strings.Contains(stderr, "usage:"), "%v did not print usage", args)
+	}
+	code, _, _ := ctl("get", "missing/path")
+	assert.Equal(t, exitFailed, code, "unknown namespace was read")
+}
@@ -0,0 +1,104 @@
+package whatnot
+
+/*
+Conflict resolution for values written concurrently on different instances.
+Every replicated value carries the version it replaced where it was written. If that is not the version we hold
+ourselves, the writer had not yet seen our value, and the Namespace's ConflictPolicy decides which value is kept.
+Every policy resolves the same pair of values identically, so every instance settles on the same result.
+*/
+
+import (
+	"bytes"
+
+	"github.com/databeast/whatnot/access"
+)
+
+// VersionedValue is an element value along with the Version it was written at
+type VersionedValue struct {
+	Value   ElementValue
+	Version Version
+}
+
+// ConflictPolicy decides the value an element keeps when two writes were made concurrently
+type ConflictPolicy interface {
+	resolve(local VersionedValue, remote VersionedValue) VersionedValue
+}
+
+type lastWriterWins struct{}
+
+// LastWriterWins keeps the value with the later Version, this is the default policy
+var LastWriterWins ConflictPolicy = lastWriterWins{}
+
+func (lastWriterWins) resolve(local VersionedValue, remote VersionedValue) VersionedValue {
+	if local.Version.Before(remote.Version) {
+		return remote
+	}
+	return local
+}
+
+type rejectConflicts struct{}
+
+// RejectConflicts keeps the value with the earlier Version, rejecting any write made without seeing it
+var RejectConflicts ConflictPolicy = rejectConflicts{}
+
+func (rejectConflicts) resolve(local VersionedValue, remote VersionedValue) VersionedValue {
+	if remote.Version.Before(local.Version) {
+		return remote
+	}
+	return local
+}
+
+// MergeValues combines concurrently written values with a custom function
+// it is always called with the earlier version first, so every instance merges them identically
+// the merged value takes the later of the two versions
+type MergeValues func(earlier VersionedValue, later VersionedValue) ElementValue
+
+func (f MergeValues) resolve(local VersionedValue, remote VersionedValue) VersionedValue {
+	earlier, later := local, remote
+	if remote.Version.Before(local.Version) {
+		earlier, later = remote, local
+	}
+	return VersionedValue{Value: f(earlier, later), Version: later.Version}
+}
+
+// SetConflictPolicy sets how this Namespace resolves values written concurrently on different instances
+func (ns *Namespace) SetConflictPolicy(policy ConflictPolicy) {
+	ns.policy.Store(conflictPolicy{policy})
+}
+
+// conflictPolicy wraps the policy, as atomic.Value requires a consistent concrete type
+type conflictPolicy struct {
+	ConflictPolicy
+}
+
+func (ns *Namespace) conflictPolicy() ConflictPolicy {
+	if p, ok := ns.policy.Load().(conflictPolicy); ok && p.ConflictPolicy != nil {
+		return p.ConflictPolicy
+	}
+	return LastWriterWins
+}
+
+// applyRemoteValue stores a value replicated from a peer, resolving it against our own if they were written concurrently
+func (p *PathElement) applyRemoteValue(remote VersionedValue, previous Version, change changeType) {
+	p.clock().observe(remote.Version)
+
+	p.mu.Lock()
+	local := VersionedValue{Value: p.resval, Version: p.version}
+	if remote.Version == local.Version {
+		p.mu.Unlock()
+		return // already applied
+	}
+	winner, note := remote, ""
+	if previous != local.Version && !local.Version.IsZero() {
+		winner = p.namespace.conflictPolicy().resolve(local, remote)
+		note = "resolved concurrent write"
+	}
+	p.resval, p.version = winner.Value, winner.Version
+	p.mu.Unlock()
+	p.changedDigest()
+
+	if winner.Version == local.Version && bytes.Equal(encodeValue(winner.Value.Val), encodeValue(local.Value.Val)) {
+		return // we kept our own value
+	}
+	p.parentnotify <- elementChange{id: randid.Uint64(), elem: p, change: change, actor: access.Role{}, note: note, version: winner.Version}
+}
@@ -0,0 +1,131 @@
+package whatnot
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/databeast/whatnot/access"
+	"github.com/databeast/whatnot/peerpb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConflictResolution(t *testing.T) {
+	t.Run("Last writer wins by default", lastWriterWinsByDefault)
+	t.Run("Reject keeps the earliest write", rejectKeepsEarliestWrite)
+	t.Run("Merge combines concurrent writes", mergeCombinesWrites)
+	t.Run("Sequential writes are not conflicts", sequentialWritesAreNotConflicts)
+	t.Run("Watch events carry the winning version", watchEventCarriesVersion)
+}
+
+// createConflictingPair creates two peers sharing a path, using the given policy
+func createConflictingPair(t *testing.T, policy ConflictPolicy) (managers []*NameSpaceManager, elems []*PathElement) {
+	managers, namespaces := createTestCluster(t, 2)
+	for _, ns := range namespaces {
+		if policy != nil {
+			ns.SetConflictPolicy(policy)
+		}
+	}
+	_ = namespaces[0].RegisterAbsolutePath(PathString("/conflict/value").ToAbsolutePath())
+	assert.Eventually(t, func() bool {
+		return namespaces[1].FetchAbsolutePath("/conflict/value") != nil
+	}, peerSyncTimeout, time.Millisecond*10, "path was not replicated")
+	return managers, []*PathElement{namespaces[0].FetchAbsolutePath("/conflict/value"), namespaces[1].FetchAbsolutePath("/conflict/value")}
+}
+
+// writeConcurrently sets a value on each peer before either has seen the other's, then delivers them crosswise
+func writeConcurrently(t *testing.T, managers []*NameSpaceManager, elems []*PathElement, values ...string) (versions []Version) {
+	var mutations []*peerpb.Mutation
+	for i, elem := range elems {
+		version := elem.clock().now()
+		previous := elem.setValue(ElementValue{Val: values[i]}, ChangeEdited, access.Role{}, version)
+		encoded, _ := json.Marshal(values[i])
+		mutations = append(mutations, &peerpb.Mutation{
+			Origin:    fmt.Sprintf("node%d", i),
+			Namespace: testNameSpace,
+			Path:      elem.AbsolutePath().toStrings(),
+			Op:        peerpb.Operation_OPERATION_SET_VALUE,
+			Value:     encoded,
+			Change:    int32(ChangeEdited),
+			Version:   version.toProto(),
+			Previous:  previous.toProto(),
+		})
+		versions = append(versions, version)
+	}
+	assert.Nil(t, managers[1].peering.apply(mutations[0]), "applying first write failed")
+	assert.Nil(t, managers[0].peering.apply(mutations[1]), "applying second write failed")
+	return versions
+}
+
+func lastWriterWinsByDefault(t *testing.T) {
+	managers, elems := createConflictingPair(t, nil)
+	versions := writeConcurrently(t, managers, elems, "first", "second")
+
+	for i, elem := range elems {
+		held := elem.GetVersionedValue()
+		assert.Equal(t, "second", held.Value.Val, "peer %d did not keep the last write", i)
+		assert.Equal(t, versions[1], held.Version, "peer %d holds the wrong version", i)
+	}
+}
+
+func rejectKeepsEarliestWrite(t *testing.T) {
+	managers, elems := createConflictingPair(t, RejectConflicts)
+	versions := writeConcurrently(t, managers, elems, "first", "second")
+
+	for i, elem := range elems {
+		held := elem.GetVersionedValue()
+		assert.Equal(t, "first", held.Value.Val, "peer %d did not reject the later write", i)
+		assert.Equal(t, versions[0], held.Version, "peer %d holds the wrong version", i)
+	}
+}
+
+func mergeCombinesWrites(t *testing.T) {
+	merge := MergeValues(func(earlier VersionedValue, later VersionedValue) ElementValue {
+		return ElementValue{Val: fmt.Sprintf("%v+%v", earlier.Value.Val, later.Value.Val)}
+	})
+	managers, elems := createConflictingPair(t, merge)
+	versions := writeConcurrently(t, managers, elems, "first", "second")
+
+	for i, elem := range elems {
+		held := elem.GetVersionedValue()
+		assert.Equal(t, "first+second", held.Value.Val, "peer %d did not merge the writes in order", i)
+		assert.Equal(t, versions[1], held.Version, "peer %d holds the wrong version", i)
+	}
+}
+
+func sequentialWritesAreNotConflicts(t *testing.T) {
+	_, elems := createConflictingPair(t, RejectConflicts)
+
+	elems[0].SetValue(ElementValue{Val: "first"}, ChangeEdited, access.Role{})
+	assert.Eventually(t, func() bool {
+		return elems[1].GetValue().Val == "first"
+	}, peerSyncTimeout, time.Millisecond*10, "first write was not replicated")
+
+	elems[1].SetValue(ElementValue{Val: "second"}, ChangeEdited, access.Role{})
+	assert.Eventually(t, func() bool {
+		return elems[0].GetValue().Val == "second"
+	}, peerSyncTimeout, time.Millisecond*10, "write made after seeing the first was rejected")
+}
+
+func watchEventCarriesVersion(t *testing.T) {
+	_, elems := createConflictingPair(t, nil)
+	sub := elems[1].Parent().SubscribeToEvents(true)
+
+	elems[0].SetValue(ElementValue{Val: "versioned"}, ChangeEdited, access.Role{})
+	winner := elems[0].GetVersionedValue().Version
+
+	timeout := time.After(peerSyncTimeout)
+	for {
+		select {
+		case e := <-sub.Events():
+			if e.Change == ChangeEdited && e.OnElement() == elems[1] {
+				assert.Equal(t, winner, e.Version, "watch event did not carry the winning version")
+				return
+			}
+		case <-timeout:
+			t.Error("no watch event was received for the replicated value")
+			return
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package whatnot
+
+/*
+Leases that wait for each other's elements deadlock, as when one lease holds /x and waits for /y while another
+holds /y and waits for /x. Each namespace keeps a wait-for graph of the leases waiting on its elements, and looks
+for a cycle through a lease whenever it has to wait. Cycles are always logged, and with WithDeadlockBreak the
+youngest waiter in the cycle is failed with a DeadlockError, letting the others go ahead
+*/
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DeadlockError is returned to the lease that was failed to break a deadlock
+type DeadlockError struct {
+	Path  PathString // the element the failed lease was waiting for
+	Cycle []LeaseID  // the leases that were waiting on each other, starting with the one that was failed
+}
+
+func (e *DeadlockError) Error() string {
+	leases := make([]string, len(e.Cycle))
+	for i, id := range e.Cycle {
+		leases[i] = id.String()
+	}
+	return fmt.Sprintf("deadlock broken waiting for %s: leases %s were waiting on each other", e.Path, strings.Join(leases, " -> "))
+}
+
+// waitGraph is every lease waiting for an element of a namespace, and where it is waiting
+type waitGraph struct {
+	ns      *Namespace
+	mu      *sync.Mutex
+	waiting map[LeaseID]map[*lockWaiter]*resourceLock
+}
+
+func newWaitGraph(ns *Namespace) *waitGraph {
+	return &waitGraph{
+		ns:      ns,
+		mu:      &sync.Mutex{},
+		waiting: make(map[LeaseID]map[*lockWaiter]*resourceLock),
+	}
+}
+
+// breaking reports if deadlocks are broken rather than only logged
+func (g *waitGraph) breaking() bool {
+	return g.ns.manager != nil && g.ns.manager.breakDeadlocks
+}
+
+// check records that the waiter is blocked on the lock, then breaks any cycle of leases it completes
+// the caller must not hold any element's selfmu, as the check visits those of every lock in the graph
+func (g *waitGraph) check(r *resourceLock, w *lockWaiter) {
+	if g == nil || w.req.Lease == 0 {
+		return
+	}
+	g.mu.Lock()
+	waiters := g.waiting[w.req.Lease]
+	if waiters == nil {
+		waiters = make(map[*lockWaiter]*resourceLock)
+		g.waiting[w.req.Lease] = waiters
+	}
+	waiters[w] = r
+	cycle := g.findCycle(w)
+	g.mu.Unlock()
+	if cycle == nil {
+		return
+	}
+
+	// the youngest waiter has lost the least by giving up
+	victim := cycle[0]
+	for _, waiter := range cycle[1:] {
+		if waiter.req.Since.After(victim.req.Since) {
+			victim = waiter
+		}
+	}
+	err := &DeadlockError{Path: victim.req.Path}
+	start := 0
+	for i, waiter := range cycle {
+		if waiter == victim {
+			start = i
+		}
+	}
+	for i := range cycle {
+		err.Cycle = append(err.Cycle, cycle[(start+i)%len(cycle)].req.Lease)
+	}
+
+	if !g.breaking() {
+		if !w.reported {
+			w.reported = true
+			g.ns.root.Warnf("%s", err.Error())
+		}
+		return
+	}
+	g.ns.root.Warnf("%s", err.Error())
+	g.mu.Lock()
+	held := g.waiting[victim.req.Lease][victim]
+	g.mu.Unlock()
+	if held != nil {
+		held.selfmu.Lock()
+		victim.broken = err
+		held.wake()
+		held.selfmu.Unlock()
+	}
+}
+
+// forget removes a waiter that is no longer blocked
+func (g *waitGraph) forget(w *lockWaiter) {
+	if g == nil || w.req.Lease == 0 {
+		return
+	}
+	g.mu.Lock()
+	delete(g.waiting[w.req.Lease], w)
+	if len(g.waiting[w.req.Lease]) == 0 {
+		delete(g.waiting, w.req.Lease)
+	}
+	g.mu.Unlock()
+}
+
+// findCycle searches the leases the waiter is blocked by, and those they are blocked by in turn, for a way back
+// to the waiter's own lease, returning the waiters along it. The caller must hold g.mu
+func (g *waitGraph) findCycle(w *lockWaiter) []*lockWaiter {
+	start := w.req.Lease
+	visited := make(map[LeaseID]bool)
+	var path []*lockWaiter
+	var search func(waiter *lockWaiter, r *resourceLock) bool
+	search = func(waiter *lockWaiter, r *resourceLock) bool {
+		path = append(path, waiter)
+		for _, blocker := range r.blockers(waiter) {
+			if blocker == start {
+				return true
+			}
+			if visited[blocker] {
+				continue
+			}
+			visited[blocker] = true
+			for next, nextLock := range g.waiting[blocker] {
+				if search(next, nextLock) {
+					return true
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	if search(w, g.waiting[start][w]) {
+		return path
+	}
+	return nil
+}
+
+// blockers are the other leases holding, or queued ahead for, a mode the waiter conflicts with
+func (r *resourceLock) blockers(w *lockWaiter) (leases []LeaseID) {
+	r.selfmu.Lock()
+	defer r.selfmu.Unlock()
+	conflicts := func(modes []lockMode) bool {
+		for _, m := range w.modes {
+			for _, other := range modes {
+				if !lockCompatible[m][other] {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	for owner, held := range r.owners {
+		if owner.lease == 0 || owner.lease == w.req.Lease {
+			continue // holds without a lease are not in the wait graph
+		}
+		var modes []lockMode
+		for m, count := range held {
+			if count > 0 {
+				modes = append(modes, lockMode(m))
+			}
+		}
+		if conflicts(modes) {
+			leases = append(leases, owner.lease)
+		}
+	}
+	for _, queued := range r.queue {
+		if queued == w {
+			break
+		}
+		if queued.req.Lease != 0 && queued.req.Lease != w.req.Lease && conflicts(queued.modes) && !r.overtakes(w, queued) {
+			leases = append(leases, queued.req.Lease)
+		}
+	}
+	return leases
+}
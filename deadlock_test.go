@@ -0,0 +1,146 @@
+package whatnot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadlockDetection(t *testing.T) {
+	t.Run("Leases waiting on each other are broken", crossedLeasesBroken)
+	t.Run("Leases queued behind each other are broken", queuedLeasesBroken)
+	t.Run("Deadlocks are only logged unless breaking is enabled", deadlocksLoggedWithoutBreak)
+}
+
+func createDeadlockBreakingNamespace(t *testing.T) *Namespace {
+	manager, err := NewNamespaceManager(WithDeadlockBreak, WithLogger{createTestLogger(t)})
+	if !assert.Nil(t, err, "NewNamespaceManager returned error") {
+		t.FailNow()
+	}
+	gns := NewNamespace(testNameSpace)
+	if !assert.Nil(t, manager.RegisterNamespace(gns), "RegisterNamespace returned error") {
+		t.FailNow()
+	}
+	return gns
+}
+
+// attachLater attaches the element to the lease in the background, reporting the outcome once it has one
+func attachLater(lease *LeaseContext, p *PathElement) chan error {
+	result := make(chan error, 1)
+	go func() { result <- lease.Attach(p) }()
+	return result
+}
+
+func crossedLeasesBroken(t *testing.T) {
+	gns := createDeadlockBreakingNamespace(t)
+	x, _ := gns.FetchOrCreateAbsolutePath("/deadlock/x")
+	y, _ := gns.FetchOrCreateAbsolutePath("/deadlock/y")
+
+	older, releaseOlder := gns.GrantLease(time.Minute)
+	defer releaseOlder()
+	younger, releaseYounger := gns.GrantLease(time.Minute)
+	defer releaseYounger()
+	assert.Nil(t, older.Attach(x))
+	assert.Nil(t, younger.Attach(y))
+
+	olderWaits := attachLater(older, y)
+	assert.Eventually(t, func() bool { return len(y.LockWaiters()) == 1 }, time.Second, time.Millisecond*5, "older lease did not start waiting")
+
+	err := younger.Attach(x)
+	var deadlock *DeadlockError
+	if assert.True(t, errors.As(err, &deadlock), "younger lease was not failed with a DeadlockError, got %v", err) {
+		assert.Equal(t, PathString("/deadlock/x"), deadlock.Path)
+		assert.Equal(t, []LeaseID{younger.ID(), older.ID()}, deadlock.Cycle)
+	}
+	select {
+	case err := <-olderWaits:
+		t.Errorf("older lease stopped waiting with %v, rather than the younger one giving up", err)
+		return
+	default:
+	}
+
+	releaseYounger()
+	select {
+	case err := <-olderWaits:
+		assert.Nil(t, err, "older lease was not given the element once the deadlock was broken")
+	case <-time.After(time.Second * 2):
+		t.Error("older lease was never given the element")
+	}
+}
+
+func queuedLeasesBroken(t *testing.T) {
+	gns := createDeadlockBreakingNamespace(t)
+	e, _ := gns.FetchOrCreateAbsolutePath("/deadlock/queued/e")
+	g, _ := gns.FetchOrCreateAbsolutePath("/deadlock/queued/g")
+
+	first, releaseFirst := gns.GrantLease(time.Minute)
+	defer releaseFirst()
+	second, releaseSecond := gns.GrantLease(time.Minute)
+	defer releaseSecond()
+	third, releaseThird := gns.GrantLease(time.Minute)
+	defer releaseThird()
+	assert.Nil(t, first.Attach(g))
+	assert.Nil(t, third.AttachShared(e))
+
+	// the second lease waits for the third to stop sharing e, and the third waits for the first to give up g
+	secondWaits := attachLater(second, e)
+	assert.Eventually(t, func() bool { return len(e.LockWaiters()) == 1 }, time.Second, time.Millisecond*5, "second lease did not start waiting")
+	thirdWaits := attachLater(third, g)
+	assert.Eventually(t, func() bool { return len(g.LockWaiters()) == 1 }, time.Second, time.Millisecond*5, "third lease did not start waiting")
+
+	// sharing e alongside the third lease would be allowed, but for queueing behind the second
+	err := first.AttachShared(e)
+	var deadlock *DeadlockError
+	if assert.True(t, errors.As(err, &deadlock), "lease queued behind a lease waiting for it was not failed, got %v", err) {
+		assert.Equal(t, []LeaseID{first.ID(), second.ID(), third.ID()}, deadlock.Cycle)
+	}
+
+	releaseFirst()
+	select {
+	case err := <-thirdWaits:
+		assert.Nil(t, err, "third lease was not given g once the first gave up")
+	case <-time.After(time.Second * 2):
+		t.Error("third lease was never given g")
+		return
+	}
+	releaseThird()
+	select {
+	case err := <-secondWaits:
+		assert.Nil(t, err, "second lease was not given e once the third was released")
+	case <-time.After(time.Second * 2):
+		t.Error("second lease was never given e")
+	}
+}
+
+func deadlocksLoggedWithoutBreak(t *testing.T) {
+	gns := createTestNamespace(t)
+	x, _ := gns.FetchOrCreateAbsolutePath("/deadlock/logged/x")
+	y, _ := gns.FetchOrCreateAbsolutePath("/deadlock/logged/y")
+
+	older, releaseOlder := gns.GrantLease(time.Minute)
+	defer releaseOlder()
+	younger, releaseYounger := gns.GrantLease(time.Minute)
+	assert.Nil(t, older.Attach(x))
+	assert.Nil(t, younger.Attach(y))
+
+	olderWaits := attachLater(older, y)
+	assert.Eventually(t, func() bool { return len(y.LockWaiters()) == 1 }, time.Second, time.Millisecond*5, "older lease did not start waiting")
+	youngerWaits := attachLater(younger, x)
+	select {
+	case err := <-youngerWaits:
+		t.Errorf("deadlocked lease was failed with %v without breaking enabled", err)
+	case err := <-olderWaits:
+		t.Errorf("deadlocked lease was failed with %v without breaking enabled", err)
+	case <-time.After(time.Millisecond * 300):
+	}
+
+	releaseYounger()
+	select {
+	case err := <-olderWaits:
+		assert.Nil(t, err, "older lease was not given the element once the younger one was released")
+	case <-time.After(time.Second * 2):
+		t.Error("older lease was never given the element")
+	}
+}
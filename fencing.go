@@ -0,0 +1,101 @@
+package whatnot
+
+/*
+Fencing tokens let systems outside whatnot reject work from a lease holder that no longer holds its lease.
+A holder whose lease expired while it was paused or partitioned carries on with an old token, which no longer
+validates, while the holder that took the lease after it has a larger one
+*/
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ErrStaleFencingToken is returned when validating a token that no current lease on the element holds
+var ErrStaleFencingToken = errors.New("fencing token is not held by a current lease")
+
+// FencingToken is issued with every lease on an element, from a count kept for the whole namespace, so each one is
+// larger than any issued in the namespace before it and no two holders anywhere in the namespace share one
+// with Raft enabled the cluster issues them, so they increase across every instance rather than only this one
+type FencingToken uint64
+
+// nextFence issues the next token from the namespace's count, or the element's own for one outside a namespace
+// a token issued by the cluster is used if it is larger than any issued before, as it always should be
+func (p *PathElement) nextFence(granted FencingToken) FencingToken {
+	if ns := p.namespace; ns != nil {
+		ns.fencemu.Lock()
+		defer ns.fencemu.Unlock()
+		return countFence(&ns.fence, granted)
+	}
+	p.reslock.selfmu.Lock()
+	defer p.reslock.selfmu.Unlock()
+	return countFence(&p.reslock.fence, granted)
+}
+
+// countFence moves the last token issued on to the next, the caller must hold whatever guards it
+func countFence(last *FencingToken, granted FencingToken) FencingToken {
+	if granted > *last {
+		*last = granted
+	} else {
+		*last++
+	}
+	return *last
+}
+
+// issueFence records a new holder of the element with the token it was issued
+func (r *resourceLock) issueFence(token FencingToken, holder LockHolder) LockHolder {
+	r.selfmu.Lock()
+	defer r.selfmu.Unlock()
+	holder.Token = token
+	r.holders[token] = holder
+	return holder
+}
+
+// retireFence forgets a holder once its lease has finished
+func (r *resourceLock) retireFence(token FencingToken) {
+	r.selfmu.Lock()
+	delete(r.holders, token)
+	r.selfmu.Unlock()
+}
+
+// holdsFence returns the current holder the token was issued to, if any
+func (r *resourceLock) holdsFence(token FencingToken) (holder LockHolder, held bool) {
+	r.selfmu.Lock()
+	defer r.selfmu.Unlock()
+	holder, held = r.holders[token]
+	return holder, held
+}
+
+// ValidateFencingToken returns ErrStaleFencingToken unless the token belongs to a lease that still holds this element,
+// either a lease on the element itself or a prefix lease on an element above it
+// a storage layer checks the token it was given with a write, rejecting writes from holders whose leases have ended
+func (p *PathElement) ValidateFencingToken(token FencingToken) error {
+	if _, held := p.reslock.holdsFence(token); held {
+		return nil
+	}
+	for a := p.parent; a != nil; a = a.parent {
+		if holder, held := a.reslock.holdsFence(token); held && holder.Prefix {
+			return nil
+		}
+	}
+	return ErrStaleFencingToken
+}
+
+// FencingToken is the token the lease was issued for the element it was granted on, or the first element attached
+// to it, zero if it holds no elements
+func (l *LeaseContext) FencingToken() FencingToken {
+	held := l.held()
+	if len(held) == 0 {
+		return 0
+	}
+	return held[0].fence
+}
+
+// FencingTokenFor is the token the lease was issued for one of the elements it holds
+func (l *LeaseContext) FencingTokenFor(p *PathElement) (token FencingToken, ok bool) {
+	for _, a := range l.held() {
+		if a.elem == p {
+			return a.fence, true
+		}
+	}
+	return 0, false
+}
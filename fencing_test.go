@@ -0,0 +1,101 @@
+package whatnot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFencingTokens(t *testing.T) {
+	t.Run("Every lease on an element is issued a larger token", fencingTokensIncrease)
+	t.Run("Tokens of expired leases are stale", expiredFencingTokenIsStale)
+	t.Run("Prefix lease tokens are valid beneath the prefix", prefixFencingTokenCoversSubtree)
+	t.Run("Shared leases each hold their own token", sharedLeasesHoldOwnTokens)
+	t.Run("Stale tokens are not taken for a prefix lease's", staleTokensNotTakenForPrefix)
+}
+
+func fencingTokensIncrease(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/fencing/increasing")
+
+	var last FencingToken
+	for i := 0; i < 3; i++ {
+		lease, release := elem.LockWithLease(time.Minute)
+		token := lease.FencingToken()
+		assert.True(t, token > last, "token %d was not larger than the last one issued %d", token, last)
+		assert.Nil(t, elem.ValidateFencingToken(token), "token of the current holder was not valid")
+		release()
+		assert.Eventually(t, func() bool { return !elem.reslock.isLocked() }, time.Second, time.Millisecond*10, "lease was not released")
+		last = token
+	}
+	assert.Equal(t, ErrStaleFencingToken, elem.ValidateFencingToken(last), "token of a released lease was still valid")
+}
+
+func expiredFencingTokenIsStale(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/fencing/expiring")
+
+	old, _ := elem.LockWithLease(time.Millisecond * 100)
+	stale := old.FencingToken()
+	<-old.Done()
+
+	current, release := elem.LockWithLease(time.Minute)
+	defer release()
+	if !assert.Nil(t, current.Err(), "lease was not granted once the previous one expired") {
+		return
+	}
+	assert.Equal(t, ErrStaleFencingToken, elem.ValidateFencingToken(stale), "token of an expired lease was still valid")
+	assert.Nil(t, elem.ValidateFencingToken(current.FencingToken()), "token of the current holder was not valid")
+}
+
+func prefixFencingTokenCoversSubtree(t *testing.T) {
+	gns := createTestNamespace(t)
+	prefix, _ := gns.FetchOrCreateAbsolutePath("/fencing/prefix")
+	child, _ := gns.FetchOrCreateAbsolutePath("/fencing/prefix/child")
+	sibling, _ := gns.FetchOrCreateAbsolutePath("/fencing/sibling")
+
+	lease, release := prefix.LockPrefixWithLease(time.Minute)
+	defer release()
+	token, ok := lease.FencingTokenFor(prefix)
+	assert.True(t, ok, "lease did not report a token for its element")
+	assert.Equal(t, token, lease.FencingToken())
+	_, ok = lease.FencingTokenFor(child)
+	assert.False(t, ok, "lease reported a token for an element it was not granted on")
+
+	assert.Nil(t, child.ValidateFencingToken(token), "prefix token was not valid beneath the prefix")
+	assert.Equal(t, ErrStaleFencingToken, sibling.ValidateFencingToken(token), "prefix token was valid outside the prefix")
+}
+
+func sharedLeasesHoldOwnTokens(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/fencing/shared")
+
+	first, releaseFirst := elem.LockSharedWithLease(time.Minute)
+	second, releaseSecond := elem.LockSharedWithLease(time.Minute)
+	defer releaseSecond()
+	firstToken, secondToken := first.FencingToken(), second.FencingToken()
+	assert.NotEqual(t, firstToken, secondToken, "shared leases were issued the same token")
+
+	releaseFirst()
+	assert.Eventually(t, func() bool { return elem.ValidateFencingToken(firstToken) == ErrStaleFencingToken },
+		time.Second, time.Millisecond*10, "token of a released shared lease was still valid")
+	assert.Nil(t, elem.ValidateFencingToken(secondToken), "token of the remaining shared lease was not valid")
+}
+
+func staleTokensNotTakenForPrefix(t *testing.T) {
+	gns := createTestNamespace(t)
+	parent, _ := gns.FetchOrCreateAbsolutePath("/fencing/above")
+	child, _ := gns.FetchOrCreateAbsolutePath("/fencing/above/child")
+
+	old, releaseOld := child.LockWithLease(time.Minute)
+	stale := old.FencingToken()
+	releaseOld()
+	assert.Eventually(t, func() bool { return !child.reslock.isLocked() }, time.Second, time.Millisecond*10, "lease was not released")
+
+	prefix, release := parent.LockPrefixWithLease(time.Minute)
+	defer release()
+	assert.NotEqual(t, stale, prefix.FencingToken(), "two leases in the namespace were issued the same token")
+	assert.Equal(t, ErrStaleFencingToken, child.ValidateFencingToken(stale), "token of a released lease was taken for the prefix lease above")
+	assert.Nil(t, child.ValidateFencingToken(prefix.FencingToken()))
+}
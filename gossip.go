@@ -0,0 +1,301 @@
+package whatnot
+
+/*
+Gossip discovery finds other whatnot instances without any static configuration, using the SWIM-based
+memberlist protocol over UDP and TCP. Discovered members advertise the address of their PeerSync service,
+and are automatically added as replication peers when peer synchronization is enabled
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/pkg/errors"
+)
+
+// how long to wait for a leave announcement to propagate when shutting down
+const gossipLeaveTimeout = time.Second
+
+// WithGossipConfig enables Gossip protocol Cluster member discovery as WithGossip does,
+// with the gossip address, seeds and node name given
+type WithGossipConfig struct {
+	// NodeName identifies this member, defaulting to the WithPeerSync node name, or the hostname
+	NodeName string
+	// BindAddr is the address to gossip on, defaulting to all interfaces
+	BindAddr string
+	// BindPort is the UDP and TCP port to gossip on, zero picks a free port
+	BindPort int
+	// Seeds are gossip addresses of existing members to join the cluster through
+	Seeds []string
+	// PeerSyncAddr is the address other members should replicate to,
+	// defaulting to the address of the WithPeerSync listener
+	PeerSyncAddr string
+	// LocalNetwork uses faster failure detection, suited to instances on a single host or fast local network
+	LocalNetwork bool
+}
+
+func (w WithGossipConfig) name() optionName {
+	return optionDiscoverGossip
+}
+
+func (w WithGossipConfig) apply(manager *NameSpaceManager) (err error) {
+	if manager.gossipopts != nil {
+		return newConfigError("gossip discovery is already configured")
+	}
+	manager.gossipopts = &w
+	return nil
+}
+
+// gossipDiscovery turns memberlist events into peers and membership notifications
+type gossipDiscovery struct {
+	logsupport
+	manager *NameSpaceManager
+	list    *memberlist.Memberlist
+	node    string
+
+	mu         *sync.Mutex
+	meta       gossipMeta
+	discovered map[string]string // node name to the peer address it was added with
+	departing  map[string]bool   // members that announced they are leaving
+}
+
+// gossipMeta is the metadata every member advertises about itself
+type gossipMeta struct {
+	PeerAddr string `json:"peer,omitempty"`
+	Leaving  bool   `json:"leaving,omitempty"`
+}
+
+func decodeGossipMeta(n *memberlist.Node) (meta gossipMeta) {
+	_ = json.Unmarshal(n.Meta, &meta)
+	return meta
+}
+
+// startGossip begins gossip discovery, once all options have been applied
+func (m *NameSpaceManager) startGossip() error {
+	if m.gossipopts == nil {
+		return nil
+	}
+	opts := *m.gossipopts
+
+	g := &gossipDiscovery{
+		manager:    m,
+		node:       opts.NodeName,
+		mu:         &sync.Mutex{},
+		discovered: make(map[string]string),
+		departing:  make(map[string]bool),
+	}
+	if g.node == "" && m.peering != nil {
+		g.node = m.peering.node
+	}
+	if g.node == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return errors.Wrap(err, "no node name for gossip discovery")
+		}
+		g.node = fmt.Sprintf("%s-%x", hostname, randid.Uint64()&0xffff)
+	}
+	peerAddr := opts.PeerSyncAddr
+	if peerAddr == "" && m.peering != nil {
+		peerAddr = m.peering.listener.Addr().String()
+	}
+	g.meta = gossipMeta{PeerAddr: peerAddr}
+
+	conf := memberlist.DefaultLANConfig()
+	if opts.LocalNetwork {
+		conf = memberlist.DefaultLocalConfig()
+	}
+	conf.Name = g.node
+	conf.BindPort = opts.BindPort
+	conf.AdvertisePort = opts.BindPort
+	if opts.BindAddr != "" {
+		conf.BindAddr = opts.BindAddr
+	}
+	conf.Delegate = g
+	conf.Events = g
+	conf.LogOutput = gossipLog{}
+
+	list, err := memberlist.Create(conf)
+	if err != nil {
+		return errors.Wrap(err, "could not start gossip discovery")
+	}
+	g.list = list
+	m.gossip = g
+
+	if len(opts.Seeds) > 0 {
+		joined, err := list.Join(opts.Seeds)
+		if err != nil {
+			// we may simply be the first instance up, the others will find us
+			g.Warnf("could not join any gossip seeds: %s", err.Error())
+		} else {
+			g.Infof("joined gossip cluster through %d seeds", joined)
+		}
+	}
+	return nil
+}
+
+// GossipAddress returns the address this instance gossips on, for use as a seed by other instances
+func (m *NameSpaceManager) GossipAddress() string {
+	if m.gossip == nil {
+		return ""
+	}
+	return m.gossip.list.LocalNode().Address()
+}
+
+// ClusterMembers lists every instance currently known to gossip discovery, including this one
+func (m *NameSpaceManager) ClusterMembers() (members []MembershipEvent) {
+	if m.gossip == nil {
+		return nil
+	}
+	for _, n := range m.gossip.list.Members() {
+		members = append(members, MembershipEvent{
+			TS:         time.Now(),
+			Node:       n.Name,
+			GossipAddr: n.Address(),
+			PeerAddr:   decodeGossipMeta(n).PeerAddr,
+		})
+	}
+	return members
+}
+
+func (g *gossipDiscovery) close() {
+	// memberlist does not tell a graceful leave apart from a failure, so announce it first
+	g.mu.Lock()
+	g.meta.Leaving = true
+	g.mu.Unlock()
+	if err := g.list.UpdateNode(gossipLeaveTimeout); err != nil {
+		g.Warnf("could not announce departure to gossip cluster: %s", err.Error())
+	}
+	if err := g.list.Leave(gossipLeaveTimeout); err != nil {
+		g.Warnf("could not announce leaving gossip cluster: %s", err.Error())
+	}
+	_ = g.list.Shutdown()
+}
+
+// NotifyJoin implements memberlist.EventDelegate
+func (g *gossipDiscovery) NotifyJoin(n *memberlist.Node) {
+	if n.Name == g.node {
+		return
+	}
+	g.addPeer(n)
+	g.manager.members.notify(g.event(n, MemberJoined))
+}
+
+// NotifyLeave implements memberlist.EventDelegate
+func (g *gossipDiscovery) NotifyLeave(n *memberlist.Node) {
+	if n.Name == g.node {
+		return
+	}
+	g.removePeer(n.Name)
+	change := MemberFailed
+	g.mu.Lock()
+	if g.departing[n.Name] {
+		change = MemberLeft
+		delete(g.departing, n.Name)
+	}
+	g.mu.Unlock()
+	g.manager.members.notify(g.event(n, change))
+}
+
+// NotifyUpdate implements memberlist.EventDelegate
+func (g *gossipDiscovery) NotifyUpdate(n *memberlist.Node) {
+	if n.Name == g.node {
+		return
+	}
+	meta := decodeGossipMeta(n)
+	g.mu.Lock()
+	current := g.discovered[n.Name]
+	if meta.Leaving {
+		g.departing[n.Name] = true
+	}
+	g.mu.Unlock()
+	if meta.Leaving {
+		return // the leave itself will follow shortly
+	}
+	if current != meta.PeerAddr { // the member moved its PeerSync service
+		g.removePeer(n.Name)
+		g.addPeer(n)
+	}
+	g.manager.members.notify(g.event(n, MemberUpdated))
+}
+
+func (g *gossipDiscovery) event(n *memberlist.Node, change memberChange) MembershipEvent {
+	return MembershipEvent{
+		TS:         time.Now(),
+		Change:     change,
+		Node:       n.Name,
+		GossipAddr: n.Address(),
+		PeerAddr:   decodeGossipMeta(n).PeerAddr,
+	}
+}
+
+// addPeer starts replicating to a discovered member, if it advertises a PeerSync service
+func (g *gossipDiscovery) addPeer(n *memberlist.Node) {
+	peerAddr := decodeGossipMeta(n).PeerAddr
+	if g.manager.peering == nil || peerAddr == "" {
+		return
+	}
+	if err := g.manager.peering.addPeer(peerAddr); err != nil {
+		g.Debugf("not adding discovered member %s as a peer: %s", n.Name, err.Error())
+		return
+	}
+	g.mu.Lock()
+	g.discovered[n.Name] = peerAddr
+	g.mu.Unlock()
+}
+
+// removePeer stops replicating to a member, if it was added through discovery
+func (g *gossipDiscovery) removePeer(node string) {
+	g.mu.Lock()
+	peerAddr, ok := g.discovered[node]
+	delete(g.discovered, node)
+	g.mu.Unlock()
+	if !ok {
+		return
+	}
+	if err := g.manager.peering.removePeer(peerAddr); err != nil {
+		g.Debugf("could not remove departed member %s: %s", node, err.Error())
+	}
+}
+
+// NodeMeta implements memberlist.Delegate, advertising our PeerSync address
+func (g *gossipDiscovery) NodeMeta(limit int) []byte {
+	g.mu.Lock()
+	meta, err := json.Marshal(g.meta)
+	g.mu.Unlock()
+	if err != nil || len(meta) > limit {
+		g.Errorf("peer sync address %q is too long to advertise", g.meta.PeerAddr)
+		return nil
+	}
+	return meta
+}
+
+// NotifyMsg implements memberlist.Delegate, whatnot sends no gossip messages of its own
+func (g *gossipDiscovery) NotifyMsg([]byte) {}
+
+// GetBroadcasts implements memberlist.Delegate
+func (g *gossipDiscovery) GetBroadcasts(overhead, limit int) [][]byte {
+	return nil
+}
+
+// LocalState implements memberlist.Delegate
+func (g *gossipDiscovery) LocalState(join bool) []byte {
+	return nil
+}
+
+// MergeRemoteState implements memberlist.Delegate
+func (g *gossipDiscovery) MergeRemoteState(buf []byte, join bool) {}
+
+// gossipLog passes memberlist log output on to the whatnot logger
+type gossipLog struct {
+	logsupport
+}
+
+func (l gossipLog) Write(p []byte) (int, error) {
+	l.Debug(strings.TrimSpace(string(p)))
+	return len(p), nil
+}
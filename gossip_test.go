@@ -0,0 +1,108 @@
+package whatnot
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/databeast/whatnot/access"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const gossipTimeout = time.Second * 10
+
+func TestGossipDiscovery(t *testing.T) {
+	t.Run("Members discover each other and replicate", membersDiscoverEachOther)
+	t.Run("Departing member emits leave event", departingMemberEmitsLeave)
+}
+
+// createGossipTestCluster starts managers that only know the gossip address of the first one
+func createGossipTestCluster(t *testing.T, size int) (managers []*NameSpaceManager, namespaces []*Namespace) {
+	cluster := bufconnCluster{}
+	for i := 0; i < size; i++ {
+		cluster[fmt.Sprintf("node%d", i)] = bufconn.Listen(1024 * 1024)
+	}
+	var seeds []string
+	for i := 0; i < size; i++ {
+		node := fmt.Sprintf("node%d", i)
+		nsm, err := NewNamespaceManager(cluster.peerSyncOption(node), WithGossipConfig{
+			BindAddr:     "127.0.0.1",
+			Seeds:        seeds,
+			PeerSyncAddr: node,
+			LocalNetwork: true,
+		})
+		if !assert.Nil(t, err, "creating gossiping manager failed") {
+			t.FailNow()
+		}
+		ns := NewNamespace(testNameSpace)
+		if !assert.Nil(t, nsm.RegisterNamespace(ns), "registering namespace failed") {
+			t.FailNow()
+		}
+		if seeds == nil {
+			seeds = []string{nsm.GossipAddress()}
+		}
+		managers = append(managers, nsm)
+		namespaces = append(namespaces, ns)
+	}
+	t.Cleanup(func() {
+		for _, nsm := range managers {
+			_ = nsm.Close()
+		}
+	})
+	return managers, namespaces
+}
+
+func membersDiscoverEachOther(t *testing.T) {
+	managers, namespaces := createGossipTestCluster(t, 3)
+
+	for i, nsm := range managers {
+		assert.Eventually(t, func() bool {
+			return len(nsm.ClusterMembers()) == 3 && len(nsm.Peers()) == 2
+		}, gossipTimeout, time.Millisecond*50, "node%d did not discover every member", i)
+	}
+
+	elem, err := namespaces[2].FetchOrCreateAbsolutePath("/gossip/value")
+	if !assert.Nil(t, err, "creating path returned error") {
+		return
+	}
+	elem.SetValue(ElementValue{Val: "discovered"}, ChangeEdited, access.Role{})
+	assert.Eventually(t, func() bool {
+		peerElem := namespaces[1].FetchAbsolutePath("/gossip/value")
+		return peerElem != nil && peerElem.GetValue().Val == "discovered"
+	}, gossipTimeout, time.Millisecond*10, "value was not replicated to a discovered peer")
+}
+
+func departingMemberEmitsLeave(t *testing.T) {
+	managers, _ := createGossipTestCluster(t, 1)
+	sub := managers[0].SubscribeToMembership()
+	defer managers[0].UnSubscribeFromMembership(sub)
+
+	joiner, err := NewNamespaceManager(WithGossipConfig{
+		NodeName:     "joiner",
+		BindAddr:     "127.0.0.1",
+		Seeds:        []string{managers[0].GossipAddress()},
+		LocalNetwork: true,
+	})
+	if !assert.Nil(t, err, "creating joining manager failed") {
+		return
+	}
+
+	expect := func(change memberChange) {
+		timeout := time.After(gossipTimeout)
+		for {
+			select {
+			case e := <-sub.Events():
+				if e.Node == "joiner" && e.Change == change {
+					return
+				}
+			case <-timeout:
+				t.Errorf("did not receive membership change %d", change)
+				return
+			}
+		}
+	}
+	expect(MemberJoined)
+	_ = joiner.Close()
+	expect(MemberLeft)
+}
@@ -0,0 +1,90 @@
+package whatnot
+
+import (
+	"sync"
+	"time"
+
+	"github.com/databeast/whatnot/peerpb"
+)
+
+// Version orders changes to an element's value across a cluster, as a hybrid logical clock timestamp
+// it follows the physical clock, but never goes backwards or behind any version seen from a peer
+type Version struct {
+	Wall    int64  `json:"wall"`    // unix nanoseconds of the physical clock
+	Logical uint32 `json:"logical"` // orders changes made within the same wall time
+	Node    string `json:"node"`    // the instance the change was made on, breaking any remaining tie
+}
+
+// Before reports if this version is ordered ahead of the other
+func (v Version) Before(o Version) bool {
+	if v.Wall != o.Wall {
+		return v.Wall < o.Wall
+	}
+	if v.Logical != o.Logical {
+		return v.Logical < o.Logical
+	}
+	return v.Node < o.Node
+}
+
+// IsZero reports if this is the version of a value that has never been set
+func (v Version) IsZero() bool {
+	return v == Version{}
+}
+
+func (v Version) toProto() *peerpb.Version {
+	if v.IsZero() {
+		return nil
+	}
+	return &peerpb.Version{Wall: v.Wall, Logical: v.Logical, Node: v.Node}
+}
+
+func versionFromProto(v *peerpb.Version) Version {
+	if v == nil {
+		return Version{}
+	}
+	return Version{Wall: v.Wall, Logical: v.Logical, Node: v.Node}
+}
+
+// hybridClock issues Versions for the changes made on a single instance
+type hybridClock struct {
+	mu   *sync.Mutex
+	node string
+	last Version
+}
+
+// localClock versions changes to Namespaces that are not registered to a manager
+var localClock = newHybridClock("")
+
+func newHybridClock(node string) *hybridClock {
+	return &hybridClock{mu: &sync.Mutex{}, node: node}
+}
+
+// now issues the version for a local change
+func (c *hybridClock) now() Version {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wall := time.Now().UnixNano()
+	if wall > c.last.Wall {
+		c.last = Version{Wall: wall, Node: c.node}
+	} else {
+		c.last.Logical++
+	}
+	return c.last
+}
+
+// observe moves the clock past a version received from a peer, so our later changes are ordered after it
+func (c *hybridClock) observe(remote Version) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if remote.Wall > c.last.Wall || (remote.Wall == c.last.Wall && remote.Logical > c.last.Logical) {
+		c.last = Version{Wall: remote.Wall, Logical: remote.Logical, Node: c.node}
+	}
+}
+
+// clock returns the clock versioning changes to this element
+func (p *PathElement) clock() *hybridClock {
+	if p.namespace != nil && p.namespace.manager != nil {
+		return p.namespace.manager.clock
+	}
+	return localClock
+}
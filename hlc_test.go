@@ -0,0 +1,43 @@
+package whatnot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHybridLogicalClock(t *testing.T) {
+	t.Run("Versions always increase", versionsAlwaysIncrease)
+	t.Run("Observed versions are overtaken", observedVersionsAreOvertaken)
+	t.Run("Equal times are ordered by node", equalTimesOrderedByNode)
+}
+
+func versionsAlwaysIncrease(t *testing.T) {
+	clock := newHybridClock("node")
+	last := clock.now()
+	for i := 0; i < 1000; i++ {
+		next := clock.now()
+		if !assert.True(t, last.Before(next), "version %d did not follow the previous one", i) {
+			return
+		}
+		last = next
+	}
+}
+
+func observedVersionsAreOvertaken(t *testing.T) {
+	clock := newHybridClock("behind")
+	ahead := Version{Wall: time.Now().Add(time.Hour).UnixNano(), Logical: 3, Node: "ahead"}
+	clock.observe(ahead)
+	next := clock.now()
+	assert.True(t, ahead.Before(next), "version issued after observing a peer was not ordered after it")
+	assert.Equal(t, "behind", next.Node, "version was not issued for our own node")
+}
+
+func equalTimesOrderedByNode(t *testing.T) {
+	a := Version{Wall: 10, Logical: 1, Node: "a"}
+	b := Version{Wall: 10, Logical: 1, Node: "b"}
+	assert.True(t, a.Before(b), "tie was not broken by node")
+	assert.False(t, b.Before(a), "tie was broken both ways")
+	assert.False(t, a.Before(a), "version was ordered before itself")
+}
@@ -7,24 +7,65 @@ and optionally, all elements beneath it
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"time"
+
+	"github.com/databeast/whatnot/access"
+	"github.com/pkg/errors"
 )
 
+// LeaseMode is whether a lease holds its elements alone, or shares them with other shared leases
+type LeaseMode int
+
+const (
+	// LeaseExclusive leases hold their elements alone, as Lock does
+	LeaseExclusive LeaseMode = iota
+	// LeaseShared leases hold their elements alongside any other shared leases, as RLock does
+	// and keep out exclusive leases until every one of them has finished
+	LeaseShared
+)
+
+// MinKeepAliveInterval is the most often KeepAlive renews a lease, however short its ttl
+const MinKeepAliveInterval = time.Millisecond
+
+// ErrLeaseEnded is returned when renewing a lease that has already been released, expired or cancelled
+var ErrLeaseEnded = errors.New("lease has already ended")
+
 // LeaseContext implements Element Locking Lease control as a Context Interface object
 // it is heavily recommend to use this as the context object for the rest of your functions
 // lifetime to keep it in sync with the accordant lease it was generated with to enable
 // your code to control and react to lease expiration
 type LeaseContext struct {
 	logsupport
-	ctx       context.Context
-	elem      *PathElement
-	recursive bool
-	cancel    func()
+	ctx    context.Context
+	cancel func()
+	id     LeaseID
+	ns     *Namespace  // the namespace whose registry holds the lease, nil for elements outside one
+	role   access.Role // who the lease was granted to, from the context it was granted with
+
+	mu       *sync.Mutex
+	ttl      time.Duration // the ttl the lease was granted with, which KeepAlive renews it by
+	deadline time.Time     // when the lease expires, unless it is renewed
+	expire   *time.Timer   // ends the lease at its deadline
+	cause    error         // why the lease was ended early, reported by Err in place of the context error
+	revoker  access.Role   // who revoked the lease, if it was ended by Namespace.RevokeLease
+	suspect  bool          // the lease is still held, but may no longer be exclusive
+
+	attached []*leaseAttachment // elements held by the lease, and those waiting to be
+	ended    bool               // the attached elements have been released, nothing more can be attached
 }
 
-// Deadline implements the Context interface
+// Deadline implements the Context interface, moving later each time the lease is renewed
+// a parent context given when locking that ends sooner still ends the lease with it
 func (l *LeaseContext) Deadline() (time.Time, bool) {
-	return l.ctx.Deadline()
+	l.mu.Lock()
+	deadline := l.deadline
+	l.mu.Unlock()
+	if parent, ok := l.ctx.Deadline(); ok && parent.Before(deadline) {
+		return parent, true
+	}
+	return deadline, true
 }
 
 // Done implements the Context interface
@@ -33,8 +74,42 @@ func (l *LeaseContext) Done() <-chan struct{} {
 }
 
 // Err implements the Context interface
+// leases cancelled on losing quorum report ErrQuorumLost rather than context.Canceled
 func (l *LeaseContext) Err() error {
-	return l.ctx.Err()
+	err := l.ctx.Err()
+	if err == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cause != nil {
+		return l.cause
+	}
+	return err
+}
+
+// Suspect reports if the lease is still held, but its instance has lost contact with a quorum of the cluster
+// so other instances may consider the same elements free
+func (l *LeaseContext) Suspect() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.suspect
+}
+
+func (l *LeaseContext) markSuspect() {
+	l.mu.Lock()
+	l.suspect = true
+	l.mu.Unlock()
+}
+
+// cancelWith ends the lease early, reporting the given cause from Err
+func (l *LeaseContext) cancelWith(cause error) {
+	l.mu.Lock()
+	if l.cause == nil && l.ctx.Err() == nil {
+		l.cause = cause
+	}
+	l.mu.Unlock()
+	l.cancel()
 }
 
 // Value implements the Context interface
@@ -42,63 +117,164 @@ func (l *LeaseContext) Value(key interface{}) interface{} {
 	return l.ctx.Value(key)
 }
 
-// Cancel implements the Context interface
+// Cancel implements the Context interface, ending the lease as its release func does
 func (l *LeaseContext) Cancel() {
-	if l.recursive == true {
-		go l.elem.UnLockSubs()
-	} else {
-		go l.elem.UnLock()
-	}
+	l.cancel()
 }
 
 // LockWithLease will lock a single path element with a timed lease on the lock
 // it uses a a background context so cannot be cancelled before the lease expires
 func (p *PathElement) LockWithLease(ttl time.Duration) (ctx *LeaseContext, release func()) {
-	return p.generateLease(context.Background(), ttl, false)
+	return p.generateLease(context.Background(), ttl, false, LeaseExclusive)
 }
 
 // ContextLockWithLease will lock a single path element with a timed lease on the lock
 // you provide the context instance to have external control to cancel it before timeout
 func (p *PathElement) ContextLockWithLease(octx context.Context, ttl time.Duration) (ctx *LeaseContext, release func()) {
-	return p.generateLease(octx, ttl, false)
+	return p.generateLease(octx, ttl, false, LeaseExclusive)
 }
 
 // LockPrefixWithLease will lock a path element and all sub-elements with a timed lease on the lock
 // it uses a a background context so cannot be cancelled before the lease expires
 func (p *PathElement) LockPrefixWithLease(ttl time.Duration) (ctx *LeaseContext, release func()) {
-	return p.generateLease(context.Background(), ttl, true)
+	return p.generateLease(context.Background(), ttl, true, LeaseExclusive)
 }
 
 // ContextLockPrefixWithLease will lock a path element and all sub-elements with a timed lease on the lock
 // you provide the context instance to have external control to cancel it before timeout
 func (p *PathElement) ContextLockPrefixWithLease(octx context.Context, ttl time.Duration) (ctx *LeaseContext, release func()) {
-	return p.generateLease(octx, ttl, true)
+	return p.generateLease(octx, ttl, true, LeaseExclusive)
 }
 
-func (p *PathElement) generateLease(octx context.Context, ttl time.Duration, recursive bool) (ctx *LeaseContext, release func()) {
+// LockSharedWithLease will place a shared lock on a single path element with a timed lease on the lock
+// any number of shared leases may be held on the element at once, but no exclusive ones
+func (p *PathElement) LockSharedWithLease(ttl time.Duration) (ctx *LeaseContext, release func()) {
+	return p.generateLease(context.Background(), ttl, false, LeaseShared)
+}
+
+// ContextLockSharedWithLease will place a shared lock on a single path element with a timed lease on the lock
+// you provide the context instance to have external control to cancel it before timeout
+func (p *PathElement) ContextLockSharedWithLease(octx context.Context, ttl time.Duration) (ctx *LeaseContext, release func()) {
+	return p.generateLease(octx, ttl, false, LeaseShared)
+}
+
+// LockPrefixSharedWithLease will place a shared lock on a path element and all sub-elements with a timed lease on the lock
+// exclusive leases on the element, or on any element beneath it, wait until every shared lease has finished
+func (p *PathElement) LockPrefixSharedWithLease(ttl time.Duration) (ctx *LeaseContext, release func()) {
+	return p.generateLease(context.Background(), ttl, true, LeaseShared)
+}
 
-	dl, cancel := context.WithTimeout(octx, ttl)
+// ContextLockPrefixSharedWithLease will place a shared lock on a path element and all sub-elements with a timed lease on the lock
+// you provide the context instance to have external control to cancel it before timeout
+func (p *PathElement) ContextLockPrefixSharedWithLease(octx context.Context, ttl time.Duration) (ctx *LeaseContext, release func()) {
+	return p.generateLease(octx, ttl, true, LeaseShared)
+}
 
-	// lock the resource lock structure itself while changing it
-	p.reslock.selfmu.Lock()
-	p.reslock.deadline = dl
-	p.reslock.selfmu.Unlock()
+func (p *PathElement) generateLease(octx context.Context, ttl time.Duration, recursive bool, mode LeaseMode) (ctx *LeaseContext, release func()) {
+	ctx = newLease(octx, p.namespace, ttl)
+	if err := ctx.attach(p, recursive, mode); err != nil {
+		p.Warnf("lease on %s was not granted: %s", p.AbsolutePath().ToPathString(), err.Error())
+		var deadlock *DeadlockError
+		if errors.As(err, &deadlock) {
+			ctx.cancelWith(deadlock) // reported by Err, so the holder knows to back off and retry
+		}
+		ctx.cancel() // the lease is returned already finished
+	}
+	return ctx, ctx.cancel
+}
 
-	ctx = &LeaseContext{
-		ctx:       dl,
-		elem:      p,
-		recursive: recursive,
-		cancel:    cancel,
+// newLease starts the lease's clock and records it with the namespace and manager, without any attached elements
+func newLease(octx context.Context, ns *Namespace, ttl time.Duration) *LeaseContext {
+	dl, cancel := context.WithCancel(octx)
+	lease := &LeaseContext{
+		ctx:      dl,
+		cancel:   cancel,
+		mu:       &sync.Mutex{},
+		ttl:      ttl,
+		deadline: time.Now().Add(ttl),
+		ns:       ns,
+		role:     roleOf(octx),
 	}
+	lease.mu.Lock()
+	lease.expire = time.AfterFunc(ttl, func() { lease.cancelWith(context.DeadlineExceeded) })
+	lease.mu.Unlock()
 
-	if recursive {
-		p.LockSubs()
+	if ns != nil {
+		lease.id = ns.leases.add(lease)
 	} else {
-		p.Lock()
+		lease.id = LeaseID(randid.Uint64())
+	}
+	lease.unlockAfterExpire()
+	if ns != nil && ns.manager != nil && ns.manager.partition != nil {
+		ns.manager.partition.check(lease)
+	}
+	return lease
+}
+
+// Renew pushes the lease's deadline back to ttl from now, which may be sooner than its current deadline
+// watchers of every attached element receive a ChangeRenewed event. With Raft enabled the cluster must accept the renewal,
+// otherwise the lease is left to expire at its previous deadline
+func (l *LeaseContext) Renew(ttl time.Duration) error {
+	if ttl <= 0 {
+		return errors.New("a lease can only be renewed by a positive ttl")
+	}
+	if l.ctx.Err() != nil {
+		return ErrLeaseEnded
+	}
+	deadline := time.Now().Add(ttl)
+	held := l.held()
+	for _, a := range held {
+		if raft := a.elem.clusterLeases(); raft != nil {
+			if err := raft.renewLease(l.ctx, a.elem, uint64(l.id), deadline); err != nil {
+				return errors.Wrapf(err, "cluster did not renew lease on %s", a.elem.AbsolutePath().ToPathString())
+			}
+		}
+	}
 
+	l.mu.Lock()
+	if l.cause != nil || l.ctx.Err() != nil {
+		l.mu.Unlock()
+		return ErrLeaseEnded
 	}
+	l.deadline = deadline
+	l.expire.Reset(ttl)
+	l.mu.Unlock()
 
-	p.unlockAfterExpire()
+	note := fmt.Sprintf("lease renewed until %s", deadline.UTC().Format(time.RFC3339Nano))
+	for _, a := range held {
+		a.elem.selfnotify <- elementChange{id: randid.Uint64(), elem: a.elem, change: ChangeRenewed, actor: l.role, note: note}
+		a.callHooks(l, LeaseRenewed, ttl)
+	}
+	return nil
+}
 
-	return ctx, cancel
+// KeepAlive renews the lease by the ttl it was granted with, every third of that ttl, until the lease ends
+// or stop is called. A holder that crashes or hangs stops renewing, so its lease ends within one ttl
+// rather than the much longer one it would otherwise have needed
+func (l *LeaseContext) KeepAlive() (stop func()) {
+	stopped := make(chan struct{})
+	var once sync.Once
+	every := l.ttl / 3
+	if every < MinKeepAliveInterval {
+		every = MinKeepAliveInterval
+	}
+	go func() {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-l.ctx.Done():
+				return
+			case <-stopped:
+				return
+			case <-ticker.C:
+				if err := l.Renew(l.ttl); err == ErrLeaseEnded {
+					return
+				} else if err != nil {
+					l.Warnf("could not renew lease %s: %s", l.id, err.Error())
+				}
+			}
+		}
+	}()
+	return func() { once.Do(func() { close(stopped) }) }
 }
@@ -1,6 +1,7 @@
 package whatnot
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -12,6 +13,12 @@ func TestLeaseCreationAndExpiration(t *testing.T) {
 	t.Run("Test that Lease expires after set time", leaseExpiresAsExpected)
 	t.Run("Test that lease responds to context cancellation", leaseAcceptsCancelation)
 	t.Run("Test that element prefix can be locked and unlocked", lockPrefixThenUnlock)
+	t.Run("Renewing a lease moves its deadline", renewMovesDeadline)
+	t.Run("KeepAlive holds a lease until stopped", keepAliveHoldsLease)
+	t.Run("KeepAlive accepts the shortest ttl", keepAliveShortTTL)
+	t.Run("Renewals are sent to watchers", renewalsAreWatched)
+	t.Run("Ended leases cannot be renewed", endedLeaseCannotRenew)
+	t.Run("Cancel releases each element once", cancelReleasesOnce)
 }
 
 func createNewLeaseOnPathElement(t *testing.T) {
@@ -129,3 +136,126 @@ func lockPrefixThenUnlock(t *testing.T) {
 	t.Log("lock released")
 
 }
+
+func renewMovesDeadline(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/renewed")
+
+	lease, release := elem.LockWithLease(time.Millisecond * 300)
+	defer release()
+	first, _ := lease.Deadline()
+	if !assert.Nil(t, lease.Renew(time.Second), "renewing lease failed") {
+		return
+	}
+	renewed, _ := lease.Deadline()
+	assert.True(t, renewed.After(first.Add(time.Millisecond*500)), "deadline was not moved")
+
+	select {
+	case <-lease.Done():
+		t.Error("renewed lease expired at its original deadline")
+		return
+	case <-time.After(time.Millisecond * 500):
+	}
+	assert.True(t, elem.reslock.isLocked(), "renewed lease released its lock")
+
+	select {
+	case <-lease.Done():
+		assert.Equal(t, context.DeadlineExceeded, lease.Err(), "expired lease reports the wrong error")
+	case <-time.After(time.Second * 2):
+		t.Error("renewed lease did not expire at its new deadline")
+	}
+}
+
+func keepAliveHoldsLease(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/kept")
+
+	lease, release := elem.LockWithLease(time.Millisecond * 150)
+	defer release()
+	stop := lease.KeepAlive()
+
+	select {
+	case <-lease.Done():
+		t.Error("lease kept alive expired")
+		return
+	case <-time.After(time.Millisecond * 600):
+	}
+
+	stop()
+	stopped := time.Now()
+	select {
+	case <-lease.Done():
+		assert.True(t, time.Since(stopped) < time.Millisecond*300, "lease outlived its ttl once renewals stopped")
+	case <-time.After(time.Second * 2):
+		t.Error("lease did not expire once renewals stopped")
+	}
+}
+
+func keepAliveShortTTL(t *testing.T) {
+	gns := createTestNamespace(t)
+	lease, release := gns.GrantLease(time.Nanosecond)
+	defer release()
+	// renewing every third of a nanosecond would panic in KeepAlive's goroutine, failing the whole test binary
+	stop := lease.KeepAlive()
+	time.Sleep(time.Millisecond * 10)
+	stop()
+}
+
+func renewalsAreWatched(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/watched")
+	sub := elem.SubscribeToEvents(false)
+	defer elem.UnSubscribeFromEvents(sub)
+
+	// subscribers not ready to receive an event are dropped, so the loop below must already be waiting
+	go func() {
+		lease, _ := elem.LockWithLease(time.Second)
+		time.Sleep(time.Millisecond * 50)
+		_ = lease.Renew(time.Millisecond * 100)
+	}()
+
+	timeout := time.After(time.Second * 2)
+	for {
+		select {
+		case e := <-sub.Events():
+			if e.Change == ChangeRenewed {
+				assert.Contains(t, e.Note, "renewed until", "renewal does not give its deadline")
+				return
+			}
+		case <-timeout:
+			t.Error("renewal was not sent to watchers")
+			return
+		}
+	}
+}
+
+func endedLeaseCannotRenew(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/ended")
+
+	lease, release := elem.LockWithLease(time.Second)
+	release()
+	<-lease.Done()
+	assert.Equal(t, ErrLeaseEnded, lease.Renew(time.Second), "released lease was renewed")
+}
+
+func cancelReleasesOnce(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/cancelled")
+
+	first, release := elem.LockSharedWithLease(time.Minute)
+	second, releaseSecond := elem.LockSharedWithLease(time.Minute)
+	defer releaseSecond()
+
+	first.Cancel()
+	select {
+	case <-first.Done():
+	case <-time.After(time.Second):
+		t.Error("cancelled lease did not end")
+		return
+	}
+	release()
+	time.Sleep(time.Millisecond * 50)
+	assert.Nil(t, second.Err())
+	assert.Equal(t, ErrLockUnavailable, elem.TryLock(), "cancelling one shared lease released the other")
+}
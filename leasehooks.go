@@ -0,0 +1,165 @@
+package whatnot
+
+/*
+Lease hooks let an application react to the life of its leases as it happens, rather than polling Done(),
+running cleanup once a lease has gone or alerting on leases that keep expiring
+*/
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/databeast/whatnot/access"
+)
+
+// LeaseEvent is a point in the life of a lease on an element that lease hooks are called for
+type LeaseEvent int
+
+const (
+	// LeaseAcquired is called once the lease holds the element
+	LeaseAcquired LeaseEvent = iota
+	// LeaseRenewed is called each time the lease is renewed, with the ttl it was renewed by
+	LeaseRenewed
+	// LeaseReleased is called once a lease that was released, or whose context was cancelled, has given up the element
+	LeaseReleased
+	// LeaseExpired is called once a lease that reached its deadline, or lost quorum, has given up the element
+	LeaseExpired
+	// LeaseRevoked is called once a lease ended by Namespace.RevokeLease has given up the element
+	LeaseRevoked
+)
+
+func (e LeaseEvent) String() string {
+	switch e {
+	case LeaseAcquired:
+		return "acquired"
+	case LeaseRenewed:
+		return "renewed"
+	case LeaseReleased:
+		return "released"
+	case LeaseExpired:
+		return "expired"
+	case LeaseRevoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
+
+// LeaseInfo describes a lease on an element to the hooks called for it
+type LeaseInfo struct {
+	Event     LeaseEvent
+	Lease     LeaseID
+	Path      PathString    // the element the lease holds
+	Prefix    bool          // the lease holds everything beneath Path as well
+	Mode      LeaseMode     // if the lease holds the element alone, or shares it
+	Holder    access.Role   // who the lease was granted to
+	TTL       time.Duration // the ttl the lease was granted with, or renewed by for LeaseRenewed
+	Held      time.Duration // how long the lease has held the element, zero for LeaseAcquired
+	Err       error         // why the lease ended, nil until it has
+	RevokedBy access.Role   // who revoked the lease, for LeaseRevoked
+}
+
+// LeaseHook is a function provided by your code which will be called (as a separate goroutine)
+// for every event in the life of the leases it was registered for
+type LeaseHook func(info LeaseInfo)
+
+// leaseHooks are the hooks registered with a namespace or element
+type leaseHooks struct {
+	mu    *sync.Mutex
+	next  int
+	hooks map[int]LeaseHook
+}
+
+func newLeaseHooks() *leaseHooks {
+	return &leaseHooks{
+		mu:    &sync.Mutex{},
+		hooks: make(map[int]LeaseHook),
+	}
+}
+
+// add registers the hook, returning the function that removes it again
+func (h *leaseHooks) add(hook LeaseHook) (remove func()) {
+	h.mu.Lock()
+	id := h.next
+	h.next++
+	h.hooks[id] = hook
+	h.mu.Unlock()
+	return func() {
+		h.mu.Lock()
+		delete(h.hooks, id)
+		h.mu.Unlock()
+	}
+}
+
+// call starts every registered hook with the lease's details
+func (h *leaseHooks) call(info LeaseInfo) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, hook := range h.hooks {
+		go hook(info)
+	}
+}
+
+// OnLease registers a hook called for every event in the life of every lease on an element of the Namespace
+// it returns a function that removes the hook again
+func (ns *Namespace) OnLease(hook LeaseHook) (remove func()) {
+	return ns.hooks.add(hook)
+}
+
+// OnLease registers a hook called for every event in the life of every lease on this element,
+// though not those on the elements beneath it. It returns a function that removes the hook again
+func (p *PathElement) OnLease(hook LeaseHook) (remove func()) {
+	p.leasehooks.CompareAndSwap(nil, newLeaseHooks())
+	return p.leasehooks.Load().(*leaseHooks).add(hook)
+}
+
+// callHooks calls the hooks of the element and its namespace for an event of a lease holding the element
+func (a *leaseAttachment) callHooks(lease *LeaseContext, event LeaseEvent, ttl time.Duration) {
+	info := LeaseInfo{
+		Event:  event,
+		Lease:  lease.id,
+		Path:   a.elem.AbsolutePath().ToPathString(),
+		Prefix: a.recursive,
+		Mode:   a.mode,
+		Holder: lease.role,
+		TTL:    ttl,
+	}
+	if event != LeaseAcquired {
+		info.Held = time.Since(a.since)
+	}
+	if event == LeaseReleased || event == LeaseExpired || event == LeaseRevoked {
+		info.Err = lease.Err()
+	}
+	if event == LeaseRevoked {
+		lease.mu.Lock()
+		info.RevokedBy = lease.revoker
+		lease.mu.Unlock()
+	}
+	if hooks, ok := a.elem.leasehooks.Load().(*leaseHooks); ok {
+		hooks.call(info)
+	}
+	if a.elem.namespace != nil {
+		a.elem.namespace.hooks.call(info)
+	}
+}
+
+// endedAs is the event for the way the lease ended
+func (l *LeaseContext) endedAs() LeaseEvent {
+	l.mu.Lock()
+	cause := l.cause
+	l.mu.Unlock()
+	switch {
+	case cause == ErrLeaseRevoked:
+		return LeaseRevoked
+	case cause == context.DeadlineExceeded, cause == ErrQuorumLost:
+		return LeaseExpired
+	case cause == nil && l.ctx.Err() == context.DeadlineExceeded:
+		return LeaseExpired // the context it was granted with reached its deadline
+	default:
+		return LeaseReleased
+	}
+}
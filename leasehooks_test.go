@@ -0,0 +1,105 @@
+package whatnot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/databeast/whatnot/access"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseHooks(t *testing.T) {
+	t.Run("Hooks follow the life of a lease", hooksFollowLeaseLife)
+	t.Run("Expired leases are reported", expiredLeasesReported)
+	t.Run("Revoked leases are reported", revokedLeasesReported)
+	t.Run("Removed hooks are not called", removedHooksNotCalled)
+}
+
+// nextLeaseEvent waits for the next lease event sent to the hook's channel
+func nextLeaseEvent(t *testing.T, events chan LeaseInfo) LeaseInfo {
+	select {
+	case info := <-events:
+		return info
+	case <-time.After(time.Second * 2):
+		t.Error("lease hook was not called")
+		return LeaseInfo{}
+	}
+}
+
+func hooksFollowLeaseLife(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/hooked/life")
+	events := make(chan LeaseInfo, 10)
+	defer gns.OnLease(func(info LeaseInfo) { events <- info })()
+
+	ctx := access.WithRole(context.Background(), access.Role{Name: "cleaner"})
+	lease, release := elem.ContextLockWithLease(ctx, time.Minute)
+	acquired := nextLeaseEvent(t, events)
+	assert.Equal(t, LeaseAcquired, acquired.Event)
+	assert.Equal(t, lease.ID(), acquired.Lease)
+	assert.Equal(t, PathString("/hooked/life"), acquired.Path)
+	assert.Equal(t, "cleaner", acquired.Holder.Name)
+	assert.Equal(t, time.Minute, acquired.TTL)
+	assert.Nil(t, acquired.Err)
+
+	assert.Nil(t, lease.Renew(time.Hour))
+	renewed := nextLeaseEvent(t, events)
+	assert.Equal(t, LeaseRenewed, renewed.Event)
+	assert.Equal(t, time.Hour, renewed.TTL)
+
+	time.Sleep(time.Millisecond * 20)
+	release()
+	released := nextLeaseEvent(t, events)
+	assert.Equal(t, LeaseReleased, released.Event)
+	assert.True(t, released.Held >= time.Millisecond*20, "lease was reported as held for %s", released.Held)
+	assert.Equal(t, context.Canceled, released.Err)
+}
+
+func expiredLeasesReported(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/hooked/expired")
+	other, _ := gns.FetchOrCreateAbsolutePath("/hooked/other")
+	events := make(chan LeaseInfo, 10)
+	defer elem.OnLease(func(info LeaseInfo) { events <- info })()
+	elsewhere := make(chan LeaseInfo, 10)
+	defer other.OnLease(func(info LeaseInfo) { elsewhere <- info })()
+
+	elem.LockWithLease(time.Millisecond * 100)
+	assert.Equal(t, LeaseAcquired, nextLeaseEvent(t, events).Event)
+	expired := nextLeaseEvent(t, events)
+	assert.Equal(t, LeaseExpired, expired.Event)
+	assert.Equal(t, context.DeadlineExceeded, expired.Err)
+	assert.Empty(t, elsewhere, "hook was called for a lease on another element")
+}
+
+func revokedLeasesReported(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/hooked/revoked")
+	events := make(chan LeaseInfo, 10)
+	defer gns.OnLease(func(info LeaseInfo) { events <- info })()
+
+	lease, _ := elem.LockWithLease(time.Minute)
+	assert.Equal(t, LeaseAcquired, nextLeaseEvent(t, events).Event)
+	assert.Nil(t, gns.RevokeLease(lease.ID(), access.Role{Name: "operator"}))
+	revoked := nextLeaseEvent(t, events)
+	assert.Equal(t, LeaseRevoked, revoked.Event)
+	assert.Equal(t, ErrLeaseRevoked, revoked.Err)
+	assert.Equal(t, "operator", revoked.RevokedBy.Name)
+}
+
+func removedHooksNotCalled(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/hooked/removed")
+	events := make(chan LeaseInfo, 10)
+	remove := elem.OnLease(func(info LeaseInfo) { events <- info })
+	remove()
+
+	_, release := elem.LockWithLease(time.Minute)
+	release()
+	select {
+	case info := <-events:
+		t.Errorf("removed hook was called for %s", info.Event)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
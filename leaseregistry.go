@@ -0,0 +1,288 @@
+package whatnot
+
+/*
+Every lease granted in a Namespace is recorded in its lease registry under a unique LeaseID, much as etcd does.
+A lease may hold any number of unrelated elements, attached as it goes, and all of them are released together
+when the lease is released, expires or is revoked
+*/
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/databeast/whatnot/peerpb"
+	"github.com/pkg/errors"
+)
+
+// ErrLeaseNotFound is returned for a LeaseID that the namespace has no current lease for
+var ErrLeaseNotFound = errors.New("no such lease")
+
+// LeaseID identifies a lease within its Namespace, for as long as the lease lasts
+type LeaseID uint64
+
+// String gives the ID in hex, the form it is logged in
+func (id LeaseID) String() string {
+	return fmt.Sprintf("%016x", uint64(id))
+}
+
+// leaseAttachment is an element held by a lease, or being waited for
+type leaseAttachment struct {
+	elem      *PathElement
+	recursive bool
+	mode      LeaseMode
+	held      bool         // the lock has been taken, so must be released with the lease
+	fence     FencingToken // issued once the lock was taken
+	since     time.Time    // when the lock was taken
+}
+
+// leaseRegistry is the set of leases currently granted in a namespace
+type leaseRegistry struct {
+	mu     *sync.Mutex
+	leases map[LeaseID]*LeaseContext
+}
+
+func newLeaseRegistry() *leaseRegistry {
+	return &leaseRegistry{
+		mu:     &sync.Mutex{},
+		leases: make(map[LeaseID]*LeaseContext),
+	}
+}
+
+// add records the lease under a newly chosen ID, which it returns
+func (r *leaseRegistry) add(lease *LeaseContext) LeaseID {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for {
+		// random rather than sequential IDs, so raft members granting leases at once do not collide
+		id := LeaseID(randid.Uint64())
+		if _, taken := r.leases[id]; id != 0 && !taken {
+			r.leases[id] = lease
+			return id
+		}
+	}
+}
+
+func (r *leaseRegistry) remove(id LeaseID) {
+	r.mu.Lock()
+	delete(r.leases, id)
+	r.mu.Unlock()
+}
+
+func (r *leaseRegistry) fetch(id LeaseID) *LeaseContext {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.leases[id]
+}
+
+func (r *leaseRegistry) list() (leases []*LeaseContext) {
+	r.mu.Lock()
+	for _, l := range r.leases {
+		leases = append(leases, l)
+	}
+	r.mu.Unlock()
+	return leases
+}
+
+// GrantLease creates a lease in the namespace that holds no elements until they are attached to it
+// it lasts for ttl unless renewed, and release ends it, releasing every attached element
+func (ns *Namespace) GrantLease(ttl time.Duration) (lease *LeaseContext, release func()) {
+	return ns.ContextGrantLease(context.Background(), ttl)
+}
+
+// ContextGrantLease creates a lease in the namespace that also ends if the given context does
+func (ns *Namespace) ContextGrantLease(octx context.Context, ttl time.Duration) (lease *LeaseContext, release func()) {
+	lease = newLease(octx, ns, ttl)
+	return lease, lease.cancel
+}
+
+// FetchLease returns the current lease with the given ID, or nil if there is none
+func (ns *Namespace) FetchLease(id LeaseID) *LeaseContext {
+	return ns.leases.fetch(id)
+}
+
+// Leases lists every lease currently granted in the namespace
+func (ns *Namespace) Leases() []*LeaseContext {
+	return ns.leases.list()
+}
+
+// ID identifies the lease in its namespace's registry
+func (l *LeaseContext) ID() LeaseID {
+	return l.id
+}
+
+// Mode reports whether the lease holds the given element exclusively or shared with other leases
+// ok is false if the lease does not hold the element
+func (l *LeaseContext) Mode(p *PathElement) (mode LeaseMode, ok bool) {
+	for _, a := range l.held() {
+		if a.elem == p {
+			return a.mode, true
+		}
+	}
+	return LeaseExclusive, false
+}
+
+// Elements lists the elements the lease currently holds
+func (l *LeaseContext) Elements() (elems []*PathElement) {
+	for _, a := range l.held() {
+		elems = append(elems, a.elem)
+	}
+	return elems
+}
+
+// Attach waits for the lock on an element of the lease's namespace, and holds it for the rest of the lease
+// it returns an error should the lease end first
+func (l *LeaseContext) Attach(p *PathElement) error {
+	return l.attach(p, false, LeaseExclusive)
+}
+
+// AttachPrefix waits for the lock on an element and every element beneath it, and holds them for the rest of the lease
+func (l *LeaseContext) AttachPrefix(p *PathElement) error {
+	return l.attach(p, true, LeaseExclusive)
+}
+
+// AttachShared waits for a shared lock on an element, and holds it for the rest of the lease
+func (l *LeaseContext) AttachShared(p *PathElement) error {
+	return l.attach(p, false, LeaseShared)
+}
+
+// AttachPrefixShared waits for a shared lock on an element and every element beneath it, and holds them for the rest of the lease
+func (l *LeaseContext) AttachPrefixShared(p *PathElement) error {
+	return l.attach(p, true, LeaseShared)
+}
+
+func (l *LeaseContext) attach(p *PathElement, recursive bool, mode LeaseMode) error {
+	if p.namespace != l.ns {
+		return errors.Errorf("%s is not in the lease's namespace", p.AbsolutePath().ToPathString())
+	}
+	path := p.AbsolutePath().ToPathString()
+
+	// the lease would wait forever on a lock it already holds itself
+	l.mu.Lock()
+	if l.ended || l.ctx.Err() != nil {
+		l.mu.Unlock()
+		return ErrLeaseEnded
+	}
+	for _, a := range l.attached {
+		if leasesOverlap(a.elem.AbsolutePath().ToPathString(), a.recursive, path, recursive) {
+			l.mu.Unlock()
+			return errors.Errorf("%s is already attached to lease %s", path, l.id)
+		}
+	}
+	a := &leaseAttachment{elem: p, recursive: recursive, mode: mode}
+	l.attached = append(l.attached, a)
+	l.mu.Unlock()
+
+	// with raft enabled, the lease must first be granted by the cluster
+	// and its fencing token is issued by the cluster, so it increases across every instance
+	var granted uint64
+	if raft := p.clusterLeases(); raft != nil {
+		var err error
+		if granted, err = raft.acquireLease(l, p, uint64(l.id), recursive, mode == LeaseShared); err != nil {
+			l.detach(a)
+			return errors.Wrap(err, "cluster did not grant lease")
+		}
+	}
+	// the wait for the lock is bounded by the lease itself, so a lease request cannot wait forever
+	var err error
+	switch {
+	case mode == LeaseShared && recursive:
+		err = p.RLockSubsContext(l)
+	case mode == LeaseShared:
+		err = p.RLockContext(l)
+	case recursive:
+		err = p.LockSubsContext(l)
+	default:
+		err = p.LockContext(l)
+	}
+	if err != nil {
+		l.detach(a)
+		if raft := p.clusterLeases(); raft != nil {
+			raft.releaseLease(p, uint64(l.id))
+		}
+		return errors.Wrap(err, "lock was not acquired")
+	}
+
+	l.mu.Lock()
+	if l.ended {
+		// the lease finished while we waited, and has released everything else already
+		l.mu.Unlock()
+		l.detach(a)
+		a.release(l)
+		return ErrLeaseEnded
+	}
+	a.held = true
+	a.since = time.Now()
+	a.fence = p.reslock.issueFence(p.nextFence(FencingToken(granted)), LockHolder{
+		Path:   path,
+		Lease:  l.id,
+		Role:   l.role,
+		Mode:   mode,
+		Prefix: recursive,
+		Since:  a.since,
+	}).Token
+	l.mu.Unlock()
+
+	a.callHooks(l, LeaseAcquired, l.ttl)
+	return nil
+}
+
+// detach forgets an element that the lease never came to hold
+func (l *LeaseContext) detach(a *leaseAttachment) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, attached := range l.attached {
+		if attached == a {
+			l.attached = append(l.attached[:i], l.attached[i+1:]...)
+			return
+		}
+	}
+}
+
+// held lists the elements the lease holds
+func (l *LeaseContext) held() []*leaseAttachment {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.heldLocked()
+}
+
+// heldLocked lists the elements the lease holds, the caller must hold l.mu
+func (l *LeaseContext) heldLocked() (held []*leaseAttachment) {
+	for _, a := range l.attached {
+		if a.held {
+			held = append(held, a)
+		}
+	}
+	return held
+}
+
+// release unlocks the element, and gives it back to the cluster if raft is enabled
+func (a *leaseAttachment) release(lease *LeaseContext) {
+	a.elem.reslock.retireFence(a.fence)
+	a.unlock(lease)
+	if raft := a.elem.clusterLeases(); raft != nil {
+		raft.releaseLease(a.elem, uint64(lease.id))
+	}
+}
+
+// unlock releases the element as the regular unlocks do, so watchers and cluster peers are notified
+// of the lease's role giving it up
+func (a *leaseAttachment) unlock(lease *LeaseContext) {
+	shared, owner := a.mode == LeaseShared, lockOwner{lease: lease.id}
+	var released bool
+	switch {
+	case shared && a.recursive:
+		released = a.elem.runlockSubs(lease.role, owner)
+	case shared:
+		released = a.elem.runlock(lease.role, owner)
+	case a.recursive:
+		released = a.elem.unlockSubs(lease.role, owner)
+	default:
+		released = a.elem.unlock(lease.role, owner)
+	}
+	if !released {
+		return
+	}
+	a.elem.replicate(&peerpb.Mutation{Op: peerpb.Operation_OPERATION_UNLOCK, Recursive: a.recursive, Shared: shared, Actor: lease.role.Name})
+}
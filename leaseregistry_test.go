@@ -0,0 +1,124 @@
+package whatnot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/databeast/whatnot/access"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseRegistry(t *testing.T) {
+	t.Run("Leases are registered under unique IDs", leasesHaveUniqueIDs)
+	t.Run("Unrelated elements are attached to one lease", elementsAttachedToOneLease)
+	t.Run("Every attached element is released when the lease expires", attachedReleasedOnExpiry)
+	t.Run("Leases are revoked by ID", leasesRevokedByID)
+	t.Run("Overlapping elements cannot be attached twice", overlappingAttachRefused)
+}
+
+func leasesHaveUniqueIDs(t *testing.T) {
+	gns := createTestNamespace(t)
+	first, _ := gns.FetchOrCreateAbsolutePath("/registry/first")
+	second, _ := gns.FetchOrCreateAbsolutePath("/registry/second")
+
+	a, releaseA := first.LockWithLease(time.Minute)
+	defer releaseA()
+	b, releaseB := second.LockWithLease(time.Minute)
+	defer releaseB()
+	assert.NotEqual(t, a.ID(), b.ID(), "leases were given the same ID")
+	assert.Equal(t, a, gns.FetchLease(a.ID()), "lease was not found by its ID")
+	assert.ElementsMatch(t, []*LeaseContext{a, b}, gns.Leases())
+
+	releaseA()
+	assert.Eventually(t, func() bool { return gns.FetchLease(a.ID()) == nil }, time.Second, time.Millisecond*10, "released lease was still registered")
+	assert.Equal(t, []*LeaseContext{b}, gns.Leases())
+}
+
+func elementsAttachedToOneLease(t *testing.T) {
+	gns := createTestNamespace(t)
+	order, _ := gns.FetchOrCreateAbsolutePath("/orders/42")
+	stock, _ := gns.FetchOrCreateAbsolutePath("/inventory/sku-9")
+
+	lease, release := gns.GrantLease(time.Minute)
+	assert.Empty(t, lease.Elements(), "new lease already holds elements")
+	assert.Nil(t, lease.Attach(order), "attaching element failed")
+	assert.Nil(t, lease.AttachPrefix(stock), "attaching prefix failed")
+	assert.ElementsMatch(t, []*PathElement{order, stock}, lease.Elements())
+	assert.True(t, order.reslock.isLocked() && stock.reslock.isLocked(), "attached elements were not locked")
+
+	release()
+	assert.Eventually(t, func() bool {
+		return !order.reslock.isLocked() && !stock.reslock.isLocked()
+	}, time.Second, time.Millisecond*10, "attached elements were not released with the lease")
+	assert.Equal(t, ErrLeaseEnded, lease.Attach(order), "element was attached to a released lease")
+}
+
+func attachedReleasedOnExpiry(t *testing.T) {
+	gns := createTestNamespace(t)
+	var elems []*PathElement
+	for _, path := range []PathString{"/a", "/b/c", "/d/e/f"} {
+		elem, _ := gns.FetchOrCreateAbsolutePath(path)
+		elems = append(elems, elem)
+	}
+	lease, release := gns.GrantLease(time.Millisecond * 200)
+	defer release()
+	for _, elem := range elems {
+		assert.Nil(t, lease.Attach(elem), "attaching element failed")
+	}
+
+	select {
+	case <-lease.Done():
+		assert.Equal(t, context.DeadlineExceeded, lease.Err(), "expired lease reports the wrong error")
+	case <-time.After(time.Second * 2):
+		t.Error("lease did not expire")
+		return
+	}
+	for _, elem := range elems {
+		locked := make(chan struct{})
+		go func(elem *PathElement) {
+			elem.Lock()
+			close(locked)
+		}(elem)
+		select {
+		case <-locked:
+			elem.UnLock()
+		case <-time.After(time.Second):
+			t.Errorf("%s was still held once its lease expired", elem.AbsolutePath().ToPathString())
+		}
+	}
+	assert.Nil(t, gns.FetchLease(lease.ID()), "expired lease was still registered")
+}
+
+func leasesRevokedByID(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/revoked")
+
+	lease, release := elem.LockWithLease(time.Minute)
+	defer release()
+	assert.Nil(t, gns.RevokeLease(lease.ID(), access.Role{Name: "operator"}), "revoking lease failed")
+	select {
+	case <-lease.Done():
+	case <-time.After(time.Second):
+		t.Error("revoked lease did not end")
+		return
+	}
+	assert.Eventually(t, func() bool { return !elem.reslock.isLocked() }, time.Second, time.Millisecond*10, "revoked lease kept its lock")
+	assert.Equal(t, ErrLeaseNotFound, gns.RevokeLease(lease.ID(), access.Role{Name: "operator"}), "ended lease was revoked again")
+}
+
+func overlappingAttachRefused(t *testing.T) {
+	gns := createTestNamespace(t)
+	parent, _ := gns.FetchOrCreateAbsolutePath("/overlap")
+	child, _ := gns.FetchOrCreateAbsolutePath("/overlap/child")
+
+	lease, release := gns.GrantLease(time.Minute)
+	defer release()
+	assert.Nil(t, lease.AttachPrefix(parent), "attaching prefix failed")
+	assert.NotNil(t, lease.Attach(child), "element under an attached prefix was attached again")
+	assert.NotNil(t, lease.Attach(parent), "attached element was attached again")
+
+	other := NewNamespace("other")
+	elsewhere, _ := other.FetchOrCreateAbsolutePath("/overlap")
+	assert.NotNil(t, lease.Attach(elsewhere), "element of another namespace was attached")
+}
@@ -6,70 +6,311 @@ import (
 	"time"
 
 	"github.com/databeast/whatnot/access"
+	"github.com/databeast/whatnot/peerpb"
+	"github.com/pkg/errors"
 )
 
 // reslock operations that take longer that this are considered failed
 const defaultLockAttemptTimeout = time.Second * 1
 
+// ErrLockUnavailable is returned by TryLock and TryLockSubs when the lock is still held by another
+// once defaultLockAttemptTimeout has passed
+var ErrLockUnavailable = errors.New("lock was not available in time")
+
+// lockMode is a way of holding an element's resource lock
+// prefix locks hold S or X on their element, which covers everything beneath it, and every lock holds the matching
+// intention, IS or IX, on each element above its own. A prefix lock and a lock beneath it then meet on at least one
+// element in incompatible modes, without the prefix lock visiting any of the elements beneath it
+type lockMode int
+
+const (
+	modeIS    lockMode = iota // intends to share something beneath the element
+	modeIX                    // intends to hold something beneath the element exclusively
+	modeS                     // shares the element and everything beneath it
+	modeX                     // holds the element and everything beneath it exclusively
+	modeSelfS                 // shares the element alone, alongside IS on it
+	modeSelfX                 // holds the element alone exclusively, alongside IX on it
+	lockModes
+)
+
+// lockCompatible is which modes may be held on one element at the same time
+// locks on an element alone also hold an intention on it, so the prefix modes already keep them out
+var lockCompatible = [lockModes][lockModes]bool{
+	modeIS:    {modeIS: true, modeIX: true, modeS: true, modeX: false, modeSelfS: true, modeSelfX: true},
+	modeIX:    {modeIS: true, modeIX: true, modeS: false, modeX: false, modeSelfS: true, modeSelfX: true},
+	modeS:     {modeIS: true, modeIX: false, modeS: true, modeX: false, modeSelfS: true, modeSelfX: true},
+	modeX:     {modeIS: false, modeIX: false, modeS: false, modeX: false, modeSelfS: true, modeSelfX: true},
+	modeSelfS: {modeIS: true, modeIX: true, modeS: true, modeX: true, modeSelfS: true, modeSelfX: false},
+	modeSelfX: {modeIS: true, modeIX: true, modeS: true, modeX: true, modeSelfS: false, modeSelfX: false},
+}
+
+// lockModesFor are the modes a lock holds on its own element, and the intention it holds on each element above
+func lockModesFor(recursive bool, shared bool) (own []lockMode, intention lockMode) {
+	switch {
+	case recursive && shared:
+		return []lockMode{modeS}, modeIS
+	case recursive:
+		return []lockMode{modeX}, modeIX
+	case shared:
+		return []lockMode{modeIS, modeSelfS}, modeIS
+	default:
+		return []lockMode{modeIX, modeSelfX}, modeIX
+	}
+}
+
 // resourceLock is a Temporary Locking Semaphore on an namespace element resource
+// it counts the holders of each lockMode, any number of which may hold it at once in compatible modes
 type resourceLock struct {
 	logsupport
-	selfmu    *sync.Mutex // mutex for modifying myself
-	resmu     *sync.Mutex // mutex for modifying my attached Path Element
-	islocked  bool        // readable state flag to making mutex state knowable
-	recursive bool        // does this resource lock cover child Path Elements?
-	Role      access.Role // APi Role that is keeping this locked
-	deadline  context.Context
+	selfmu   *sync.Mutex                  // mutex for modifying myself
+	released chan struct{}                // closed and replaced whenever a hold is given up, waking everything waiting on it
+	held     [lockModes]int               // holders of each mode
+	queue    []*lockWaiter                // waiting for the lock, by priority then arrival, none overtaking one it conflicts with
+	owners   map[lockOwner][lockModes]int // modes held by each owner, including its intentions
+
+	fence   FencingToken                // the last token issued with a lease on the element, if it has no namespace to count them
+	holders map[FencingToken]LockHolder // the leases holding the element, by the token each was issued
 }
 
-// unlockAfterExpire sets the given Path Element to remove any leases on it after the given duration
-func (p *PathElement) unlockAfterExpire() {
+// unlockAfterExpire releases every element attached to the lease together once the lease finishes
+// no element can be attached after this, so the lease never holds some elements while others are released
+func (lease *LeaseContext) unlockAfterExpire() {
 	go func() {
-		select {
-		case <-p.reslock.deadline.Done():
-			if p.reslock.recursive {
-				unlockWg := &sync.WaitGroup{}
-				unlockWg.Add(1)
-				go p.asyncRecursiveUnLockSelfAndSubs(unlockWg)
-				unlockWg.Wait()
-			} else {
-				p.reslock.resmu.Unlock()
-			}
+		<-lease.ctx.Done()
+		lease.expire.Stop()
+		lease.mu.Lock()
+		lease.ended = true
+		held := lease.heldLocked()
+		lease.attached = nil
+		lease.mu.Unlock()
+
+		if lease.ns != nil {
+			lease.ns.leases.remove(lease.id)
+		}
+		event := lease.endedAs()
+		for _, a := range held {
+			a.release(lease)
+			a.callHooks(lease, event, lease.ttl)
 		}
 	}()
 }
 
-func (r *resourceLock) lock(recursive bool) {
+func newResourceLock() resourceLock {
+	return resourceLock{
+		selfmu:   &sync.Mutex{},
+		released: make(chan struct{}),
+		holders:  make(map[FencingToken]LockHolder),
+		owners:   make(map[lockOwner][lockModes]int),
+	}
+}
+
+// acquire queues for the lock until every one of the modes can be held alongside the current holders, and
+// no one queued ahead wants a mode they conflict with, then holds them all
+// it returns the context's error, holding none of them, should the context finish first
+// or a DeadlockError should it be failed to break a deadlock between the leases in the namespace's wait graph
+func (r *resourceLock) acquire(ctx context.Context, waits *waitGraph, req *LockWaiter, modes ...lockMode) error {
+	w := &lockWaiter{req: req, modes: modes}
+	defer waits.forget(w)
 	r.selfmu.Lock()
-	if r.islocked {
+	defer r.selfmu.Unlock()
+	r.enqueue(w)
+	defer r.dequeue(w)
+	for !r.available(w) {
+		// the wait graph visits other locks, so cannot be checked while holding this one
+		r.selfmu.Unlock()
+		waits.check(r, w)
+		r.selfmu.Lock()
+		if w.broken != nil {
+			return w.broken
+		}
+		if r.available(w) {
+			break
+		}
 		r.Debug("waiting to claim additional lock")
+		if err := r.wait(ctx); err != nil {
+			return err
+		}
+		if w.broken != nil {
+			return w.broken
+		}
+	}
+	owned := r.owners[req.owner()]
+	for _, m := range modes {
+		r.held[m]++
+		owned[m]++
 	}
+	r.owners[req.owner()] = owned
+	return nil
+}
 
-	r.resmu.Lock()
-	r.recursive = recursive
-	r.islocked = true
+// available reports if the waiter's modes are compatible with every holder, and with every waiter ahead of it
+// the caller must hold selfmu
+func (r *resourceLock) available(w *lockWaiter) bool {
+	for _, m := range w.modes {
+		for other := lockMode(0); other < lockModes; other++ {
+			if r.held[other] > 0 && !lockCompatible[m][other] {
+				return false
+			}
+		}
+	}
+	return !r.blockedInQueue(w)
+}
 
+// wait gives up selfmu until the lock is next released or the context finishes, the caller must hold selfmu
+// never hold selfmu while waiting, or release() can never get in
+func (r *resourceLock) wait(ctx context.Context) error {
+	released := r.released
 	r.selfmu.Unlock()
+	select {
+	case <-released:
+		r.selfmu.Lock()
+		return nil
+	case <-ctx.Done():
+		r.selfmu.Lock()
+		return ctx.Err()
+	}
+}
+
+// wake lets everything waiting on the lock try again, the caller must hold selfmu
+func (r *resourceLock) wake() {
+	close(r.released)
+	r.released = make(chan struct{})
 }
 
-func (r *resourceLock) unlock() {
+// release gives up a hold on each of the modes held by the owner, reporting if it had them all
+// an owner cannot give up holds it does not have, so a stray or repeated release leaves every holder as it was
+func (r *resourceLock) release(owner lockOwner, modes ...lockMode) (released bool) {
 	r.selfmu.Lock()
-	if r.islocked == false {
-		r.Warn("ignoring call to unlock already unlocked reslock")
-		r.resmu.Unlock()
-		return
+	defer r.selfmu.Unlock()
+	owned := r.owners[owner]
+	var giving [lockModes]int
+	for _, m := range modes {
+		if giving[m]++; owned[m] < giving[m] {
+			r.Warnf("ignoring call to unlock reslock not held by %s", owner)
+			return false
+		}
 	}
-	r.resmu.Unlock()
-	r.islocked = false
-	r.selfmu.Unlock()
+	for _, m := range modes {
+		owned[m]--
+		r.held[m]--
+	}
+	empty := true
+	for _, count := range owned {
+		empty = empty && count == 0
+	}
+	if empty {
+		delete(r.owners, owner)
+	} else {
+		r.owners[owner] = owned
+	}
+	r.wake()
+	return true
+}
+
+// isLocked reports if the lock is currently held exclusively, either alone or as part of a prefix
+func (r *resourceLock) isLocked() bool {
+	r.selfmu.Lock()
+	defer r.selfmu.Unlock()
+	return r.held[modeX] > 0 || r.held[modeSelfX] > 0
+}
+
+// sharedBy reports how many holders currently share the lock, either alone or as part of a prefix
+func (r *resourceLock) sharedBy() int {
+	r.selfmu.Lock()
+	defer r.selfmu.Unlock()
+	return r.held[modeS] + r.held[modeSelfS]
+}
+
+// ancestors lists the elements above this one, from the root of its namespace down
+func (p *PathElement) ancestors() (above []*PathElement) {
+	for a := p.parent; a != nil; a = a.parent {
+		above = append([]*PathElement{a}, above...)
+	}
+	return above
+}
+
+// lockHierarchy takes the intention locks on every element above this one, always from the root down so that
+// lockers never wait on each other in opposite orders, then locks this element itself
+// should the context finish first, whatever was already taken is given up again
+func (p *PathElement) lockHierarchy(ctx context.Context, recursive bool, shared bool) error {
+	own, intention := lockModesFor(recursive, shared)
+	req := newLockRequest(ctx, p, recursive, shared)
+	var waits *waitGraph
+	if p.namespace != nil {
+		waits = p.namespace.waits
+	}
+	above := p.ancestors()
+	for i, a := range above {
+		if err := a.reslock.acquire(ctx, waits, req, intention); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				above[j].reslock.release(req.owner(), intention)
+			}
+			return err
+		}
+	}
+	if err := p.reslock.acquire(ctx, waits, req, own...); err != nil {
+		for j := len(above) - 1; j >= 0; j-- {
+			above[j].reslock.release(req.owner(), intention)
+		}
+		return err
+	}
+	return nil
+}
+
+// unlockHierarchy gives up a lock taken by lockHierarchy, from this element back up to the root
+// it reports if the owner held the lock, leaving everything as it was if not
+func (p *PathElement) unlockHierarchy(recursive bool, shared bool, owner lockOwner) bool {
+	own, intention := lockModesFor(recursive, shared)
+	if !p.reslock.release(owner, own...) {
+		return false
+	}
+	above := p.ancestors()
+	for j := len(above) - 1; j >= 0; j-- {
+		above[j].reslock.release(owner, intention)
+	}
+	return true
 }
 
 // Lock places a Mutex on this pathElement
 // and sends a notification of this lock to its chain of parent elements
 // this also fulfills the interface Sync.Locker
 func (p *PathElement) Lock() {
-	p.reslock.lock(false)
-	p.selfnotify <- elementChange{id: randid.Uint64(), elem: p, change: ChangeLocked}
+	p.lock()
+	p.replicate(&peerpb.Mutation{Op: peerpb.Operation_OPERATION_LOCK})
+}
+
+func (p *PathElement) lock() {
+	_ = p.lockContext(context.Background())
+}
+
+// LockContext places a Mutex on this pathElement as Lock does, unless the context finishes first
+// in which case it returns the context's error, and the element is left as it was
+// the lock's watch event carries the role from access.WithRole, if the context has one
+func (p *PathElement) LockContext(ctx context.Context) error {
+	if err := p.lockContext(ctx); err != nil {
+		return err
+	}
+	p.replicate(&peerpb.Mutation{Op: peerpb.Operation_OPERATION_LOCK, Actor: roleOf(ctx).Name})
+	return nil
+}
+
+func (p *PathElement) lockContext(ctx context.Context) error {
+	if err := p.lockHierarchy(ctx, false, false); err != nil {
+		return err
+	}
+	p.selfnotify <- elementChange{id: randid.Uint64(), elem: p, change: ChangeLocked, actor: roleOf(ctx)}
+	return nil
+}
+
+// TryLock places a Mutex on this pathElement, returning ErrLockUnavailable rather than waiting
+// any longer than defaultLockAttemptTimeout for another holder to release it
+func (p *PathElement) TryLock() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultLockAttemptTimeout)
+	defer cancel()
+	if p.LockContext(ctx) != nil {
+		return ErrLockUnavailable
+	}
+	return nil
 }
 
 // UnLock will release the Mutex Lock on this path element
@@ -77,56 +318,77 @@ func (p *PathElement) Lock() {
 // unlocking will sent a notification event to the chain of parent elements
 // this also fulfills the interface Sync.Locker
 func (p *PathElement) UnLock() {
+	if p.unlock(access.Role{}, lockOwner{}) {
+		p.replicate(&peerpb.Mutation{Op: peerpb.Operation_OPERATION_UNLOCK})
+	}
+}
+
+// unlock reports if the owner held the lock, watchers are only told of locks that were given up
+func (p *PathElement) unlock(actor access.Role, owner lockOwner) bool {
 	//NOTE: Subs will Remain Locked when doing this.
-	p.reslock.unlock()
-	p.selfnotify <- elementChange{id: randid.Uint64(), elem: p, change: ChangeUnlocked}
+	if !p.unlockHierarchy(false, false, owner) {
+		return false
+	}
+	p.selfnotify <- elementChange{id: randid.Uint64(), elem: p, change: ChangeUnlocked, actor: actor}
+	return true
 }
 
 // LockSubs will lock this Path Element and every Path Element it is a parent to
 func (p *PathElement) LockSubs() {
-	// TODO:  Implement deadlock timeouts and recovery
-	lockwg := &sync.WaitGroup{}
-	lockwg.Add(1)
-	p.asyncRecursiveLockSelfAndSubs(lockwg)
-	lockwg.Wait()
-	p.selfnotify <- elementChange{id: randid.Uint64(), elem: p, change: ChangeLocked}
+	p.lockSubs()
+	p.replicate(&peerpb.Mutation{Op: peerpb.Operation_OPERATION_LOCK, Recursive: true})
 }
 
-func (p *PathElement) UnLockSubs() {
-	// TODO:  Implement deadlock timeouts and recovery
-	unlockwg := &sync.WaitGroup{}
-	unlockwg.Add(1)
-	p.asyncRecursiveUnLockSelfAndSubs(unlockwg)
-	unlockwg.Wait()
-	p.selfnotify <- elementChange{elem: p, change: ChangeUnlocked}
+func (p *PathElement) lockSubs() {
+	_ = p.lockSubsContext(context.Background())
 }
 
-func (p *PathElement) asyncRecursiveLockSelfAndSubs(parentwg *sync.WaitGroup) {
-	p.reslock.lock(true) // reslock myself first
-
-	if len(p.children) > 0 {
-		subLockWg := &sync.WaitGroup{}
-		subLockWg.Add(len(p.children)) // always increment the waitgroup delta before allowing anything to start
+// LockSubsContext locks this Path Element and every Path Element it is a parent to as LockSubs does,
+// unless the context finishes first, in which case it returns the context's error and none of them are left locked
+func (p *PathElement) LockSubsContext(ctx context.Context) error {
+	if err := p.lockSubsContext(ctx); err != nil {
+		return err
+	}
+	p.replicate(&peerpb.Mutation{Op: peerpb.Operation_OPERATION_LOCK, Recursive: true, Actor: roleOf(ctx).Name})
+	return nil
+}
 
-		for _, v := range p.children {
-			go v.asyncRecursiveLockSelfAndSubs(subLockWg)
-		}
-		subLockWg.Wait()
+func (p *PathElement) lockSubsContext(ctx context.Context) error {
+	if err := p.lockHierarchy(ctx, true, false); err != nil {
+		return err
 	}
-	parentwg.Done()
+	p.selfnotify <- elementChange{id: randid.Uint64(), elem: p, change: ChangeLocked, actor: roleOf(ctx)}
+	return nil
 }
 
-func (p *PathElement) asyncRecursiveUnLockSelfAndSubs(parentwg *sync.WaitGroup) {
-	p.reslock.unlock() // unlock myself first
+// TryLockSubs locks this Path Element and every Path Element it is a parent to, returning ErrLockUnavailable
+// rather than waiting any longer than defaultLockAttemptTimeout for any of them
+func (p *PathElement) TryLockSubs() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultLockAttemptTimeout)
+	defer cancel()
+	if p.LockSubsContext(ctx) != nil {
+		return ErrLockUnavailable
+	}
+	return nil
+}
 
-	subUnlockwg := &sync.WaitGroup{}
+// UnLockSubs will release this Path Element and every Path Element it is a parent to
+func (p *PathElement) UnLockSubs() {
+	if p.unlockSubs(access.Role{}, lockOwner{}) {
+		p.replicate(&peerpb.Mutation{Op: peerpb.Operation_OPERATION_UNLOCK, Recursive: true})
+	}
+}
 
-	for _, v := range p.children {
-		subUnlockwg.Add(1) // always increment the waitgroup delta before allowing anything to start
-		go v.asyncRecursiveUnLockSelfAndSubs(subUnlockwg)
+func (p *PathElement) unlockSubs(actor access.Role, owner lockOwner) bool {
+	if !p.unlockHierarchy(true, false, owner) {
+		return false
 	}
-	subUnlockwg.Wait()
-	p.Debugf("%s has finished unlocking all its children", p.section)
+	p.selfnotify <- elementChange{id: randid.Uint64(), elem: p, change: ChangeUnlocked, actor: actor}
+	return true
+}
 
-	parentwg.Done()
+// roleOf is the role a lock is taken with, empty if the context was given none
+func roleOf(ctx context.Context) access.Role {
+	role, _ := access.RoleFromContext(ctx)
+	return role
 }
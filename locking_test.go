@@ -1,8 +1,12 @@
 package whatnot
 
 import (
+	"context"
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/databeast/whatnot/access"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -10,6 +14,14 @@ func TestElementLocking(t *testing.T) {
 	t.Run("Lock Single Element", lockSingleElement)
 	t.Run("Lock Element Prefix", lockElementPrefix)
 	t.Run("Queue Locks on single element", lockandUnlockSingleElement)
+	t.Run("TryLock gives up on a held element", tryLockGivesUp)
+	t.Run("LockContext stops waiting when its context ends", lockContextStopsWaiting)
+	t.Run("LockSubsContext leaves nothing locked when it gives up", lockSubsContextLeavesNothingLocked)
+	t.Run("Lease requests give up when their lease ends", leaseRequestBounded)
+	t.Run("Prefix locks conflict with locks beneath them", prefixConflictsWithDescendants)
+	t.Run("Locks on an element alone do not cover its children", elementLocksDoNotCoverChildren)
+	t.Run("Prefix locks leave the elements beneath them untouched", prefixLocksVisitOnlyAncestors)
+	t.Run("Unlocks only give up holds their locker has", unlocksOnlyGiveUpOwnHolds)
 }
 
 func lockSingleElement(t *testing.T) {
@@ -61,3 +73,141 @@ func lockElementPrefix(t *testing.T) {
 func lockandUnlockSingleElement(t *testing.T) {
 	t.Log("Creating a Path Element, locking it, and testing it remains locked until manually unlocked")
 }
+
+func tryLockGivesUp(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/try/lock")
+
+	assert.Nil(t, elem.TryLock(), "locking a free element failed")
+	start := time.Now()
+	assert.Equal(t, ErrLockUnavailable, elem.TryLock(), "held element was locked again")
+	assert.True(t, time.Since(start) < defaultLockAttemptTimeout*2, "TryLock waited beyond its timeout")
+	elem.UnLock()
+	assert.Nil(t, elem.TryLock(), "locking a released element failed")
+	elem.UnLock()
+}
+
+func lockContextStopsWaiting(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/context/lock")
+	elem.Lock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, elem.LockContext(ctx), "held element was locked again")
+
+	acquired := make(chan error)
+	go func() { acquired <- elem.LockContext(context.Background()) }()
+	elem.UnLock()
+	select {
+	case err := <-acquired:
+		assert.Nil(t, err, "locking a released element failed")
+		elem.UnLock()
+	case <-time.After(time.Second):
+		t.Error("waiter was not given the released lock")
+	}
+}
+
+func lockSubsContextLeavesNothingLocked(t *testing.T) {
+	gns := createTestNamespace(t)
+	prefix, _ := gns.FetchOrCreateAbsolutePath("/subs")
+	free, _ := gns.FetchOrCreateAbsolutePath("/subs/free")
+	held, _ := gns.FetchOrCreateAbsolutePath("/subs/held")
+	held.Lock()
+
+	assert.Equal(t, ErrLockUnavailable, prefix.TryLockSubs(), "prefix was locked over a held element")
+	assert.False(t, prefix.reslock.isLocked(), "prefix was left locked")
+	assert.False(t, free.reslock.isLocked(), "free child was left locked")
+
+	held.UnLock()
+	assert.Nil(t, prefix.TryLockSubs(), "locking a free prefix failed")
+	prefix.UnLockSubs()
+}
+
+func leaseRequestBounded(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/bounded")
+	elem.Lock()
+	defer elem.UnLock()
+
+	start := time.Now()
+	lease, _ := elem.LockWithLease(time.Millisecond * 200)
+	assert.True(t, time.Since(start) < time.Second, "lease request waited beyond its ttl")
+	assert.Equal(t, context.DeadlineExceeded, lease.Err(), "lease was granted on a held element")
+	assert.Empty(t, lease.Elements(), "lease holds an element it never locked")
+}
+
+func prefixConflictsWithDescendants(t *testing.T) {
+	gns := createTestNamespace(t)
+	prefix, _ := gns.FetchOrCreateAbsolutePath("/a")
+	deep, _ := gns.FetchOrCreateAbsolutePath("/a/b/c")
+
+	deep.Lock()
+	assert.Equal(t, ErrLockUnavailable, prefix.TryLockSubs(), "prefix was locked over a locked element beneath it")
+	deep.UnLock()
+
+	prefix.LockSubs()
+	assert.Equal(t, ErrLockUnavailable, deep.TryLock(), "element beneath a locked prefix was locked")
+	assert.Equal(t, ErrLockUnavailable, prefix.TryLock(), "locked prefix was locked again")
+	prefix.UnLockSubs()
+	assert.Nil(t, deep.TryLock(), "element was not free once the prefix was unlocked")
+	deep.UnLock()
+}
+
+func elementLocksDoNotCoverChildren(t *testing.T) {
+	gns := createTestNamespace(t)
+	parent, _ := gns.FetchOrCreateAbsolutePath("/alone")
+	child, _ := gns.FetchOrCreateAbsolutePath("/alone/child")
+
+	parent.Lock()
+	assert.Nil(t, child.TryLock(), "child was kept locked by a lock on its parent alone")
+	assert.Equal(t, ErrLockUnavailable, parent.TryLockSubs(), "prefix was locked over a held element")
+	child.UnLock()
+	parent.UnLock()
+}
+
+func prefixLocksVisitOnlyAncestors(t *testing.T) {
+	gns := createTestNamespace(t)
+	prefix, _ := gns.FetchOrCreateAbsolutePath("/wide")
+	var children []*PathElement
+	for i := 0; i < 100; i++ {
+		child, _ := gns.FetchOrCreateAbsolutePath(PathString(fmt.Sprintf("/wide/%d/leaf", i)))
+		children = append(children, child)
+	}
+
+	prefix.LockSubs()
+	defer prefix.UnLockSubs()
+	for _, child := range children {
+		child.reslock.selfmu.Lock()
+		held := child.reslock.held
+		child.reslock.selfmu.Unlock()
+		if !assert.Equal(t, [lockModes]int{}, held, "prefix lock took a lock beneath it") {
+			return
+		}
+	}
+	gns.root.reslock.selfmu.Lock()
+	assert.Equal(t, 1, gns.root.reslock.held[modeIX], "prefix lock did not hold its intention on the root")
+	gns.root.reslock.selfmu.Unlock()
+}
+
+func unlocksOnlyGiveUpOwnHolds(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/owned/element")
+
+	lease, release := elem.LockWithLease(time.Minute)
+	defer release()
+	// nothing was locked without a lease, so there is nothing to give up, tell watchers of or replicate
+	assert.False(t, elem.unlock(access.Role{}, lockOwner{}), "unlock without a lease released a leased lock")
+	assert.True(t, elem.reslock.isLocked(), "unlock without a lease released a leased lock")
+	assert.False(t, elem.reslock.release(lockOwner{lease: lease.ID() + 1}, modeIX, modeSelfX), "another lease released a lock it did not hold")
+	assert.True(t, elem.reslock.isLocked(), "another lease released a lock it did not hold")
+
+	release()
+	assert.Eventually(t, func() bool { return !elem.reslock.isLocked() }, time.Second, time.Millisecond*10, "lease did not release its own lock")
+
+	shared, _ := gns.FetchOrCreateAbsolutePath("/owned/shared")
+	shared.RLock()
+	defer shared.RUnLock()
+	assert.False(t, shared.runlock(access.Role{}, lockOwner{peer: "elsewhere"}), "a peer's unlock released a lock taken here")
+	assert.Equal(t, 1, shared.reslock.sharedBy(), "a peer's unlock released a lock taken here")
+}
@@ -11,6 +11,17 @@ import (
 type NameSpaceManager struct {
 	namespaces map[string]*Namespace
 	mu         *mutex.SmartMutex
+	peering    *peerSync // replication to other instances, if enabled
+	static     []string  // peer addresses declared with WithPeers
+	gossipopts *WithGossipConfig
+	gossip     *gossipDiscovery
+	raftopts   *WithRaftConfig
+	raft       *raftNode
+	members    *membershipNotifier
+	clock      *hybridClock // versions local changes to element values
+	partition  *partitionDetector
+	// fail the youngest lease waiting in a deadlock, rather than only logging it, set by WithDeadlockBreak
+	breakDeadlocks bool
 	logsupport
 }
 
@@ -21,26 +32,70 @@ func NewNamespaceManager(opts ...ManagerOption) (nsm *NameSpaceManager, err erro
 	nsm = &NameSpaceManager{
 		mu:         mutex.New(fmt.Sprintf("NameSpace Manager mutex")),
 		namespaces: make(map[string]*Namespace),
+		members:    newMembershipNotifier(),
 	}
+	// options only record their configuration, nothing is started until all of them have been checked
 	for _, o := range opts {
-		err = o.apply(nsm)
-		if err != nil {
-			return nil, err
+		if err = o.apply(nsm); err != nil {
+			break
 		}
 	}
+	if err == nil {
+		err = nsm.checkOptions()
+	}
+	if err == nil {
+		nsm.clock = newHybridClock(nsm.nodeName())
+		err = nsm.start()
+	}
+	if err != nil {
+		_ = nsm.Close() // stop whatever had already started, and the listener WithPeerSync was given
+		return nil, err
+	}
 	return nsm, nil
 }
 
+// checkOptions reports configuration errors that depend on more than one option
+func (m *NameSpaceManager) checkOptions() error {
+	if len(m.static) > 0 && m.peering == nil {
+		return newConfigError("WithPeers requires WithPeerSync to be configured")
+	}
+	if m.partition != nil && m.raftopts == nil && m.peering == nil {
+		return newConfigError("WithPartitionDetection requires WithRaft or WithPeerSync to be configured")
+	}
+	return m.checkRaft()
+}
+
+// start begins replication, discovery and raft, once every option has been checked
+func (m *NameSpaceManager) start() (err error) {
+	if m.peering != nil {
+		m.peering.serve()
+	}
+	if err = m.joinPeers(); err != nil {
+		return err
+	}
+	if err = m.startGossip(); err != nil {
+		return err
+	}
+	if err = m.startRaft(); err != nil {
+		return err
+	}
+	return m.startPartitionDetection()
+}
+
 // RegisterNamespace actives a name Namespace into the list of actively available and
 // subscribable namespaces
 func (m *NameSpaceManager) RegisterNamespace(ns *Namespace) error {
 
-	if _, ok := m.namespaces[ns.name]; ok { // fail if already registered
-		return errors.Errorf("refusing to register already-registered name")
-	}
-
 	m.mu.Lock()
+	if existing, ok := m.namespaces[ns.name]; ok { // fail if already registered
+		if !existing.fromSnapshot {
+			m.mu.Unlock()
+			return errors.Errorf("refusing to register already-registered name")
+		}
+		ns.adopt(existing)
+	}
 	m.namespaces[ns.name] = ns
+	ns.manager = m
 	go ns.pruningcheck()
 	m.mu.Unlock()
 
@@ -60,6 +115,7 @@ func (m *NameSpaceManager) UnRegisterNamespace(ns *Namespace) error {
 
 	m.mu.Lock()
 	delete(m.namespaces, ns.name) // TODO: this needs a better collapsing method than just deleting this reference
+	ns.manager = nil
 	m.mu.Unlock()
 
 	return nil
@@ -75,3 +131,39 @@ func (m *NameSpaceManager) FetchNamespace(name string) (ns *Namespace, err error
 		return nil, errors.Errorf("no such namespaces: %q", name)
 	}
 }
+
+// Namespaces lists the names of every registered Namespace, in no particular order
+func (m *NameSpaceManager) Namespaces() (names []string) {
+	m.mu.Lock()
+	for name := range m.namespaces {
+		names = append(names, name)
+	}
+	m.mu.Unlock()
+	return names
+}
+
+// nodeName identifies this instance amongst its peers, if it has any
+func (m *NameSpaceManager) nodeName() string {
+	if m.peering == nil {
+		return ""
+	}
+	return m.peering.node
+}
+
+// Close stops all replication to and from other instances
+// the Namespaces of this manager remain usable locally
+func (m *NameSpaceManager) Close() error {
+	if m.partition != nil {
+		m.partition.close()
+	}
+	if m.raft != nil {
+		m.raft.close()
+	}
+	if m.gossip != nil {
+		m.gossip.close()
+	}
+	if m.peering != nil {
+		m.peering.close()
+	}
+	return nil
+}
@@ -15,6 +15,9 @@ const (
 	optionRateLimit      optionName = "lease rate limiting"
 	optionLogger         optionName = "custom log output"
 	optionPruning        optionName = "unused element pruning"
+	optionPeerSync       optionName = "grpc peer synchronization"
+	optionStaticPeers    optionName = "static cluster membership"
+	optionPartition      optionName = "partition-aware leases"
 )
 
 type ManagerOption interface {
@@ -25,12 +28,13 @@ type ManagerOption interface {
 type managerOptionFunc func() optionName
 
 // WithGossip enables Gossip protocol Cluster member discovery of other instances running
-// the whatNot gRPC connector
+// the whatNot gRPC connector, with the defaults of WithGossipConfig
 var WithGossip managerOptionFunc = func() optionName {
 	return optionDiscoverGossip
 }
 
 // WithRaft enables Raft Quorum synchromization - improving cluster accuracy at a slight speed and bandwith cost
+// as a single-member cluster, use WithRaftConfig to declare other members
 var WithRaft managerOptionFunc = func() optionName {
 	return optionSyncRaft
 }
@@ -41,6 +45,7 @@ var WithTrace managerOptionFunc = func() optionName {
 }
 
 // WithDeadlockBreak turns on Whatnot's Self-healing breaking of Mutex Deadlocks
+// and of leases waiting on each other's elements, failing the youngest waiter with a DeadlockError
 var WithDeadlockBreak managerOptionFunc = func() optionName {
 	return optionBreak
 }
@@ -57,6 +62,14 @@ var WithPruning managerOptionFunc = func() optionName {
 }
 
 func (f managerOptionFunc) apply(manager *NameSpaceManager) (err error) {
+	switch f() {
+	case optionBreak:
+		manager.breakDeadlocks = true
+	case optionDiscoverGossip:
+		return WithGossipConfig{}.apply(manager)
+	case optionSyncRaft:
+		return WithRaftConfig{}.apply(manager)
+	}
 	return
 }
 
@@ -0,0 +1,86 @@
+package whatnot
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+Cluster membership notifications, so applications can react to instances joining and leaving
+*/
+
+type memberChange int
+
+const (
+	MemberJoined memberChange = iota + 1
+	MemberUpdated
+	MemberLeft   // the member announced it was leaving
+	MemberFailed // the member stopped responding without leaving
+)
+
+// MembershipEvent describes a change to the set of instances in the cluster
+type MembershipEvent struct {
+	TS         time.Time
+	Change     memberChange
+	Node       string // the node name of the member
+	GossipAddr string // the address the member gossips on
+	PeerAddr   string // the address of the member's PeerSync service, if it has one
+}
+
+// MembershipSubscription is a contract to be notified of every cluster membership change
+type MembershipSubscription struct {
+	events chan MembershipEvent
+}
+
+// Events returns the channel of membership changes. If the channel is closed, the
+// subscriber was too slow to receive events and should subscribe again
+func (s *MembershipSubscription) Events() <-chan MembershipEvent {
+	return s.events
+}
+
+// membershipNotifier fans out membership events to every subscriber
+type membershipNotifier struct {
+	logsupport
+	mu   *sync.Mutex
+	subs map[*MembershipSubscription]bool
+}
+
+func newMembershipNotifier() *membershipNotifier {
+	return &membershipNotifier{
+		mu:   &sync.Mutex{},
+		subs: make(map[*MembershipSubscription]bool),
+	}
+}
+
+func (n *membershipNotifier) notify(evt MembershipEvent) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for sub := range n.subs {
+		select {
+		case sub.events <- evt:
+		default:
+			n.Debug("removing slow membership subscriber")
+			delete(n.subs, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// SubscribeToMembership generates a subscription to cluster members joining, leaving and failing
+func (m *NameSpaceManager) SubscribeToMembership() *MembershipSubscription {
+	sub := &MembershipSubscription{events: make(chan MembershipEvent, defaultMultiplexerBuffer)}
+	m.members.mu.Lock()
+	m.members.subs[sub] = true
+	m.members.mu.Unlock()
+	return sub
+}
+
+// UnSubscribeFromMembership stops delivery of membership events to the subscription
+func (m *NameSpaceManager) UnSubscribeFromMembership(sub *MembershipSubscription) {
+	m.members.mu.Lock()
+	if _, ok := m.members.subs[sub]; ok {
+		delete(m.members.subs, sub)
+		close(sub.events)
+	}
+	m.members.mu.Unlock()
+}
@@ -0,0 +1,81 @@
+package whatnot
+
+/*
+Several paths locked together under one lease are taken all-or-nothing, and always in the same order whatever order
+they were asked for in. Two callers locking overlapping sets of paths then queue for the first path they share,
+rather than each holding one that the other is waiting for
+*/
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LockTarget is one of the paths locked together by LockPathsWithLease
+type LockTarget struct {
+	Path   AbsolutePath
+	Prefix bool      // lock everything beneath Path as well
+	Mode   LeaseMode // LeaseShared to share the path with other shared leases, rather than hold it alone
+}
+
+// LockPathsWithLease locks every one of the paths under a single lease, creating any that do not exist yet
+// either the lease holds all of them, or it is returned already finished, holding none, with Err reporting why
+// it uses a background context so cannot be cancelled before the lease expires
+func (ns *Namespace) LockPathsWithLease(ttl time.Duration, targets ...LockTarget) (ctx *LeaseContext, release func()) {
+	return ns.ContextLockPathsWithLease(context.Background(), ttl, targets...)
+}
+
+// ContextLockPathsWithLease locks every one of the paths under a single lease as LockPathsWithLease does
+// you provide the context instance to have external control to cancel it before timeout
+func (ns *Namespace) ContextLockPathsWithLease(octx context.Context, ttl time.Duration, targets ...LockTarget) (ctx *LeaseContext, release func()) {
+	ctx = newLease(octx, ns, ttl)
+	if err := ctx.attachAll(targets); err != nil {
+		ns.root.Warnf("lease %s on %d paths was not granted: %s", ctx.id, len(targets), err.Error())
+		ctx.cancelWith(err) // releases whatever was already locked
+	}
+	return ctx, ctx.cancel
+}
+
+// attachAll attaches the targets in their canonical order, stopping at the first that fails
+func (l *LeaseContext) attachAll(targets []LockTarget) error {
+	if len(targets) == 0 {
+		return errors.New("no paths to lock")
+	}
+	ordered := append([]LockTarget(nil), targets...)
+	sort.Slice(ordered, func(i, j int) bool {
+		return comparePaths(ordered[i].Path, ordered[j].Path) < 0
+	})
+	for i := 1; i < len(ordered); i++ {
+		a, b := ordered[i-1], ordered[i]
+		if leasesOverlap(a.Path.ToPathString(), a.Prefix, b.Path.ToPathString(), b.Prefix) {
+			return errors.Errorf("%s overlaps %s", a.Path.ToPathString(), b.Path.ToPathString())
+		}
+	}
+
+	for _, target := range ordered {
+		elem, err := l.ns.FetchOrCreateAbsolutePath(target.Path.ToPathString())
+		if err != nil {
+			return errors.Wrapf(err, "could not create %s", target.Path.ToPathString())
+		}
+		if err = l.attach(elem, target.Prefix, target.Mode); err != nil {
+			return errors.Wrapf(err, "could not lock %s", target.Path.ToPathString())
+		}
+	}
+	return nil
+}
+
+// comparePaths orders paths section by section, so every element comes directly before the elements beneath it
+func comparePaths(a AbsolutePath, b AbsolutePath) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
@@ -0,0 +1,122 @@
+package whatnot
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiPathLocking(t *testing.T) {
+	t.Run("Paths are locked together under one lease", pathsLockedTogether)
+	t.Run("Paths are locked all or nothing", pathsLockedAllOrNothing)
+	t.Run("Overlapping paths are refused", overlappingPathsRefused)
+	t.Run("Concurrent multi-path lockers do not deadlock", multiPathLockersDoNotDeadlock)
+}
+
+func pathsLockedTogether(t *testing.T) {
+	gns := createTestNamespace(t)
+	sku, _ := gns.FetchOrCreateAbsolutePath("/inventory/sku-9")
+
+	lease, release := gns.LockPathsWithLease(time.Minute,
+		LockTarget{Path: PathString("/orders/42").ToAbsolutePath()},
+		LockTarget{Path: PathString("/inventory").ToAbsolutePath(), Prefix: true},
+	)
+	if !assert.Nil(t, lease.Err(), "paths were not locked") {
+		return
+	}
+	order := gns.FetchAbsolutePath("/orders/42")
+	if !assert.NotNil(t, order, "path was not created to be locked") {
+		return
+	}
+	assert.Len(t, lease.Elements(), 2)
+	assert.Equal(t, ErrLockUnavailable, order.TryLock(), "single path was not locked")
+	assert.Equal(t, ErrLockUnavailable, sku.TryLock(), "path beneath a prefix was not locked")
+
+	release()
+	assert.Eventually(t, func() bool { return len(lease.Elements()) == 0 && !order.reslock.isLocked() }, time.Second, time.Millisecond*10, "paths were not released together")
+	assert.Nil(t, sku.TryLock(), "prefix was not released")
+	sku.UnLock()
+}
+
+func pathsLockedAllOrNothing(t *testing.T) {
+	gns := createTestNamespace(t)
+	order, _ := gns.FetchOrCreateAbsolutePath("/orders/42")
+	_, releaseHeld := order.LockWithLease(time.Minute)
+	defer releaseHeld()
+
+	lease, _ := gns.LockPathsWithLease(time.Millisecond*200,
+		LockTarget{Path: PathString("/orders/42").ToAbsolutePath()},
+		LockTarget{Path: PathString("/inventory/sku-9").ToAbsolutePath()},
+	)
+	assert.NotNil(t, lease.Err(), "paths were locked while one of them was held")
+
+	// the paths are locked in order, so the free one was locked before the held one was waited for
+	sku := gns.FetchAbsolutePath("/inventory/sku-9")
+	if assert.NotNil(t, sku) {
+		assert.Eventually(t, func() bool { return !sku.reslock.isLocked() }, time.Second, time.Millisecond*10, "path was left locked when the rest could not be")
+	}
+}
+
+func overlappingPathsRefused(t *testing.T) {
+	gns := createTestNamespace(t)
+
+	lease, _ := gns.LockPathsWithLease(time.Minute,
+		LockTarget{Path: PathString("/orders/42").ToAbsolutePath()},
+		LockTarget{Path: PathString("/orders").ToAbsolutePath(), Prefix: true},
+	)
+	assert.NotNil(t, lease.Err(), "overlapping paths were locked")
+	assert.Empty(t, lease.Elements())
+
+	empty, _ := gns.LockPathsWithLease(time.Minute)
+	assert.NotNil(t, empty.Err(), "lease was granted with no paths to lock")
+}
+
+func multiPathLockersDoNotDeadlock(t *testing.T) {
+	gns := createTestNamespace(t)
+	orders := LockTarget{Path: PathString("/orders/42").ToAbsolutePath()}
+	sku := LockTarget{Path: PathString("/inventory/sku-9").ToAbsolutePath()}
+	inventory := LockTarget{Path: PathString("/inventory").ToAbsolutePath(), Prefix: true}
+	sets := [][]LockTarget{
+		{orders, sku},
+		{sku, orders},
+		{inventory, orders},
+		{orders, inventory},
+	}
+
+	var wg sync.WaitGroup
+	failed := make(chan error, len(sets)*20)
+	for _, targets := range sets {
+		targets := targets
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				lease, release := gns.LockPathsWithLease(time.Second*5, targets...)
+				if err := lease.Err(); err != nil {
+					failed <- err
+					return
+				}
+				release()
+				<-lease.Done()
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 10):
+		t.Error("multi-path lockers deadlocked")
+		return
+	}
+	close(failed)
+	for err := range failed {
+		t.Errorf("multi-path lease failed: %s", err)
+	}
+}
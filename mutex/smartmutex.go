@@ -152,6 +152,9 @@ func (m *SmartMutex) Unlock() {
 	if m.locked == true {
 		m.mu.Unlock()
 		m.locked = false
+		if !Opts.Disable {
+			postUnlock(m) // Lock tracks the SmartMutex as well as its underlying rwmutex
+		}
 	}
 	m.statuslock.Unlock()
 }
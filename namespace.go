@@ -2,8 +2,11 @@ package whatnot
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"github.com/databeast/whatnot/mutex"
+	"github.com/databeast/whatnot/peerpb"
 )
 
 // Namespace provides unique namespaces for keyval trees
@@ -12,6 +15,23 @@ type Namespace struct {
 	name     string
 	globalmu *mutex.SmartMutex
 	events   chan elementChange
+	manager  *NameSpaceManager // set once registered to a NameSpaceManager
+	leases   *leaseRegistry    // leases granted on the namespace's elements, by ID
+	waits    *waitGraph        // leases waiting for the namespace's elements, to find deadlocks between them
+	hooks    *leaseHooks       // called for the leases on every element of the namespace
+
+	// the last fencing token issued with a lease on any of the namespace's elements
+	fencemu *sync.Mutex
+	fence   FencingToken
+
+	// recently deleted paths, so reconciliation with peers does not bring them back
+	tombmu     *sync.Mutex
+	tombstones map[PathString]int64
+
+	// created from a peer's snapshot, the application may still register its own instance to take it over
+	fromSnapshot bool
+
+	policy atomic.Value // ConflictPolicy for concurrently written values
 }
 
 // NewNamespace creates a new Namespace Instance. If this is intended to be persisted
@@ -21,21 +41,33 @@ func NewNamespace(name string) (ns *Namespace) {
 		name:     name,
 		globalmu: mutex.New(fmt.Sprintf("Global mutex for namespace %q", name)),
 		events:   make(chan elementChange),
+		leases:   newLeaseRegistry(),
+		hooks:    newLeaseHooks(),
+		fencemu:  &sync.Mutex{},
+
+		tombmu:     &sync.Mutex{},
+		tombstones: make(map[PathString]int64),
 	}
 
+	ns.waits = newWaitGraph(ns)
+
 	ns.root = &PathElement{
 		section:      rootId,
+		namespace:    ns,
 		mu:           mutex.New("Namespace Root Element mutex"),
 		children:     make(map[SubPath]*PathElement),
 		subevents:    make(chan elementChange, 100), // big buffer to absorb events
 		parentnotify: ns.events,
+		reslock:      newResourceLock(), // holds the intentions of every lock in the namespace
 	}
 
 	// drain out notification events once they reach the root element
+	// the root is taken once, as a namespace taking over a snapshot's tree replaces it, whose own drain carries on
 	// TODO: perhaps include a root-level event subscription?
+	root := ns.root
 	go func() {
 		for {
-			<-ns.root.subevents
+			<-root.subevents
 		}
 	}()
 
@@ -45,6 +77,16 @@ func NewNamespace(name string) (ns *Namespace) {
 // RegisterAbsolutePath constructs a complete path in the Namespace, with all required
 // structure instances to make the path immediately available and active
 func (ns *Namespace) RegisterAbsolutePath(path AbsolutePath) error {
+	err := ns.registerAbsolutePath(path)
+	if err != nil {
+		return err
+	}
+	ns.replicate(&peerpb.Mutation{Op: peerpb.Operation_OPERATION_REGISTER, Path: path.toStrings()})
+	return nil
+}
+
+// registerAbsolutePath constructs the path without replicating it to cluster peers
+func (ns *Namespace) registerAbsolutePath(path AbsolutePath) error {
 	var currentElement = ns.root
 	var err error
 	for _, p := range path {
@@ -68,6 +110,11 @@ func (ns *Namespace) FetchOrCreateAbsolutePath(path PathString) (elem *PathEleme
 	return
 }
 
+// Root is the element at the top of the Namespace, that every path begins beneath
+func (ns *Namespace) Root() *PathElement {
+	return ns.root
+}
+
 // FetchAbsolutePath will return the PathElement instance at the end of the provided Path
 // assuming it exists, otherwise it returns Nil
 func (ns *Namespace) FetchAbsolutePath(path PathString) *PathElement {
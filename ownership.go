@@ -0,0 +1,47 @@
+package whatnot
+
+/*
+Every lease records the access.Role it was granted to, so a lock that is stuck can be traced back to whoever holds it.
+Leases are granted to the role carried by the context they are taken with, from access.WithRole
+*/
+
+import (
+	"sort"
+	"time"
+
+	"github.com/databeast/whatnot/access"
+)
+
+// LockHolder describes a lease holding an element's lock
+type LockHolder struct {
+	Path   PathString   // the element the lease was granted on, above the element asked about for a prefix lease
+	Lease  LeaseID      // the lease holding it
+	Role   access.Role  // who the lease was granted to, empty if it was granted with no role
+	Mode   LeaseMode    // if the lease holds it alone, or shares it
+	Prefix bool         // the lease holds everything beneath Path as well
+	Since  time.Time    // when the lease took the lock
+	Token  FencingToken // the fencing token the lease was issued for the element
+}
+
+// LockHolders lists the leases holding this element, including prefix leases on the elements above it,
+// longest held first. Locks taken without a lease have no holder to report, so are not listed
+func (p *PathElement) LockHolders() (holders []LockHolder) {
+	for a := p; a != nil; a = a.parent {
+		a.reslock.selfmu.Lock()
+		for _, holder := range a.reslock.holders {
+			if a == p || holder.Prefix {
+				holders = append(holders, holder)
+			}
+		}
+		a.reslock.selfmu.Unlock()
+	}
+	sort.Slice(holders, func(i, j int) bool {
+		return holders[i].Since.Before(holders[j].Since)
+	})
+	return holders
+}
+
+// Role is who the lease was granted to, from the context it was granted with
+func (l *LeaseContext) Role() access.Role {
+	return l.role
+}
@@ -0,0 +1,95 @@
+package whatnot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/databeast/whatnot/access"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockOwnership(t *testing.T) {
+	t.Run("Leases record the role they were granted to", leasesRecordRole)
+	t.Run("Prefix lease holders are listed beneath the prefix", prefixHoldersListedBeneath)
+	t.Run("Lock events carry the role holding the lock", lockEventsCarryActor)
+}
+
+func leasesRecordRole(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/owned/element")
+	role := access.Role{Name: "billing-service"}
+
+	before := time.Now()
+	lease, release := elem.ContextLockWithLease(access.WithRole(context.Background(), role), time.Minute)
+	if !assert.Nil(t, lease.Err(), "lease was not granted") {
+		return
+	}
+	assert.Equal(t, role.Name, lease.Role().Name)
+
+	holders := elem.LockHolders()
+	if assert.Len(t, holders, 1, "lease was not listed as holding the element") {
+		assert.Equal(t, role.Name, holders[0].Role.Name)
+		assert.Equal(t, lease.ID(), holders[0].Lease)
+		assert.Equal(t, PathString("/owned/element"), holders[0].Path)
+		assert.Equal(t, lease.FencingToken(), holders[0].Token)
+		assert.False(t, holders[0].Since.Before(before), "lease was listed as held from before it was granted")
+	}
+
+	release()
+	assert.Eventually(t, func() bool { return len(elem.LockHolders()) == 0 }, time.Second, time.Millisecond*10, "released lease was still listed")
+}
+
+func prefixHoldersListedBeneath(t *testing.T) {
+	gns := createTestNamespace(t)
+	prefix, _ := gns.FetchOrCreateAbsolutePath("/owned/prefix")
+	child, _ := gns.FetchOrCreateAbsolutePath("/owned/prefix/child")
+
+	first, releaseFirst := prefix.ContextLockPrefixSharedWithLease(access.WithRole(context.Background(), access.Role{Name: "indexer"}), time.Minute)
+	defer releaseFirst()
+	second, releaseSecond := child.ContextLockSharedWithLease(access.WithRole(context.Background(), access.Role{Name: "reporter"}), time.Minute)
+	defer releaseSecond()
+	if !assert.Nil(t, first.Err(), "prefix lease was not granted") || !assert.Nil(t, second.Err(), "child lease was not granted") {
+		return
+	}
+
+	holders := child.LockHolders()
+	if assert.Len(t, holders, 2, "child did not list both leases holding it") {
+		assert.Equal(t, "indexer", holders[0].Role.Name, "longest held lease was not listed first")
+		assert.True(t, holders[0].Prefix)
+		assert.Equal(t, "reporter", holders[1].Role.Name)
+		assert.Equal(t, LeaseShared, holders[1].Mode)
+	}
+	assert.Len(t, prefix.LockHolders(), 1, "prefix listed a lease held only beneath it")
+}
+
+func lockEventsCarryActor(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/owned/watched")
+	sub := elem.SubscribeToEvents(false)
+	defer elem.UnSubscribeFromEvents(sub)
+
+	// subscribers not ready to receive an event are dropped, so the loop below must already be waiting
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		_, release := elem.ContextLockWithLease(access.WithRole(context.Background(), access.Role{Name: "scheduler"}), time.Minute)
+		time.Sleep(time.Millisecond * 50)
+		release()
+	}()
+
+	var seen []WatchEvent
+	timeout := time.After(time.Second * 2)
+	for len(seen) < 2 {
+		select {
+		case e := <-sub.Events():
+			seen = append(seen, e)
+		case <-timeout:
+			t.Errorf("lock events were not sent to watchers, saw %v", seen)
+			return
+		}
+	}
+	assert.Equal(t, ChangeLocked, seen[0].Change)
+	assert.Equal(t, "scheduler", seen[0].Actor.Name, "lock event did not carry the lease's role")
+	assert.Equal(t, ChangeUnlocked, seen[1].Change)
+	assert.Equal(t, "scheduler", seen[1].Actor.Name, "unlock event did not carry the lease's role")
+}
@@ -0,0 +1,223 @@
+package whatnot
+
+/*
+Partition-aware leases stop an instance trusting the leases it holds once it can no longer reach a majority of
+its cluster. During a split-brain the other side of the partition may well consider the same resources free,
+so holders are told - either by their LeaseContext being cancelled, or by it being marked suspect.
+*/
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultQuorumCheckInterval = time.Second
+
+// QuorumLossAction is what happens to held leases when an instance loses contact with a quorum of its cluster
+type QuorumLossAction int
+
+const (
+	// CancelLeases cancels every held lease, their Err reports ErrQuorumLost
+	CancelLeases QuorumLossAction = iota
+	// SuspectLeases leaves leases held, but marks them as Suspect
+	SuspectLeases
+)
+
+// ErrQuorumLost is reported by the Err of a lease cancelled because its instance lost contact with a quorum
+// it wraps context.Canceled, so code only checking for cancellation still recognizes it
+var ErrQuorumLost error = quorumLostError{}
+
+type quorumLostError struct{}
+
+func (quorumLostError) Error() string {
+	return "lease cancelled: lost contact with a quorum of the cluster"
+}
+
+func (quorumLostError) Unwrap() error {
+	return context.Canceled
+}
+
+// WithPartitionDetection watches for this instance losing contact with a majority of its cluster
+// and stops trusting the leases it holds while that lasts. Quorum is judged by Raft if it is enabled,
+// otherwise by the liveness of PeerSync peers
+type WithPartitionDetection struct {
+	// Action is what happens to held leases when quorum is lost, defaulting to CancelLeases
+	Action QuorumLossAction
+	// Interval is how often quorum is checked, defaulting to one second
+	Interval time.Duration
+}
+
+func (w WithPartitionDetection) name() optionName {
+	return optionPartition
+}
+
+func (w WithPartitionDetection) apply(manager *NameSpaceManager) (err error) {
+	if manager.partition != nil {
+		return newConfigError("partition detection is already configured")
+	}
+	if w.Interval <= 0 {
+		w.Interval = defaultQuorumCheckInterval
+	}
+	manager.partition = &partitionDetector{
+		manager: manager,
+		action:  w.Action,
+		every:   w.Interval,
+		mu:      &sync.Mutex{},
+		quorate: true,
+	}
+	return nil
+}
+
+// startPartitionDetection begins checking quorum, once all options have been applied
+func (m *NameSpaceManager) startPartitionDetection() error {
+	if m.partition == nil {
+		return nil
+	}
+	m.partition.ctx, m.partition.cancel = context.WithCancel(context.Background())
+	go m.partition.run()
+	return nil
+}
+
+// HasQuorum reports if this instance is in contact with a majority of its cluster
+// instances that are not clustered always have quorum
+func (m *NameSpaceManager) HasQuorum() bool {
+	ok, _ := m.quorumStatus()
+	return ok
+}
+
+// quorumStatus reports if we have quorum, and if not, why not
+func (m *NameSpaceManager) quorumStatus() (ok bool, reason string) {
+	if m.raft != nil {
+		return m.raft.quorumStatus()
+	}
+	if m.peering == nil {
+		return true, ""
+	}
+	statuses := m.PeerStatus()
+	reached := 1 // ourselves
+	for _, s := range statuses {
+		if s.Alive {
+			reached++
+		}
+	}
+	members := len(statuses) + 1
+	if reached*2 > members {
+		return true, ""
+	}
+	return false, fmt.Sprintf("only %d of %d cluster members are reachable", reached, members)
+}
+
+// quorumStatus reports if this member is in contact with a majority of the raft cluster
+func (r *raftNode) quorumStatus() (ok bool, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch r.state {
+	case raftLeader:
+		reached := 1
+		for id, at := range r.acks {
+			if id != r.id && time.Since(at) < r.timeout {
+				reached++
+			}
+		}
+		if reached >= r.quorum() {
+			return true, ""
+		}
+		return false, fmt.Sprintf("raft leader reached only %d of %d members", reached, len(r.members))
+	default:
+		if r.leader != "" && time.Since(r.lastContact) < r.timeout {
+			return true, ""
+		}
+		return false, fmt.Sprintf("no contact with a raft leader for %s", time.Since(r.lastContact).Round(time.Millisecond))
+	}
+}
+
+// partitionDetector periodically checks quorum, acting on held leases when it is lost
+type partitionDetector struct {
+	logsupport
+	manager *NameSpaceManager
+	action  QuorumLossAction
+	every   time.Duration
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	mu      *sync.Mutex
+	quorate bool
+	reason  string // why quorum was lost
+}
+
+func (d *partitionDetector) run() {
+	ticker := time.NewTicker(d.every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ok, reason := d.manager.quorumStatus()
+		d.mu.Lock()
+		lost := d.quorate && !ok
+		regained := !d.quorate && ok
+		d.quorate, d.reason = ok, reason
+		d.mu.Unlock()
+
+		if lost {
+			d.Warnf("quorum lost, %s", reason)
+			for _, lease := range d.manager.grantedLeases() {
+				d.distrust(lease, reason)
+			}
+		} else if regained {
+			d.Infof("quorum regained")
+		}
+	}
+}
+
+// check acts on a newly granted lease straight away, if quorum is already lost
+func (d *partitionDetector) check(lease *LeaseContext) {
+	d.mu.Lock()
+	quorate, reason := d.quorate, d.reason
+	d.mu.Unlock()
+	if !quorate {
+		d.distrust(lease, reason)
+	}
+}
+
+// distrust cancels or marks the lease as suspect, and tells watchers of its elements why
+func (d *partitionDetector) distrust(lease *LeaseContext, reason string) {
+	var note string
+	switch d.action {
+	case SuspectLeases:
+		lease.markSuspect()
+		note = fmt.Sprintf("lease is suspect: %s", reason)
+	default:
+		note = fmt.Sprintf("lease cancelled: %s", reason)
+	}
+	for _, a := range lease.held() {
+		a.elem.selfnotify <- elementChange{id: randid.Uint64(), elem: a.elem, change: ChangeQuorumLost, note: note}
+	}
+	if d.action == CancelLeases {
+		lease.cancelWith(ErrQuorumLost)
+	}
+}
+
+func (d *partitionDetector) close() {
+	if d.cancel != nil {
+		d.cancel()
+	}
+}
+
+// grantedLeases lists the leases granted in every namespace of the manager
+func (m *NameSpaceManager) grantedLeases() (leases []*LeaseContext) {
+	m.mu.Lock()
+	namespaces := make([]*Namespace, 0, len(m.namespaces))
+	for _, ns := range m.namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	m.mu.Unlock()
+	for _, ns := range namespaces {
+		leases = append(leases, ns.leases.list()...)
+	}
+	return leases
+}
@@ -0,0 +1,109 @@
+package whatnot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const testQuorumInterval = time.Millisecond * 20
+
+func TestPartitionDetection(t *testing.T) {
+	t.Run("Leases are cancelled when quorum is lost", leasesCancelledOnQuorumLoss)
+	t.Run("Leases are marked suspect when quorum is lost", leasesSuspectOnQuorumLoss)
+	t.Run("Leases granted without quorum are cancelled", leasesWithoutQuorumCancelled)
+	t.Run("Partition detection requires clustering", partitionDetectionRequiresCluster)
+}
+
+// awaitQuorumEvent waits for a quorum loss event on the subscription, returning its note
+func awaitQuorumEvent(t *testing.T, sub *ElementWatchSubscription) (note string) {
+	timeout := time.After(testElectionTimeout * 20)
+	for {
+		select {
+		case e := <-sub.Events():
+			if e.Change == ChangeQuorumLost {
+				return e.Note
+			}
+		case <-timeout:
+			t.Error("no quorum loss event was received")
+			return ""
+		}
+	}
+}
+
+// partitionedLease takes a lease on the first member of a raft cluster, then cuts that member off
+func partitionedLease(t *testing.T, action QuorumLossAction) (lease *LeaseContext, sub *ElementWatchSubscription) {
+	transport, _, namespaces := createRaftTestCluster(t, 3, WithPartitionDetection{Action: action, Interval: testQuorumInterval})
+	elem, _ := namespaces[0].FetchOrCreateAbsolutePath("/partition/leased")
+	sub = elem.SubscribeToEvents(false)
+
+	lease, release := elem.LockWithLease(time.Minute)
+	t.Cleanup(release)
+	if !assert.Nil(t, lease.Err(), "lease was not granted") {
+		t.FailNow()
+	}
+	transport.Disconnect("node0")
+	return lease, sub
+}
+
+func leasesCancelledOnQuorumLoss(t *testing.T) {
+	lease, sub := partitionedLease(t, CancelLeases)
+
+	note := awaitQuorumEvent(t, sub)
+	assert.NotEmpty(t, note, "quorum loss event did not explain why")
+	select {
+	case <-lease.Done():
+	case <-time.After(testElectionTimeout * 20):
+		t.Error("lease was not cancelled")
+		return
+	}
+	assert.True(t, errors.Is(lease.Err(), ErrQuorumLost), "lease did not report quorum loss")
+	assert.True(t, errors.Is(lease.Err(), context.Canceled), "quorum loss is not a cancellation")
+}
+
+func leasesSuspectOnQuorumLoss(t *testing.T) {
+	lease, sub := partitionedLease(t, SuspectLeases)
+
+	note := awaitQuorumEvent(t, sub)
+	assert.NotEmpty(t, note, "quorum loss event did not explain why")
+	assert.True(t, lease.Suspect(), "lease was not marked suspect")
+	assert.Nil(t, lease.Err(), "suspect lease was cancelled")
+}
+
+func leasesWithoutQuorumCancelled(t *testing.T) {
+	cluster := bufconnCluster{"node0": bufconn.Listen(1024 * 1024)}
+	opt := cluster.peerSyncOption("node0")
+	opt.HeartbeatInterval = testHeartbeatInterval
+	nsm, err := NewNamespaceManager(opt, WithPartitionDetection{Interval: testQuorumInterval})
+	if !assert.Nil(t, err, "creating manager failed") {
+		return
+	}
+	defer nsm.Close()
+	// peers that never answer
+	_ = nsm.AddPeer("unreachable1")
+	_ = nsm.AddPeer("unreachable2")
+	assert.Eventually(t, func() bool { return !nsm.HasQuorum() }, peerSyncTimeout, testQuorumInterval, "quorum was not lost")
+
+	ns := NewNamespace(testNameSpace)
+	_ = nsm.RegisterNamespace(ns)
+	elem, _ := ns.FetchOrCreateAbsolutePath("/partition/isolated")
+	time.Sleep(testQuorumInterval * 3) // let the detector notice
+
+	lease, release := elem.LockWithLease(time.Minute)
+	defer release()
+	select {
+	case <-lease.Done():
+		assert.True(t, errors.Is(lease.Err(), ErrQuorumLost), "lease did not report quorum loss")
+	case <-time.After(time.Second):
+		t.Error("lease granted without quorum was not cancelled")
+	}
+}
+
+func partitionDetectionRequiresCluster(t *testing.T) {
+	_, err := NewNamespaceManager(WithPartitionDetection{})
+	assert.NotNil(t, err, "partition detection was allowed without clustering")
+}
@@ -53,6 +53,24 @@ func (m AbsolutePath) SubtractPath(path AbsolutePath) PathString {
 	return ""
 }
 
+// toStrings converts an absolute path into its plain string sections
+func (m AbsolutePath) toStrings() []string {
+	strs := make([]string, len(m))
+	for i, p := range m {
+		strs[i] = string(p)
+	}
+	return strs
+}
+
+// absolutePathFromStrings is the reverse of AbsolutePath.toStrings
+func absolutePathFromStrings(sections []string) AbsolutePath {
+	path := make(AbsolutePath, len(sections))
+	for i, s := range sections {
+		path[i] = SubPath(s)
+	}
+	return path
+}
+
 func splitPath(path PathString) (sections []SubPath) {
 	s := strings.Split(string(path), pathDelimeter)
 
@@ -4,10 +4,11 @@ import (
 	"context"
 	"fmt"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/databeast/whatnot/mutex"
+	"github.com/databeast/whatnot/peerpb"
 	"github.com/pkg/errors"
 )
 
@@ -24,6 +25,9 @@ type PathElement struct {
 	// The parent Path Element
 	parent *PathElement
 
+	// the Namespace this Path Element belongs to
+	namespace *Namespace
+
 	// sub Path-elements directly beneath this PathElement
 	children map[SubPath]*PathElement
 
@@ -45,6 +49,14 @@ type PathElement struct {
 	// additional keyval data attached to this pathelement
 	resval ElementValue
 
+	// unix nanosecond time this element was created, and the version of its value
+	created int64
+	version Version
+
+	// digest of this element and everything beneath it for anti-entropy, kept until any of them changes
+	digested  *subtreeDigest
+	digestgen uint64
+
 	// Channel Multiplexer for sending watch events to subscriptions
 	// on this Path Element or any of its children
 	subscriberNotify *EventMultiplexer
@@ -56,6 +68,9 @@ type PathElement struct {
 
 	// semaphore pool support
 	semaphores *SemaphorePool
+
+	// *leaseHooks called for the leases on this element, stored once the first is registered
+	leasehooks atomic.Value
 }
 
 // SubPath returns the name of this Path Element
@@ -108,8 +123,10 @@ func (p *PathElement) AbsolutePath() (path AbsolutePath) {
 
 // fetchSubElement fetches named sub element, if it exists
 // returns nil if no sub element by that name exists
-func (p PathElement) fetchSubElement(path SubPath) *PathElement {
+func (p *PathElement) fetchSubElement(path SubPath) *PathElement {
+	p.mu.Lock()
 	sub, ok := p.children[path]
+	p.mu.Unlock()
 	if ok {
 		return sub
 	} else {
@@ -145,7 +162,7 @@ func (p *PathElement) logChange(e elementChange) {
 }
 
 // FetchClosestSubPathTail finds the last element in a path chain that most closely resembles the requested path
-func (p PathElement) FetchClosestSubPathTail(subPath PathString) *PathElement {
+func (p *PathElement) FetchClosestSubPathTail(subPath PathString) *PathElement {
 	elemChain := p.FetchClosestSubPath(subPath)
 	if len(elemChain) > 0 {
 		return elemChain[len(elemChain)-1]
@@ -179,6 +196,11 @@ func (p *PathElement) FetchClosestSubPath(subPath PathString) (pathchain []*Path
 	return pathchain
 }
 
+// Children returns the elements directly beneath this one, ordered by their SubPath
+func (p *PathElement) Children() []*PathElement {
+	return p.sortedChildren()
+}
+
 // Add a Single subpath Element to this Element
 func (p *PathElement) Add(path SubPath) (elem *PathElement, err error) {
 
@@ -199,22 +221,21 @@ func (p *PathElement) Add(path SubPath) (elem *PathElement, err error) {
 	elem = &PathElement{
 		section:      path,
 		parent:       p,
+		namespace:    p.namespace,
 		parentnotify: p.subevents,
 		mu:           mutex.New(fmt.Sprintf("internal mutex for %s", path)),
 		children:     make(map[SubPath]*PathElement),
 		subevents:    make(chan elementChange, 2),
 		selfnotify:   make(chan elementChange, 2),
+		created:      time.Now().UnixNano(),
 	}
 	p.children[path] = elem
-	elem.reslock = resourceLock{
-		selfmu:    &sync.Mutex{},
-		resmu:     &sync.Mutex{},
-		recursive: false,
-	}
+	elem.reslock = newResourceLock()
 	// begin the broadcaster for watch subscriptions to function
 	elem.initEventBroadcast()
 
 	p.mu.Unlock()
+	p.changedDigest()
 	return elem, nil
 }
 
@@ -227,15 +248,51 @@ func (p *PathElement) attach(elem *PathElement) (err error) {
 	}
 	// propagate our pruning information down to this element as well
 	elem.prunetracker = p.prunetracker
+	elem.namespace = p.namespace
 
 	p.mu.Lock()
 	p.children[elem.SubPath()] = elem
 	p.mu.Unlock()
+	p.changedDigest()
 
 	return nil
 }
 
+// Delete removes this Path Element, and all of its children, from the Namespace
 func (p *PathElement) Delete() (err error) {
+	err = p.delete()
+	if err != nil {
+		return err
+	}
+	p.replicate(&peerpb.Mutation{Op: peerpb.Operation_OPERATION_DELETE})
+	return nil
+}
+
+// delete removes the element without replicating the deletion to cluster peers
+func (p *PathElement) delete() (err error) {
+	path := p.AbsolutePath().ToPathString()
+	err = p.deleteTree()
+	if err != nil {
+		return err
+	}
+	if p.namespace != nil {
+		p.namespace.bury(path, time.Now().UnixNano())
+	}
+
+	// detach from our parent, so the path can no longer be fetched
+	if p.parent != nil {
+		p.parent.mu.Lock()
+		if p.parent.children[p.section] == p {
+			delete(p.parent.children, p.section)
+		}
+		p.parent.mu.Unlock()
+		p.parent.changedDigest()
+	}
+	return nil
+}
+
+// deleteTree shuts down this element and all of its children
+func (p *PathElement) deleteTree() (err error) {
 	if p.mu.IsLocked() {
 		panic("recursive")
 	}
@@ -244,7 +301,9 @@ func (p *PathElement) Delete() (err error) {
 	defer p.mu.Unlock()
 
 	// cascade the context-cancel signal that our event-watching goroutine needs to exit, along with that of all child elements
-	p.prunefunc()
+	if p.prunefunc != nil {
+		p.prunefunc()
+	}
 	deleteEvent := elementChange{id: randid.Uint64(), elem: p, change: ChangeDeleted}
 	p.parentnotify <- deleteEvent
 	p.selfnotify <- deleteEvent
@@ -252,7 +311,7 @@ func (p *PathElement) Delete() (err error) {
 	// recursively delete all children
 	for _, elem := range p.children {
 		if elem != nil {
-			err = elem.Delete()
+			err = elem.deleteTree()
 			if err != nil {
 				return err // TODO: what happens in this half-deleted state?
 			}
@@ -1,6 +1,11 @@
 package whatnot
 
-import "github.com/databeast/whatnot/access"
+import (
+	"encoding/json"
+
+	"github.com/databeast/whatnot/access"
+	"github.com/databeast/whatnot/peerpb"
+)
 
 type ElementValue struct {
 	Val interface{}
@@ -10,16 +15,55 @@ func (p *PathElement) SetValue(value ElementValue, change changeType, actor acce
 	if p == nil {
 		panic("SetValue called on nil PathElement")
 	}
+	version := p.clock().now()
+	previous := p.setValue(value, change, actor, version)
+
+	// values are replicated to cluster peers as JSON
+	encoded, err := json.Marshal(value.Val)
+	if err != nil {
+		p.Warnf("cannot replicate value of %s: %s", p.AbsolutePath().ToPathString(), err.Error())
+		return
+	}
+	p.replicate(&peerpb.Mutation{
+		Op:       peerpb.Operation_OPERATION_SET_VALUE,
+		Value:    encoded,
+		Change:   int32(change),
+		Version:  version.toProto(),
+		Previous: previous.toProto(),
+	})
+}
+
+// setValue stores the value without replicating it to cluster peers, returning the version it replaced
+func (p *PathElement) setValue(value ElementValue, change changeType, actor access.Role, version Version) (previous Version) {
+	previous = p.storeValue(value, version)
+	p.parentnotify <- elementChange{elem: p, change: change, actor: actor, version: version}
+	return previous
+}
+
+// storeValue replaces the value and its version, returning the version it replaced
+func (p *PathElement) storeValue(value ElementValue, version Version) (previous Version) {
 	p.mu.Lock()
+	previous = p.version
 	p.resval = value
+	p.version = version
 	p.mu.Unlock()
-
-	p.parentnotify <- elementChange{elem: p, change: change, actor: actor}
+	p.changedDigest()
+	return previous
 }
 
 func (p *PathElement) GetValue() (value ElementValue) {
 	if p == nil {
 		panic("GetValue called on nil PathElement")
 	}
+	// values are written by cluster peers as well as locally
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.resval
 }
+
+// GetVersionedValue returns the value along with the Version it was written at
+func (p *PathElement) GetVersionedValue() VersionedValue {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return VersionedValue{Value: p.resval, Version: p.version}
+}
@@ -0,0 +1,592 @@
+package whatnot
+
+/*
+Peer Synchronization replicates changes to registered Namespaces between NameSpaceManager instances
+every instance serves the PeerSync gRPC service, and streams its own local changes out to each of its peers
+changes received from a peer are applied locally, but never forwarded on, so peers are expected to form a full mesh
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/databeast/whatnot/access"
+	"github.com/databeast/whatnot/peerpb"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	// how many mutations can be waiting to be sent to a single peer before they are dropped
+	defaultPeerQueueLength = 1024
+	// how long a local change waits for room in a full replication queue before it is dropped
+	defaultPeerQueueTimeout = time.Millisecond * 250
+	// how long a lock replicated from a peer is waited for, before giving up on it
+	defaultRemoteLockTimeout = time.Second * 30
+)
+
+// WithPeerSync enables replication of Namespace changes to other NameSpaceManager instances
+// using the gRPC PeerSync service. Peers are added with NameSpaceManager.AddPeer
+type WithPeerSync struct {
+	// NodeName uniquely identifies this instance amongst its peers
+	NodeName string
+	// Listener is where this instance will serve the PeerSync service to its peers
+	Listener net.Listener
+	// DialOptions are used when connecting to peers, without any the connection will be insecure
+	DialOptions []grpc.DialOption
+	// ServerOptions are used when creating the PeerSync gRPC server
+	ServerOptions []grpc.ServerOption
+	// HeartbeatInterval is how often peer liveness is checked, defaults to one second
+	HeartbeatInterval time.Duration
+	// AntiEntropyInterval is how often Namespaces are reconciled with each peer, defaults to thirty seconds
+	AntiEntropyInterval time.Duration
+}
+
+func (w WithPeerSync) name() optionName {
+	return optionPeerSync
+}
+
+func (w WithPeerSync) apply(manager *NameSpaceManager) (err error) {
+	if w.NodeName == "" {
+		return newConfigError("no node name passed in WithPeerSync config option")
+	}
+	if w.Listener == nil {
+		return newConfigError("no listener passed in WithPeerSync config option")
+	}
+	if manager.peering != nil {
+		return newConfigError("peer synchronization is already configured")
+	}
+	manager.peering = newPeerSync(manager, w) // served once every option has been applied
+	return nil
+}
+
+// peerSync is the PeerSync service of a single NameSpaceManager
+// and the set of outbound replication streams to its peers
+type peerSync struct {
+	logsupport
+	peerpb.UnimplementedPeerSyncServer
+
+	manager  *NameSpaceManager
+	node     string
+	listener net.Listener
+	server   *grpc.Server
+	dialopts []grpc.DialOption
+	interval time.Duration // peer heartbeat interval
+
+	reconcileEvery time.Duration // anti-entropy interval
+
+	sequence uint64 // atomically incremented ordering for outbound mutations
+
+	mu    *sync.Mutex
+	peers map[string]*peerConn
+
+	// remote locks are acquired in the background, in the order they were received
+	remoteLocks *lockChain
+
+	snapshots *snapshotState
+}
+
+func newPeerSync(manager *NameSpaceManager, opts WithPeerSync) *peerSync {
+	dialopts := opts.DialOptions
+	if len(dialopts) == 0 {
+		dialopts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	interval := opts.HeartbeatInterval
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+	reconcileEvery := opts.AntiEntropyInterval
+	if reconcileEvery <= 0 {
+		reconcileEvery = defaultAntiEntropyInterval
+	}
+	s := &peerSync{
+		manager:        manager,
+		node:           opts.NodeName,
+		listener:       opts.Listener,
+		server:         grpc.NewServer(opts.ServerOptions...),
+		dialopts:       dialopts,
+		interval:       interval,
+		reconcileEvery: reconcileEvery,
+		mu:             &sync.Mutex{},
+		peers:          make(map[string]*peerConn),
+		remoteLocks:    newLockChain(),
+		snapshots:      newSnapshotState(),
+	}
+	peerpb.RegisterPeerSyncServer(s.server, s)
+	return s
+}
+
+func (s *peerSync) serve() {
+	go func() {
+		err := s.server.Serve(s.listener)
+		if err != nil {
+			s.Errorf("peer sync server on %s stopped: %s", s.listener.Addr().String(), err.Error())
+		}
+	}()
+}
+
+// publish queues a local change to be sent to every peer
+func (s *peerSync) publish(m *peerpb.Mutation) {
+	m.Origin = s.node
+	m.Sequence = atomic.AddUint64(&s.sequence, 1)
+	s.mu.Lock()
+	for _, p := range s.peers {
+		p.enqueue(m)
+	}
+	s.mu.Unlock()
+}
+
+func (s *peerSync) close() {
+	s.mu.Lock()
+	for target, p := range s.peers {
+		p.close()
+		delete(s.peers, target)
+	}
+	s.mu.Unlock()
+	s.server.Stop()
+	_ = s.listener.Close() // in case the server was never started
+}
+
+// Replicate implements the PeerSync gRPC service, applying every mutation a peer sends to us
+func (s *peerSync) Replicate(stream peerpb.PeerSync_ReplicateServer) error {
+	var received uint64
+	var snapshotFrom string // the peer whose snapshot we are part way through applying
+	defer func() {
+		if snapshotFrom != "" {
+			s.snapshots.abandon(snapshotFrom)
+		}
+	}()
+	for {
+		m, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&peerpb.ReplicateSummary{Received: received})
+		}
+		if err != nil {
+			return err
+		}
+		received++
+		if m.Snapshot || m.Op == peerpb.Operation_OPERATION_SNAPSHOT_END {
+			accepted, err := s.receiveSnapshot(m)
+			if accepted && m.Op != peerpb.Operation_OPERATION_SNAPSHOT_END {
+				snapshotFrom = m.Origin
+			} else {
+				snapshotFrom = ""
+			}
+			if err != nil {
+				s.Warnf("could not apply snapshot from %s: %s", m.Origin, err.Error())
+			}
+			continue
+		}
+		if err = s.apply(m); err != nil {
+			s.Warnf("could not apply mutation %d from %s: %s", m.Sequence, m.Origin, err.Error())
+		}
+	}
+}
+
+// apply performs a peer's mutation on our own copy of the Namespace, without replicating it any further
+func (s *peerSync) apply(m *peerpb.Mutation) error {
+	if m.Origin == s.node {
+		return nil // our own change, reflected back at us
+	}
+	ns, err := s.manager.FetchNamespace(m.Namespace)
+	if err != nil {
+		return err
+	}
+	path := absolutePathFromStrings(m.Path)
+
+	switch m.Op {
+	case peerpb.Operation_OPERATION_REGISTER:
+		return ns.registerAbsolutePath(path)
+	case peerpb.Operation_OPERATION_SET_VALUE:
+		elem, err := ns.fetchOrRegisterAbsolutePath(path)
+		if err != nil {
+			return err
+		}
+		var val interface{}
+		if err = json.Unmarshal(m.Value, &val); err != nil {
+			return errors.Wrap(err, "undecodable element value")
+		}
+		remote := VersionedValue{Value: ElementValue{Val: val}, Version: versionFromProto(m.Version)}
+		if remote.Version.IsZero() {
+			remote.Version = elem.clock().now() // from an instance that does not version its values
+		}
+		elem.applyRemoteValue(remote, versionFromProto(m.Previous), changeType(m.Change))
+	case peerpb.Operation_OPERATION_DELETE:
+		elem := ns.FetchAbsolutePath(path.ToPathString())
+		if elem == nil {
+			return nil // already gone
+		}
+		return elem.delete()
+	case peerpb.Operation_OPERATION_LOCK:
+		elem, err := ns.fetchOrRegisterAbsolutePath(path)
+		if err != nil {
+			return err
+		}
+		if s.snapshots.consumeLock(lockChainKey(m)) {
+			return nil // the lock was already included in the snapshot from this peer
+		}
+		s.remoteLocks.then(lockChainKey(m), func() {
+			s.lockRemote(elem, m)
+		})
+	case peerpb.Operation_OPERATION_UNLOCK:
+		elem := ns.FetchAbsolutePath(path.ToPathString())
+		if elem == nil {
+			return nil
+		}
+		s.snapshots.consumeLock(lockChainKey(m))
+		s.remoteLocks.then(lockChainKey(m), func() {
+			// only the holds taken for the same peer are given up, never anyone's here
+			actor, owner := access.Role{Name: m.Actor}, lockOwner{peer: m.Origin}
+			switch {
+			case m.Shared && m.Recursive:
+				elem.runlockSubs(actor, owner)
+			case m.Shared:
+				elem.runlock(actor, owner)
+			case m.Recursive:
+				elem.unlockSubs(actor, owner)
+			default:
+				elem.unlock(actor, owner)
+			}
+		})
+	default:
+		return errors.Errorf("unknown mutation operation %d", m.Op)
+	}
+	return nil
+}
+
+// lockRemote takes the lock a peer was granted, giving up should it still be held here after defaultRemoteLockTimeout
+// so a lock the two instances disagree on cannot hold up every later change to the element forever
+func (s *peerSync) lockRemote(elem *PathElement, m *peerpb.Mutation) {
+	ctx, cancel := context.WithTimeout(withPeerOrigin(access.WithRole(context.Background(), access.Role{Name: m.Actor}), m.Origin), defaultRemoteLockTimeout)
+	defer cancel()
+	var err error
+	switch {
+	case m.Shared && m.Recursive:
+		err = elem.rlockSubsContext(ctx)
+	case m.Shared:
+		err = elem.rlockContext(ctx)
+	case m.Recursive:
+		err = elem.lockSubsContext(ctx)
+	default:
+		err = elem.lockContext(ctx)
+	}
+	if err != nil {
+		s.Warnf("gave up on lock of %s replicated from %s: %s", elem.AbsolutePath().ToPathString(), m.Origin, err.Error())
+	}
+}
+
+// peerOriginKey carries the peer a replicated lock is taken for, so that only the same peer's unlock gives it up
+type peerOriginKey struct{}
+
+func withPeerOrigin(ctx context.Context, origin string) context.Context {
+	return context.WithValue(ctx, peerOriginKey{}, origin)
+}
+
+// fetchOrRegisterAbsolutePath is FetchOrCreateAbsolutePath without replication to cluster peers
+func (ns *Namespace) fetchOrRegisterAbsolutePath(path AbsolutePath) (elem *PathElement, err error) {
+	elem = ns.FetchAbsolutePath(path.ToPathString())
+	if elem == nil {
+		if err = ns.registerAbsolutePath(path); err != nil {
+			return nil, err
+		}
+		elem = ns.FetchAbsolutePath(path.ToPathString())
+	}
+	return elem, nil
+}
+
+// replicate passes a change made on this Namespace on to every peer of its manager
+func (ns *Namespace) replicate(m *peerpb.Mutation) {
+	if ns.manager == nil || ns.manager.peering == nil {
+		return
+	}
+	m.Namespace = ns.name
+	ns.manager.peering.publish(m)
+}
+
+// replicate passes a change made on this Path Element on to every peer of its Namespace manager
+func (p *PathElement) replicate(m *peerpb.Mutation) {
+	if p.namespace == nil {
+		return
+	}
+	m.Path = p.AbsolutePath().toStrings()
+	p.namespace.replicate(m)
+}
+
+// AddPeer begins replicating all local Namespace changes to the given peer address
+func (m *NameSpaceManager) AddPeer(target string) error {
+	if m.peering == nil {
+		return errors.Errorf("peer synchronization is not enabled on this manager")
+	}
+	return m.peering.addPeer(target)
+}
+
+func (s *peerSync) addPeer(target string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.peers[target]; ok {
+		return errors.Errorf("already replicating to peer %q", target)
+	}
+	conn, err := grpc.Dial(target, s.dialopts...)
+	if err != nil {
+		return errors.Wrapf(err, "could not connect to peer %q", target)
+	}
+	p := newPeerConn(s, target, conn)
+	s.peers[target] = p
+	go p.run()
+	go p.heartbeat()
+	go p.antiEntropy()
+	return nil
+}
+
+// RemovePeer stops replicating changes to the given peer address
+func (m *NameSpaceManager) RemovePeer(target string) error {
+	if m.peering == nil {
+		return errors.Errorf("peer synchronization is not enabled on this manager")
+	}
+	return m.peering.removePeer(target)
+}
+
+func (s *peerSync) removePeer(target string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.peers[target]
+	if !ok {
+		return errors.Errorf("no such peer %q", target)
+	}
+	p.close()
+	delete(s.peers, target)
+	return nil
+}
+
+// Peers lists the addresses of every peer this manager is replicating changes to
+func (m *NameSpaceManager) Peers() (targets []string) {
+	if m.peering == nil {
+		return nil
+	}
+	m.peering.mu.Lock()
+	for t := range m.peering.peers {
+		targets = append(targets, t)
+	}
+	m.peering.mu.Unlock()
+	return targets
+}
+
+// peerConn is the outbound replication stream to a single peer
+type peerConn struct {
+	logsupport
+	sync     *peerSync
+	target   string
+	conn     *grpc.ClientConn
+	client   peerpb.PeerSyncClient
+	outbound chan *peerpb.Mutation
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	// liveness tracking, updated by heartbeat
+	statusmu *sync.Mutex
+	status   PeerStatus
+
+	// unlocks that did not fit in a full outbound queue, sent in order once it has room
+	spillmu *sync.Mutex
+	spilled []*peerpb.Mutation
+	behind  bool // changes have been dropped since the queue last emptied
+	dropped int
+}
+
+func newPeerConn(s *peerSync, target string, conn *grpc.ClientConn) *peerConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &peerConn{
+		sync:     s,
+		target:   target,
+		conn:     conn,
+		client:   peerpb.NewPeerSyncClient(conn),
+		outbound: make(chan *peerpb.Mutation, defaultPeerQueueLength),
+		ctx:      ctx,
+		cancel:   cancel,
+		statusmu: &sync.Mutex{},
+		status:   PeerStatus{Address: target},
+		spillmu:  &sync.Mutex{},
+	}
+}
+
+// enqueue waits a little for room should the peer fall behind, then drops the change for anti-entropy
+// to repair on the peer. Unlocks are never dropped, as nothing else would release the lock on the peer,
+// but held back until the queue has room
+func (p *peerConn) enqueue(m *peerpb.Mutation) {
+	p.spillmu.Lock()
+	defer p.spillmu.Unlock()
+	p.refillLocked()
+	if len(p.spilled) == 0 && !p.behind {
+		select {
+		case p.outbound <- m:
+			return
+		default:
+		}
+		timeout := time.NewTimer(defaultPeerQueueTimeout)
+		defer timeout.Stop()
+		select {
+		case p.outbound <- m:
+			return
+		case <-p.ctx.Done():
+			return
+		case <-timeout.C:
+		}
+		p.behind = true
+		p.Warnf("replication queue to %s is full, dropping changes until it catches up", p.target)
+	}
+	if m.Op == peerpb.Operation_OPERATION_UNLOCK {
+		p.spilled = append(p.spilled, m)
+		return
+	}
+	p.dropped++
+}
+
+// refill moves held back unlocks into the outbound queue as it empties
+func (p *peerConn) refill() {
+	p.spillmu.Lock()
+	p.refillLocked()
+	p.spillmu.Unlock()
+}
+
+// refillLocked is refill for callers already holding spillmu
+func (p *peerConn) refillLocked() {
+	for len(p.spilled) > 0 {
+		select {
+		case p.outbound <- p.spilled[0]:
+			p.spilled = p.spilled[1:]
+		default:
+			return
+		}
+	}
+	if p.behind && len(p.outbound) == 0 {
+		p.behind = false
+		p.Warnf("replication queue to %s caught up, %d changes were dropped for anti-entropy to repair", p.target, p.dropped)
+		p.dropped = 0
+	}
+}
+
+// run keeps a replication stream open to the peer, re-opening it with backoff whenever it fails
+func (p *peerConn) run() {
+	var pending *peerpb.Mutation // a mutation that failed to send, and needs to go first on the next stream
+	retry := newBackoff()
+	for {
+		stream, err := p.client.Replicate(p.ctx)
+		if err != nil {
+			p.Debugf("could not open replication stream to %s: %s", p.target, err.Error())
+			if !p.wait(retry.next()) {
+				return
+			}
+			continue
+		}
+		if err = p.sendSnapshot(stream); err == nil {
+			pending, err = p.stream(stream, pending, retry)
+		}
+		if err == nil {
+			return // closed down
+		}
+		p.Debugf("replication stream to %s failed: %s", p.target, err.Error())
+		if !p.wait(retry.next()) {
+			return
+		}
+	}
+}
+
+// stream sends queued mutations until the stream fails or the connection is closed
+func (p *peerConn) stream(stream peerpb.PeerSync_ReplicateClient, pending *peerpb.Mutation, retry *backoff) (*peerpb.Mutation, error) {
+	for {
+		if pending == nil {
+			select {
+			case <-p.ctx.Done():
+				_, _ = stream.CloseAndRecv()
+				return nil, nil
+			case pending = <-p.outbound:
+				p.refill()
+			}
+		}
+		if err := stream.Send(pending); err != nil {
+			if err == io.EOF {
+				_, err = stream.CloseAndRecv() // the real error is only available from here
+			}
+			return pending, err
+		}
+		pending = nil
+		retry.reset()
+	}
+}
+
+// sendSnapshot begins a new replication stream with our full state, for the peer to catch up from if it needs to
+func (p *peerConn) sendSnapshot(stream peerpb.PeerSync_ReplicateClient) error {
+	for _, m := range p.sync.manager.snapshot() {
+		m.Origin = p.sync.node
+		if err := stream.Send(m); err != nil {
+			if err == io.EOF {
+				_, err = stream.CloseAndRecv()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// wait pauses for the given duration, returning false if the connection was closed in the meantime
+func (p *peerConn) wait(d time.Duration) bool {
+	select {
+	case <-p.ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func (p *peerConn) close() {
+	p.cancel()
+	_ = p.conn.Close()
+}
+
+// lockChain runs remotely requested lock operations on each element in the order they arrived
+// without blocking the replication stream while a lock is waited on
+type lockChain struct {
+	mu    *sync.Mutex
+	tails map[string]chan struct{}
+}
+
+func newLockChain() *lockChain {
+	return &lockChain{
+		mu:    &sync.Mutex{},
+		tails: make(map[string]chan struct{}),
+	}
+}
+
+func lockChainKey(m *peerpb.Mutation) string {
+	return m.Origin + "|" + m.Namespace + "|" + strings.Join(m.Path, pathDelimeter)
+}
+
+// then runs fn once every previous operation queued under the same key has completed
+func (c *lockChain) then(key string, fn func()) {
+	done := make(chan struct{})
+	c.mu.Lock()
+	prev := c.tails[key]
+	c.tails[key] = done
+	c.mu.Unlock()
+
+	go func() {
+		if prev != nil {
+			<-prev
+		}
+		fn()
+		close(done)
+
+		c.mu.Lock()
+		if c.tails[key] == done {
+			delete(c.tails, key)
+		}
+		c.mu.Unlock()
+	}()
+}
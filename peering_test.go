@@ -0,0 +1,246 @@
+package whatnot
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/databeast/whatnot/access"
+	"github.com/databeast/whatnot/peerpb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const peerSyncTimeout = time.Second * 5
+
+func TestPeerSynchronization(t *testing.T) {
+	t.Run("Registered paths are replicated to peers", registeredPathIsReplicated)
+	t.Run("Element values are replicated to peers", elementValueIsReplicated)
+	t.Run("Element deletion is replicated to peers", elementDeletionIsReplicated)
+	t.Run("Element locks are replicated to peers", elementLockIsReplicated)
+	t.Run("Lock holders' roles are replicated to peers", lockActorIsReplicated)
+	t.Run("A failed manager leaves nothing running", failedManagerLeavesNothingRunning)
+	t.Run("Unlocks are kept when the queue to a peer is full", unlocksKeptFromFullQueue)
+}
+
+// bufconnCluster is a set of in-process listeners standing in for the network
+type bufconnCluster map[string]*bufconn.Listener
+
+func (c bufconnCluster) dialer(ctx context.Context, target string) (net.Conn, error) {
+	lis, ok := c[target]
+	if !ok {
+		return nil, fmt.Errorf("no such test peer %q", target)
+	}
+	return lis.DialContext(ctx)
+}
+
+func (c bufconnCluster) peerSyncOption(node string) WithPeerSync {
+	return WithPeerSync{
+		NodeName: node,
+		Listener: c[node],
+		DialOptions: []grpc.DialOption{
+			grpc.WithContextDialer(c.dialer),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		},
+	}
+}
+
+// createTestCluster creates a fully meshed set of managers, each with the test namespace registered
+func createTestCluster(t *testing.T, size int, opts ...ManagerOption) (managers []*NameSpaceManager, namespaces []*Namespace) {
+	cluster := bufconnCluster{}
+	for i := 0; i < size; i++ {
+		cluster[fmt.Sprintf("node%d", i)] = bufconn.Listen(1024 * 1024)
+	}
+	for i := 0; i < size; i++ {
+		node := fmt.Sprintf("node%d", i)
+		nsm, err := NewNamespaceManager(append([]ManagerOption{cluster.peerSyncOption(node)}, opts...)...)
+		if !assert.Nil(t, err, "creating clustered namespace manager failed") {
+			t.FailNow()
+		}
+		ns := NewNamespace(testNameSpace)
+		if !assert.Nil(t, nsm.RegisterNamespace(ns), "registering namespace failed") {
+			t.FailNow()
+		}
+		managers = append(managers, nsm)
+		namespaces = append(namespaces, ns)
+	}
+	for i, nsm := range managers {
+		for j := range managers {
+			if i == j {
+				continue
+			}
+			if !assert.Nil(t, nsm.AddPeer(fmt.Sprintf("node%d", j)), "adding peer failed") {
+				t.FailNow()
+			}
+		}
+	}
+	t.Cleanup(func() {
+		for _, nsm := range managers {
+			_ = nsm.Close()
+		}
+	})
+	return managers, namespaces
+}
+
+func registeredPathIsReplicated(t *testing.T) {
+	_, namespaces := createTestCluster(t, 3)
+
+	err := namespaces[0].RegisterAbsolutePath(PathString("/replicated/path").ToAbsolutePath())
+	if !assert.Nil(t, err, "registering path returned error") {
+		return
+	}
+	for i, ns := range namespaces[1:] {
+		assert.Eventually(t, func() bool {
+			return ns.FetchAbsolutePath("/replicated/path") != nil
+		}, peerSyncTimeout, time.Millisecond*10, "path was not replicated to peer %d", i+1)
+	}
+}
+
+func elementValueIsReplicated(t *testing.T) {
+	_, namespaces := createTestCluster(t, 3)
+
+	elem, err := namespaces[1].FetchOrCreateAbsolutePath("/replicated/value")
+	if !assert.Nil(t, err, "creating path returned error") {
+		return
+	}
+	elem.SetValue(ElementValue{Val: "replicated value"}, ChangeEdited, access.Role{})
+
+	for _, i := range []int{0, 2} {
+		ns := namespaces[i]
+		assert.Eventually(t, func() bool {
+			peerElem := ns.FetchAbsolutePath("/replicated/value")
+			return peerElem != nil && peerElem.GetValue().Val == "replicated value"
+		}, peerSyncTimeout, time.Millisecond*10, "value was not replicated to peer %d", i)
+	}
+}
+
+func elementDeletionIsReplicated(t *testing.T) {
+	_, namespaces := createTestCluster(t, 2)
+
+	err := namespaces[0].RegisterAbsolutePath(PathString("/replicated/deletion").ToAbsolutePath())
+	if !assert.Nil(t, err, "registering path returned error") {
+		return
+	}
+	if !assert.Eventually(t, func() bool {
+		return namespaces[1].FetchAbsolutePath("/replicated/deletion") != nil
+	}, peerSyncTimeout, time.Millisecond*10, "path was not replicated") {
+		return
+	}
+
+	err = namespaces[0].FetchAbsolutePath("/replicated/deletion").Delete()
+	if !assert.Nil(t, err, "deleting element returned error") {
+		return
+	}
+	assert.Nil(t, namespaces[0].FetchAbsolutePath("/replicated/deletion"), "deleted element is still present")
+	assert.Eventually(t, func() bool {
+		return namespaces[1].FetchAbsolutePath("/replicated/deletion") == nil
+	}, peerSyncTimeout, time.Millisecond*10, "deletion was not replicated")
+}
+
+func elementLockIsReplicated(t *testing.T) {
+	_, namespaces := createTestCluster(t, 2)
+
+	elem, err := namespaces[0].FetchOrCreateAbsolutePath("/replicated/lock")
+	if !assert.Nil(t, err, "creating path returned error") {
+		return
+	}
+	elem.Lock()
+
+	var peerElem *PathElement
+	if !assert.Eventually(t, func() bool {
+		peerElem = namespaces[1].FetchAbsolutePath("/replicated/lock")
+		return peerElem != nil && peerElem.reslock.isLocked()
+	}, peerSyncTimeout, time.Millisecond*10, "lock was not replicated") {
+		return
+	}
+
+	elem.UnLock()
+	assert.Eventually(t, func() bool {
+		return !peerElem.reslock.isLocked()
+	}, peerSyncTimeout, time.Millisecond*10, "unlock was not replicated")
+}
+
+func lockActorIsReplicated(t *testing.T) {
+	_, namespaces := createTestCluster(t, 2)
+
+	elem, err := namespaces[0].FetchOrCreateAbsolutePath("/replicated/actor")
+	if !assert.Nil(t, err, "creating path returned error") {
+		return
+	}
+	var peerElem *PathElement
+	if !assert.Eventually(t, func() bool {
+		peerElem = namespaces[1].FetchAbsolutePath("/replicated/actor")
+		return peerElem != nil
+	}, peerSyncTimeout, time.Millisecond*10, "path was not replicated") {
+		return
+	}
+	sub := peerElem.SubscribeToEvents(false)
+	defer peerElem.UnSubscribeFromEvents(sub)
+
+	// subscribers not ready to receive an event are dropped, so the loop below must already be waiting
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		_ = elem.LockContext(access.WithRole(context.Background(), access.Role{Name: "replicator"}))
+	}()
+
+	timeout := time.After(peerSyncTimeout)
+	for {
+		select {
+		case e := <-sub.Events():
+			if e.Change == ChangeLocked {
+				assert.Equal(t, "replicator", e.Actor.Name, "peer's lock event did not carry the role holding the lock")
+				return
+			}
+		case <-timeout:
+			t.Error("lock was not replicated")
+			return
+		}
+	}
+}
+
+func failedManagerLeavesNothingRunning(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	addr := lis.Addr().String()
+
+	// raft members that leave out this member are only found to be wrong after WithPeerSync was applied
+	_, err = NewNamespaceManager(WithPeerSync{NodeName: addr, Listener: lis}, WithRaftConfig{ID: addr, Members: []string{"elsewhere"}})
+	assert.NotNil(t, err, "manager was created with a bad raft configuration")
+
+	again, err := net.Listen("tcp", addr)
+	if assert.Nil(t, err, "listener of the failed manager was left open") {
+		_ = again.Close()
+	}
+}
+
+func unlocksKeptFromFullQueue(t *testing.T) {
+	p := newPeerConn(&peerSync{}, "stalled", nil)
+	p.outbound = make(chan *peerpb.Mutation, 1)
+	defer p.cancel()
+
+	p.enqueue(&peerpb.Mutation{Op: peerpb.Operation_OPERATION_LOCK, Sequence: 1})
+	p.enqueue(&peerpb.Mutation{Op: peerpb.Operation_OPERATION_SET_VALUE, Sequence: 2})
+	p.enqueue(&peerpb.Mutation{Op: peerpb.Operation_OPERATION_UNLOCK, Sequence: 3})
+	p.enqueue(&peerpb.Mutation{Op: peerpb.Operation_OPERATION_SET_VALUE, Sequence: 4})
+	assert.True(t, p.behind, "full queue was not reported")
+
+	var sent []uint64
+	for len(sent) < 2 {
+		select {
+		case m := <-p.outbound:
+			sent = append(sent, m.Sequence)
+			p.refill()
+		case <-time.After(time.Second):
+			t.Errorf("queue stopped after %v", sent)
+			return
+		}
+	}
+	assert.Equal(t, []uint64{1, 3}, sent, "unlock was dropped from a full queue")
+	assert.False(t, p.behind, "queue did not catch up once emptied")
+}
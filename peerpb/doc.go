@@ -0,0 +1,7 @@
+/*
+Package peerpb contains the generated gRPC service used by NameSpaceManager
+instances to replicate Namespace changes between each other
+*/
+package peerpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative peer.proto
@@ -0,0 +1,345 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: peer.proto
+
+package peerpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	PeerSync_Replicate_FullMethodName     = "/whatnot.peer.PeerSync/Replicate"
+	PeerSync_Ping_FullMethodName          = "/whatnot.peer.PeerSync/Ping"
+	PeerSync_RequestVote_FullMethodName   = "/whatnot.peer.PeerSync/RequestVote"
+	PeerSync_AppendEntries_FullMethodName = "/whatnot.peer.PeerSync/AppendEntries"
+	PeerSync_Propose_FullMethodName       = "/whatnot.peer.PeerSync/Propose"
+	PeerSync_Digest_FullMethodName        = "/whatnot.peer.PeerSync/Digest"
+)
+
+// PeerSyncClient is the client API for PeerSync service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PeerSyncClient interface {
+	// Replicate carries an ordered stream of Namespace mutations
+	// from the instance they occurred on to one of its peers
+	Replicate(ctx context.Context, opts ...grpc.CallOption) (PeerSync_ReplicateClient, error)
+	// Ping is the liveness heartbeat between peers
+	Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingReply, error)
+	// RequestVote is the raft leader election request
+	RequestVote(ctx context.Context, in *VoteRequest, opts ...grpc.CallOption) (*VoteReply, error)
+	// AppendEntries is the raft log replication and leader heartbeat request
+	AppendEntries(ctx context.Context, in *AppendRequest, opts ...grpc.CallOption) (*AppendReply, error)
+	// Propose submits a command to the raft leader, to be committed to the log
+	Propose(ctx context.Context, in *ProposeRequest, opts ...grpc.CallOption) (*ProposeReply, error)
+	// Digest describes a single element and the hashes of the subtrees beneath it
+	// so anti-entropy reconciliation only needs to descend into subtrees that differ
+	Digest(ctx context.Context, in *DigestRequest, opts ...grpc.CallOption) (*DigestReply, error)
+}
+
+type peerSyncClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPeerSyncClient(cc grpc.ClientConnInterface) PeerSyncClient {
+	return &peerSyncClient{cc}
+}
+
+func (c *peerSyncClient) Replicate(ctx context.Context, opts ...grpc.CallOption) (PeerSync_ReplicateClient, error) {
+	stream, err := c.cc.NewStream(ctx, &PeerSync_ServiceDesc.Streams[0], PeerSync_Replicate_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &peerSyncReplicateClient{stream}
+	return x, nil
+}
+
+type PeerSync_ReplicateClient interface {
+	Send(*Mutation) error
+	CloseAndRecv() (*ReplicateSummary, error)
+	grpc.ClientStream
+}
+
+type peerSyncReplicateClient struct {
+	grpc.ClientStream
+}
+
+func (x *peerSyncReplicateClient) Send(m *Mutation) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *peerSyncReplicateClient) CloseAndRecv() (*ReplicateSummary, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ReplicateSummary)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *peerSyncClient) Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingReply, error) {
+	out := new(PingReply)
+	err := c.cc.Invoke(ctx, PeerSync_Ping_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *peerSyncClient) RequestVote(ctx context.Context, in *VoteRequest, opts ...grpc.CallOption) (*VoteReply, error) {
+	out := new(VoteReply)
+	err := c.cc.Invoke(ctx, PeerSync_RequestVote_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *peerSyncClient) AppendEntries(ctx context.Context, in *AppendRequest, opts ...grpc.CallOption) (*AppendReply, error) {
+	out := new(AppendReply)
+	err := c.cc.Invoke(ctx, PeerSync_AppendEntries_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *peerSyncClient) Propose(ctx context.Context, in *ProposeRequest, opts ...grpc.CallOption) (*ProposeReply, error) {
+	out := new(ProposeReply)
+	err := c.cc.Invoke(ctx, PeerSync_Propose_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *peerSyncClient) Digest(ctx context.Context, in *DigestRequest, opts ...grpc.CallOption) (*DigestReply, error) {
+	out := new(DigestReply)
+	err := c.cc.Invoke(ctx, PeerSync_Digest_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PeerSyncServer is the server API for PeerSync service.
+// All implementations must embed UnimplementedPeerSyncServer
+// for forward compatibility
+type PeerSyncServer interface {
+	// Replicate carries an ordered stream of Namespace mutations
+	// from the instance they occurred on to one of its peers
+	Replicate(PeerSync_ReplicateServer) error
+	// Ping is the liveness heartbeat between peers
+	Ping(context.Context, *PingRequest) (*PingReply, error)
+	// RequestVote is the raft leader election request
+	RequestVote(context.Context, *VoteRequest) (*VoteReply, error)
+	// AppendEntries is the raft log replication and leader heartbeat request
+	AppendEntries(context.Context, *AppendRequest) (*AppendReply, error)
+	// Propose submits a command to the raft leader, to be committed to the log
+	Propose(context.Context, *ProposeRequest) (*ProposeReply, error)
+	// Digest describes a single element and the hashes of the subtrees beneath it
+	// so anti-entropy reconciliation only needs to descend into subtrees that differ
+	Digest(context.Context, *DigestRequest) (*DigestReply, error)
+	mustEmbedUnimplementedPeerSyncServer()
+}
+
+// UnimplementedPeerSyncServer must be embedded to have forward compatible implementations.
+type UnimplementedPeerSyncServer struct {
+}
+
+func (UnimplementedPeerSyncServer) Replicate(PeerSync_ReplicateServer) error {
+	return status.Errorf(codes.Unimplemented, "method Replicate not implemented")
+}
+func (UnimplementedPeerSyncServer) Ping(context.Context, *PingRequest) (*PingReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ping not implemented")
+}
+func (UnimplementedPeerSyncServer) RequestVote(context.Context, *VoteRequest) (*VoteReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestVote not implemented")
+}
+func (UnimplementedPeerSyncServer) AppendEntries(context.Context, *AppendRequest) (*AppendReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AppendEntries not implemented")
+}
+func (UnimplementedPeerSyncServer) Propose(context.Context, *ProposeRequest) (*ProposeReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Propose not implemented")
+}
+func (UnimplementedPeerSyncServer) Digest(context.Context, *DigestRequest) (*DigestReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Digest not implemented")
+}
+func (UnimplementedPeerSyncServer) mustEmbedUnimplementedPeerSyncServer() {}
+
+// UnsafePeerSyncServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PeerSyncServer will
+// result in compilation errors.
+type UnsafePeerSyncServer interface {
+	mustEmbedUnimplementedPeerSyncServer()
+}
+
+func RegisterPeerSyncServer(s grpc.ServiceRegistrar, srv PeerSyncServer) {
+	s.RegisterService(&PeerSync_ServiceDesc, srv)
+}
+
+func _PeerSync_Replicate_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PeerSyncServer).Replicate(&peerSyncReplicateServer{stream})
+}
+
+type PeerSync_ReplicateServer interface {
+	SendAndClose(*ReplicateSummary) error
+	Recv() (*Mutation, error)
+	grpc.ServerStream
+}
+
+type peerSyncReplicateServer struct {
+	grpc.ServerStream
+}
+
+func (x *peerSyncReplicateServer) SendAndClose(m *ReplicateSummary) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *peerSyncReplicateServer) Recv() (*Mutation, error) {
+	m := new(Mutation)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _PeerSync_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PeerSyncServer).Ping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PeerSync_Ping_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PeerSyncServer).Ping(ctx, req.(*PingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PeerSync_RequestVote_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VoteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PeerSyncServer).RequestVote(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PeerSync_RequestVote_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PeerSyncServer).RequestVote(ctx, req.(*VoteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PeerSync_AppendEntries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AppendRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PeerSyncServer).AppendEntries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PeerSync_AppendEntries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PeerSyncServer).AppendEntries(ctx, req.(*AppendRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PeerSync_Propose_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProposeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PeerSyncServer).Propose(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PeerSync_Propose_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PeerSyncServer).Propose(ctx, req.(*ProposeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PeerSync_Digest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DigestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PeerSyncServer).Digest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PeerSync_Digest_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PeerSyncServer).Digest(ctx, req.(*DigestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// PeerSync_ServiceDesc is the grpc.ServiceDesc for PeerSync service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PeerSync_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "whatnot.peer.PeerSync",
+	HandlerType: (*PeerSyncServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Ping",
+			Handler:    _PeerSync_Ping_Handler,
+		},
+		{
+			MethodName: "RequestVote",
+			Handler:    _PeerSync_RequestVote_Handler,
+		},
+		{
+			MethodName: "AppendEntries",
+			Handler:    _PeerSync_AppendEntries_Handler,
+		},
+		{
+			MethodName: "Propose",
+			Handler:    _PeerSync_Propose_Handler,
+		},
+		{
+			MethodName: "Digest",
+			Handler:    _PeerSync_Digest_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Replicate",
+			Handler:       _PeerSync_Replicate_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "peer.proto",
+}
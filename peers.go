@@ -0,0 +1,178 @@
+package whatnot
+
+/*
+Static cluster membership, for deployments small enough to simply declare every instance
+each declared peer is kept connected, reconnected with backoff, and checked for liveness by heartbeat
+*/
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/databeast/whatnot/peerpb"
+)
+
+const (
+	defaultHeartbeatInterval = time.Second
+	// consecutive failed heartbeats before a peer is no longer considered alive
+	defaultHeartbeatFailures = 3
+
+	minReconnectBackoff = time.Millisecond * 100
+	maxReconnectBackoff = time.Second * 30
+)
+
+// WithPeers declares a fixed list of peer addresses that this manager will replicate to
+// it requires WithPeerSync to be configured as well. The list may include this instance's
+// own address, it is recognized and skipped, so every instance can share identical configuration
+type WithPeers []string
+
+func (w WithPeers) name() optionName {
+	return optionStaticPeers
+}
+
+func (w WithPeers) apply(manager *NameSpaceManager) (err error) {
+	if len(w) == 0 {
+		return newConfigError("no peer addresses passed in WithPeers config option")
+	}
+	manager.static = append(manager.static, w...)
+	return nil
+}
+
+// joinPeers connects to every statically declared peer, once all options have been applied
+func (m *NameSpaceManager) joinPeers() error {
+	if len(m.static) == 0 {
+		return nil
+	}
+	for _, target := range m.static {
+		err := m.peering.addPeer(target)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PeerStatus describes the liveness of a single peer, as seen by this instance
+type PeerStatus struct {
+	Address   string    // the address this peer was added with
+	NodeName  string    // the node name the peer reports for itself
+	Alive     bool      // the peer is currently answering heartbeats
+	LastSeen  time.Time // time of the last successful heartbeat
+	Failures  int       // consecutive failed heartbeats
+	LastError error     // the reason for the most recent heartbeat failure
+}
+
+// PeerStatus returns the current liveness of every peer
+func (m *NameSpaceManager) PeerStatus() (statuses []PeerStatus) {
+	if m.peering == nil {
+		return nil
+	}
+	m.peering.mu.Lock()
+	for _, p := range m.peering.peers {
+		statuses = append(statuses, p.currentStatus())
+	}
+	m.peering.mu.Unlock()
+	return statuses
+}
+
+// PeerAlive reports if the peer at the given address is currently answering heartbeats
+func (m *NameSpaceManager) PeerAlive(target string) bool {
+	if m.peering == nil {
+		return false
+	}
+	m.peering.mu.Lock()
+	p, ok := m.peering.peers[target]
+	m.peering.mu.Unlock()
+	if !ok {
+		return false
+	}
+	return p.currentStatus().Alive
+}
+
+// Ping implements the PeerSync gRPC service liveness check
+func (s *peerSync) Ping(ctx context.Context, req *peerpb.PingRequest) (*peerpb.PingReply, error) {
+	return &peerpb.PingReply{Node: s.node}, nil
+}
+
+func (p *peerConn) currentStatus() PeerStatus {
+	p.statusmu.Lock()
+	defer p.statusmu.Unlock()
+	return p.status
+}
+
+// heartbeat pings the peer every interval until the connection is closed
+func (p *peerConn) heartbeat() {
+	ticker := time.NewTicker(p.sync.interval)
+	defer ticker.Stop()
+	for {
+		p.ping()
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *peerConn) ping() {
+	ctx, cancel := context.WithTimeout(p.ctx, p.sync.interval)
+	reply, err := p.client.Ping(ctx, &peerpb.PingRequest{Origin: p.sync.node})
+	cancel()
+	if p.ctx.Err() != nil {
+		return // closed while waiting
+	}
+
+	if err == nil && reply.Node == p.sync.node {
+		p.Infof("peer %s is this instance, no longer replicating to it", p.target)
+		go func() { _ = p.sync.removePeer(p.target) }()
+		return
+	}
+
+	p.statusmu.Lock()
+	defer p.statusmu.Unlock()
+	wasAlive := p.status.Alive
+	if err != nil {
+		p.status.Failures++
+		p.status.LastError = err
+		p.status.Alive = p.status.Failures < defaultHeartbeatFailures && !p.status.LastSeen.IsZero()
+	} else {
+		p.status.NodeName = reply.Node
+		p.status.LastSeen = time.Now()
+		p.status.Failures = 0
+		p.status.LastError = nil
+		p.status.Alive = true
+	}
+	if wasAlive != p.status.Alive {
+		if p.status.Alive {
+			p.Infof("peer %s (%s) is alive", p.target, p.status.NodeName)
+		} else {
+			p.Warnf("peer %s (%s) is no longer responding", p.target, p.status.NodeName)
+		}
+	}
+}
+
+// backoff provides jittered exponential delays between reconnection attempts
+type backoff struct {
+	attempt int
+}
+
+func newBackoff() *backoff {
+	return &backoff{}
+}
+
+func (b *backoff) next() time.Duration {
+	delay := minReconnectBackoff << uint(b.attempt)
+	if delay > maxReconnectBackoff || delay <= 0 {
+		delay = maxReconnectBackoff
+	} else {
+		b.attempt++
+	}
+	// up to 20% jitter either way, so reconnecting peers don't stampede
+	jitter := time.Duration(rand.Int63n(int64(delay)/5*2+1)) - delay/5
+	return delay + jitter
+}
+
+func (b *backoff) reset() {
+	b.attempt = 0
+}
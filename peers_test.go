@@ -0,0 +1,102 @@
+package whatnot
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const testHeartbeatInterval = time.Millisecond * 50
+
+func TestStaticPeers(t *testing.T) {
+	t.Run("Declared peers become alive, skipping ourselves", declaredPeersBecomeAlive)
+	t.Run("Stopped peer is no longer alive", stoppedPeerIsNotAlive)
+	t.Run("Peers require peer sync", peersRequirePeerSync)
+	t.Run("Reconnection backoff grows to a limit", reconnectBackoffGrows)
+}
+
+// createStaticTestCluster creates managers which all share the same WithPeers declaration
+func createStaticTestCluster(t *testing.T, size int) (cluster bufconnCluster, managers []*NameSpaceManager) {
+	cluster = bufconnCluster{}
+	var addresses WithPeers
+	for i := 0; i < size; i++ {
+		node := fmt.Sprintf("node%d", i)
+		cluster[node] = bufconn.Listen(1024 * 1024)
+		addresses = append(addresses, node)
+	}
+	for i := 0; i < size; i++ {
+		opt := cluster.peerSyncOption(fmt.Sprintf("node%d", i))
+		opt.HeartbeatInterval = testHeartbeatInterval
+		nsm, err := NewNamespaceManager(opt, addresses)
+		if !assert.Nil(t, err, "creating statically clustered manager failed") {
+			t.FailNow()
+		}
+		managers = append(managers, nsm)
+	}
+	t.Cleanup(func() {
+		for _, nsm := range managers {
+			_ = nsm.Close()
+		}
+	})
+	return cluster, managers
+}
+
+func declaredPeersBecomeAlive(t *testing.T) {
+	_, managers := createStaticTestCluster(t, 3)
+
+	for i, nsm := range managers {
+		assert.Eventually(t, func() bool {
+			statuses := nsm.PeerStatus()
+			if len(statuses) != 2 {
+				return false
+			}
+			for _, s := range statuses {
+				if !s.Alive {
+					return false
+				}
+			}
+			return true
+		}, peerSyncTimeout, testHeartbeatInterval, "node%d did not see both other peers alive", i)
+	}
+
+	for _, s := range managers[0].PeerStatus() {
+		assert.Equal(t, s.Address, s.NodeName, "peer reported unexpected node name")
+		assert.NotEqual(t, "node0", s.NodeName, "node is peered with itself")
+	}
+}
+
+func stoppedPeerIsNotAlive(t *testing.T) {
+	_, managers := createStaticTestCluster(t, 2)
+
+	if !assert.Eventually(t, func() bool {
+		return managers[0].PeerAlive("node1")
+	}, peerSyncTimeout, testHeartbeatInterval, "peer never became alive") {
+		return
+	}
+
+	_ = managers[1].Close()
+	assert.Eventually(t, func() bool {
+		return !managers[0].PeerAlive("node1")
+	}, peerSyncTimeout, testHeartbeatInterval, "stopped peer is still considered alive")
+}
+
+func peersRequirePeerSync(t *testing.T) {
+	_, err := NewNamespaceManager(WithPeers{"localhost:7700"})
+	assert.NotNil(t, err, "static peers were accepted without peer sync")
+}
+
+func reconnectBackoffGrows(t *testing.T) {
+	b := newBackoff()
+	first := b.next()
+	assert.InDelta(t, float64(minReconnectBackoff), float64(first), float64(minReconnectBackoff)/5, "first backoff out of range")
+	var last time.Duration
+	for i := 0; i < 20; i++ {
+		last = b.next()
+	}
+	assert.InDelta(t, float64(maxReconnectBackoff), float64(last), float64(maxReconnectBackoff)/5, "backoff did not settle at its maximum")
+	b.reset()
+	assert.InDelta(t, float64(minReconnectBackoff), float64(b.next()), float64(minReconnectBackoff)/5, "backoff did not reset")
+}
@@ -0,0 +1,558 @@
+package whatnot
+
+/*
+Lightweight Raft keeps leases exclusive across every instance in a cluster, rather than just within one process.
+Lease acquisition, release and expiry are committed to a replicated log, and every member applies that log to
+the same table of held leases. There is no persistence and no log compaction - like the rest of whatnot, the
+state lives only as long as a quorum of members stays online.
+*/
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/databeast/whatnot/peerpb"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultRaftElectionTimeout = time.Second
+	// leader heartbeats are sent this many times per election timeout
+	raftHeartbeatsPerTimeout = 5
+	// the ID a single-member raft cluster uses when none is given
+	defaultRaftID = "local"
+)
+
+// ErrNotRaftLeader is returned for proposals made to a member that is not the current raft leader
+var ErrNotRaftLeader = errors.New("not the raft leader")
+
+type raftState int
+
+const (
+	raftFollower raftState = iota
+	raftCandidate
+	raftLeader
+)
+
+// WithRaftConfig enables Raft Quorum synchromization as WithRaft does, with the cluster's members given
+// with it enabled, every lease is committed to a raft log shared by all members, making it exclusive cluster-wide.
+// The zero value runs a single-member cluster
+type WithRaftConfig struct {
+	// ID identifies this member, for the default gRPC transport this must be its PeerSync address
+	ID string
+	// Members lists the ID of every voting member of the cluster, including this one
+	Members []string
+	// Transport carries raft messages between members, defaulting to the PeerSync gRPC service
+	Transport RaftTransport
+	// ElectionTimeout is how long followers wait to hear from a leader before starting an election
+	ElectionTimeout time.Duration
+}
+
+func (w WithRaftConfig) name() optionName {
+	return optionSyncRaft
+}
+
+func (w WithRaftConfig) apply(manager *NameSpaceManager) (err error) {
+	if manager.raftopts != nil {
+		return newConfigError("raft synchronization is already configured")
+	}
+	manager.raftopts = &w
+	return nil
+}
+
+// checkRaft fills in the defaults of the raft configuration, and reports any error in it
+// before anything else is started
+func (m *NameSpaceManager) checkRaft() error {
+	if m.raftopts == nil {
+		return nil
+	}
+	opts := m.raftopts
+
+	if opts.ID == "" {
+		if len(opts.Members) > 0 {
+			return newConfigError("WithRaftConfig requires an ID when members are declared")
+		}
+		opts.ID = defaultRaftID
+	}
+	if len(opts.Members) == 0 {
+		opts.Members = []string{opts.ID}
+	}
+	member := false
+	for _, id := range opts.Members {
+		member = member || id == opts.ID
+	}
+	if !member {
+		return newConfigError("WithRaftConfig members must include this member's own ID")
+	}
+	if opts.Transport == nil && len(opts.Members) > 1 && m.peering == nil {
+		return newConfigError("WithRaftConfig requires WithPeerSync or a Transport")
+	}
+	if opts.ElectionTimeout <= 0 {
+		opts.ElectionTimeout = defaultRaftElectionTimeout
+	}
+	return nil
+}
+
+// startRaft begins participating in the raft cluster, once all options have been checked
+func (m *NameSpaceManager) startRaft() error {
+	if m.raftopts == nil {
+		return nil
+	}
+	opts := *m.raftopts
+	if opts.Transport == nil {
+		if len(opts.Members) == 1 {
+			opts.Transport = NewInMemoryRaftTransport()
+		} else {
+			opts.Transport = newGrpcRaftTransport(m.peering)
+		}
+	}
+
+	m.raft = newRaftNode(opts)
+	m.raft.run()
+	return nil
+}
+
+// RaftLeader returns the ID of the current raft leader, as far as this member knows
+func (m *NameSpaceManager) RaftLeader() string {
+	if m.raft == nil {
+		return ""
+	}
+	m.raft.mu.Lock()
+	defer m.raft.mu.Unlock()
+	return m.raft.leader
+}
+
+// raftNode is this instance's membership of a raft cluster
+type raftNode struct {
+	logsupport
+	id        string
+	members   []string
+	transport RaftTransport
+	leases    *raftLeaseTable
+	timeout   time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu          *sync.Mutex
+	state       raftState
+	term        uint64
+	votedFor    string
+	leader      string
+	log         []*peerpb.LogEntry // log[0] is a sentinel, so indexes match raft's 1-based log
+	commitIndex uint64
+	lastApplied uint64
+	lastContact time.Time // when we last heard from a leader, or voted for a candidate
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	inflight    map[string]bool      // followers with an AppendEntries request already outstanding
+	acks        map[string]time.Time // when each follower last answered us
+	waiting     map[uint64]*raftProposal
+}
+
+// raftProposal is a command waiting for its log entry to be applied
+type raftProposal struct {
+	term   uint64
+	result chan raftOutcome
+}
+
+type raftOutcome struct {
+	result []byte
+	err    error
+}
+
+func newRaftNode(opts WithRaftConfig) *raftNode {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &raftNode{
+		id:          opts.ID,
+		members:     opts.Members,
+		transport:   opts.Transport,
+		leases:      newRaftLeaseTable(),
+		timeout:     opts.ElectionTimeout,
+		ctx:         ctx,
+		cancel:      cancel,
+		mu:          &sync.Mutex{},
+		log:         []*peerpb.LogEntry{{}},
+		lastContact: time.Now(),
+		waiting:     make(map[uint64]*raftProposal),
+	}
+}
+
+func (r *raftNode) run() {
+	r.transport.register(r.id, r)
+	if len(r.members) == 1 {
+		r.startElection() // nobody else to wait for
+	}
+	go func() {
+		ticker := time.NewTicker(r.timeout / raftHeartbeatsPerTimeout)
+		defer ticker.Stop()
+		electionAfter := r.electionDelay()
+		for {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			r.mu.Lock()
+			state := r.state
+			waited := time.Since(r.lastContact)
+			r.mu.Unlock()
+
+			if state == raftLeader {
+				r.replicate()
+				r.expireLeases()
+				continue
+			}
+			if waited > electionAfter {
+				r.startElection()
+				electionAfter = r.electionDelay()
+			}
+		}
+	}()
+}
+
+func (r *raftNode) close() {
+	r.cancel()
+	r.transport.unregister(r.id)
+	r.mu.Lock()
+	r.failWaiting()
+	r.mu.Unlock()
+}
+
+// electionDelay is randomized between one and two election timeouts, so split votes are unlikely to repeat
+func (r *raftNode) electionDelay() time.Duration {
+	return r.timeout + time.Duration(rand.Int63n(int64(r.timeout)))
+}
+
+func (r *raftNode) lastLog() (index uint64, term uint64) {
+	last := r.log[len(r.log)-1]
+	return last.Index, last.Term
+}
+
+func (r *raftNode) quorum() int {
+	return len(r.members)/2 + 1
+}
+
+// under r.mu
+func (r *raftNode) becomeFollower(term uint64) {
+	if r.state == raftLeader {
+		r.Infof("raft member %s stepping down as leader in term %d", r.id, term)
+		r.failWaiting()
+	}
+	r.state = raftFollower
+	if term > r.term {
+		r.term = term
+		r.votedFor = ""
+	}
+}
+
+// under r.mu
+func (r *raftNode) becomeLeader() {
+	r.Infof("raft member %s elected leader for term %d", r.id, r.term)
+	r.state = raftLeader
+	r.leader = r.id
+	last, _ := r.lastLog()
+	r.nextIndex = make(map[string]uint64)
+	r.matchIndex = make(map[string]uint64)
+	r.inflight = make(map[string]bool)
+	r.acks = make(map[string]time.Time)
+	for _, id := range r.members {
+		r.nextIndex[id] = last + 1
+	}
+	// a new leader can only commit earlier entries once an entry from its own term commits
+	r.log = append(r.log, &peerpb.LogEntry{Term: r.term, Index: last + 1})
+	r.advanceCommit()
+}
+
+// failWaiting releases every waiting proposal, under r.mu
+func (r *raftNode) failWaiting() {
+	for idx, p := range r.waiting {
+		p.result <- raftOutcome{err: ErrNotRaftLeader}
+		delete(r.waiting, idx)
+	}
+}
+
+func (r *raftNode) startElection() {
+	r.mu.Lock()
+	r.state = raftCandidate
+	r.term++
+	r.votedFor = r.id
+	r.leader = ""
+	r.lastContact = time.Now()
+	term := r.term
+	lastIndex, lastTerm := r.lastLog()
+	r.Debugf("raft member %s starting election for term %d", r.id, term)
+	if len(r.members) == 1 {
+		r.becomeLeader()
+		r.mu.Unlock()
+		return
+	}
+	r.mu.Unlock()
+
+	req := &peerpb.VoteRequest{Term: term, Candidate: r.id, LastLogIndex: lastIndex, LastLogTerm: lastTerm}
+	votes := 1
+	votemu := &sync.Mutex{}
+	for _, id := range r.members {
+		if id == r.id {
+			continue
+		}
+		go func(id string) {
+			ctx, cancel := context.WithTimeout(r.ctx, r.timeout)
+			reply, err := r.transport.requestVote(ctx, id, req)
+			cancel()
+			if err != nil {
+				return
+			}
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if reply.Term > r.term {
+				r.becomeFollower(reply.Term)
+				return
+			}
+			if !reply.Granted || r.state != raftCandidate || r.term != term {
+				return
+			}
+			votemu.Lock()
+			votes++
+			won := votes == r.quorum()
+			votemu.Unlock()
+			if won {
+				r.becomeLeader()
+			}
+		}(id)
+	}
+}
+
+// handleRequestVote answers another member's election request
+func (r *raftNode) handleRequestVote(req *peerpb.VoteRequest) *peerpb.VoteReply {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req.Term > r.term {
+		r.becomeFollower(req.Term)
+	}
+	if req.Term < r.term {
+		return &peerpb.VoteReply{Term: r.term}
+	}
+	lastIndex, lastTerm := r.lastLog()
+	upToDate := req.LastLogTerm > lastTerm || (req.LastLogTerm == lastTerm && req.LastLogIndex >= lastIndex)
+	if (r.votedFor == "" || r.votedFor == req.Candidate) && upToDate {
+		r.votedFor = req.Candidate
+		r.lastContact = time.Now()
+		return &peerpb.VoteReply{Term: r.term, Granted: true}
+	}
+	return &peerpb.VoteReply{Term: r.term}
+}
+
+// replicate sends any new log entries, or an empty heartbeat, to every follower
+func (r *raftNode) replicate() {
+	for _, id := range r.members {
+		if id == r.id {
+			continue
+		}
+		r.mu.Lock()
+		if r.state != raftLeader || r.inflight[id] {
+			r.mu.Unlock()
+			continue
+		}
+		next := r.nextIndex[id]
+		req := &peerpb.AppendRequest{
+			Term:         r.term,
+			Leader:       r.id,
+			PrevLogIndex: next - 1,
+			PrevLogTerm:  r.log[next-1].Term,
+			Entries:      append([]*peerpb.LogEntry{}, r.log[next:]...),
+			LeaderCommit: r.commitIndex,
+		}
+		r.inflight[id] = true
+		r.mu.Unlock()
+
+		go r.sendAppend(id, req)
+	}
+}
+
+func (r *raftNode) sendAppend(id string, req *peerpb.AppendRequest) {
+	ctx, cancel := context.WithTimeout(r.ctx, r.timeout)
+	reply, err := r.transport.appendEntries(ctx, id, req)
+	cancel()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.inflight != nil {
+		delete(r.inflight, id)
+	}
+	if err != nil || r.state != raftLeader || r.term != req.Term {
+		return
+	}
+	r.acks[id] = time.Now()
+	if reply.Term > r.term {
+		r.becomeFollower(reply.Term)
+		return
+	}
+	if reply.Success {
+		if reply.MatchIndex > r.matchIndex[id] {
+			r.matchIndex[id] = reply.MatchIndex
+		}
+		r.nextIndex[id] = r.matchIndex[id] + 1
+		r.advanceCommit()
+	} else if r.nextIndex[id] > 1 {
+		r.nextIndex[id]-- // walk back until our logs agree
+	}
+}
+
+// advanceCommit commits every entry from the current term that a quorum has stored, under r.mu
+func (r *raftNode) advanceCommit() {
+	last, _ := r.lastLog()
+	for n := last; n > r.commitIndex; n-- {
+		if r.log[n].Term != r.term {
+			break
+		}
+		stored := 1
+		for id, match := range r.matchIndex {
+			if id != r.id && match >= n {
+				stored++
+			}
+		}
+		if stored >= r.quorum() {
+			r.commitIndex = n
+			r.applyCommitted()
+			return
+		}
+	}
+}
+
+// handleAppendEntries accepts log entries and heartbeats from the leader
+func (r *raftNode) handleAppendEntries(req *peerpb.AppendRequest) *peerpb.AppendReply {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req.Term < r.term {
+		return &peerpb.AppendReply{Term: r.term}
+	}
+	if req.Term > r.term || r.state != raftFollower {
+		r.becomeFollower(req.Term)
+	}
+	r.leader = req.Leader
+	r.lastContact = time.Now()
+
+	lastIndex, _ := r.lastLog()
+	if req.PrevLogIndex > lastIndex || r.log[req.PrevLogIndex].Term != req.PrevLogTerm {
+		return &peerpb.AppendReply{Term: r.term}
+	}
+	for _, e := range req.Entries {
+		if e.Index < uint64(len(r.log)) {
+			if r.log[e.Index].Term == e.Term {
+				continue
+			}
+			r.log = r.log[:e.Index] // conflicting entry, discard it and everything after
+		}
+		r.log = append(r.log, e)
+	}
+	match := req.PrevLogIndex + uint64(len(req.Entries))
+	if req.LeaderCommit > r.commitIndex {
+		r.commitIndex = req.LeaderCommit
+		if match < r.commitIndex {
+			r.commitIndex = match
+		}
+		r.applyCommitted()
+	}
+	return &peerpb.AppendReply{Term: r.term, Success: true, MatchIndex: match}
+}
+
+// applyCommitted applies every newly committed entry to the lease table, under r.mu
+func (r *raftNode) applyCommitted() {
+	for r.lastApplied < r.commitIndex {
+		r.lastApplied++
+		entry := r.log[r.lastApplied]
+		var outcome raftOutcome
+		if len(entry.Command) > 0 {
+			outcome.result, outcome.err = r.leases.apply(entry.Command)
+		}
+		if p, ok := r.waiting[entry.Index]; ok {
+			if p.term != entry.Term {
+				outcome = raftOutcome{err: ErrNotRaftLeader} // our proposal was replaced by another leader's
+			}
+			p.result <- outcome
+			delete(r.waiting, entry.Index)
+		}
+	}
+}
+
+// propose commits a command to the log, forwarding it to the leader if we are not it
+// and waiting until it has been applied. A failed attempt may still have been committed, so the command is
+// only retried for as long as the lease table remembers the proposals it applied
+func (r *raftNode) propose(ctx context.Context, command []byte) ([]byte, error) {
+	started := time.Now()
+	for {
+		result, err := r.proposeOnce(ctx, command)
+		if err == nil {
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if time.Since(started) > raftProposalRetention {
+			return nil, errors.Wrap(err, "raft proposal was not accepted")
+		}
+		r.Debugf("raft proposal from %s not yet accepted: %s", r.id, err.Error())
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-r.ctx.Done():
+			return nil, errors.New("raft member is shutting down")
+		case <-time.After(r.timeout / raftHeartbeatsPerTimeout):
+		}
+	}
+}
+
+func (r *raftNode) proposeOnce(ctx context.Context, command []byte) ([]byte, error) {
+	r.mu.Lock()
+	if r.state == raftLeader {
+		r.mu.Unlock()
+		return r.proposeLocal(ctx, command)
+	}
+	leader := r.leader
+	r.mu.Unlock()
+	if leader == "" {
+		return nil, errors.New("no raft leader elected")
+	}
+	reply, err := r.transport.propose(ctx, leader, &peerpb.ProposeRequest{Command: command})
+	if err != nil {
+		return nil, err
+	}
+	return reply.Result, nil
+}
+
+// proposeLocal appends the command to our own log, only valid while we are the leader
+func (r *raftNode) proposeLocal(ctx context.Context, command []byte) ([]byte, error) {
+	r.mu.Lock()
+	if r.state != raftLeader {
+		r.mu.Unlock()
+		return nil, ErrNotRaftLeader
+	}
+	last, _ := r.lastLog()
+	entry := &peerpb.LogEntry{Term: r.term, Index: last + 1, Command: command}
+	r.log = append(r.log, entry)
+	proposal := &raftProposal{term: r.term, result: make(chan raftOutcome, 1)}
+	r.waiting[entry.Index] = proposal
+	r.advanceCommit() // a single member cluster commits immediately
+	r.mu.Unlock()
+
+	r.replicate()
+
+	select {
+	case outcome := <-proposal.result:
+		return outcome.result, outcome.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// handlePropose accepts a command forwarded from another member
+func (r *raftNode) handlePropose(ctx context.Context, req *peerpb.ProposeRequest) (*peerpb.ProposeReply, error) {
+	result, err := r.proposeLocal(ctx, req.Command)
+	if err != nil {
+		return nil, err
+	}
+	return &peerpb.ProposeReply{Result: result}, nil
+}
@@ -0,0 +1,261 @@
+package whatnot
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testElectionTimeout = time.Millisecond * 150
+
+func TestRaftLeases(t *testing.T) {
+	t.Run("Cluster elects a single leader", clusterElectsSingleLeader)
+	t.Run("Lease is exclusive across the cluster", leaseIsExclusiveAcrossCluster)
+	t.Run("Expired lease can be taken by another member", expiredLeaseIsAvailable)
+	t.Run("Prefix lease conflicts with leases beneath it", prefixLeaseConflictsWithSubLease)
+	t.Run("Leader failure elects a new leader", leaderFailureElectsNewLeader)
+	t.Run("Single member cluster grants leases", singleMemberGrantsLeases)
+	t.Run("Renewed lease stays exclusive across the cluster", renewedLeaseStaysExclusive)
+	t.Run("Shared leases are held together across the cluster", sharedLeasesHeldTogether)
+	t.Run("Fencing tokens increase across the cluster", fencingTokensIncreaseAcrossCluster)
+	t.Run("Retried proposals are applied once", retriedProposalsAppliedOnce)
+}
+
+// createRaftTestCluster creates managers sharing an in-memory raft transport
+func createRaftTestCluster(t *testing.T, size int, opts ...ManagerOption) (transport *InMemoryRaftTransport, managers []*NameSpaceManager, namespaces []*Namespace) {
+	transport = NewInMemoryRaftTransport()
+	var members []string
+	for i := 0; i < size; i++ {
+		members = append(members, fmt.Sprintf("node%d", i))
+	}
+	for _, id := range members {
+		nsm, err := NewNamespaceManager(append([]ManagerOption{WithRaftConfig{
+			ID:              id,
+			Members:         members,
+			Transport:       transport,
+			ElectionTimeout: testElectionTimeout,
+		}}, opts...)...)
+		if !assert.Nil(t, err, "creating raft manager failed") {
+			t.FailNow()
+		}
+		ns := NewNamespace(testNameSpace)
+		if !assert.Nil(t, nsm.RegisterNamespace(ns), "registering namespace failed") {
+			t.FailNow()
+		}
+		managers = append(managers, nsm)
+		namespaces = append(namespaces, ns)
+	}
+	t.Cleanup(func() {
+		for _, nsm := range managers {
+			_ = nsm.Close()
+		}
+	})
+	awaitRaftLeader(t, managers)
+	return transport, managers, namespaces
+}
+
+// awaitRaftLeader waits for every member to agree on a leader, and returns it
+func awaitRaftLeader(t *testing.T, managers []*NameSpaceManager) (leader string) {
+	assert.Eventually(t, func() bool {
+		leader = managers[0].RaftLeader()
+		for _, nsm := range managers {
+			if nsm.RaftLeader() == "" || nsm.RaftLeader() != leader {
+				return false
+			}
+		}
+		return true
+	}, testElectionTimeout*20, testElectionTimeout/5, "members did not agree on a leader")
+	return leader
+}
+
+func clusterElectsSingleLeader(t *testing.T) {
+	_, managers, _ := createRaftTestCluster(t, 3)
+	leaders := 0
+	for _, nsm := range managers {
+		nsm.raft.mu.Lock()
+		if nsm.raft.state == raftLeader {
+			leaders++
+		}
+		nsm.raft.mu.Unlock()
+	}
+	assert.Equal(t, 1, leaders, "cluster did not have exactly one leader")
+}
+
+func leaseIsExclusiveAcrossCluster(t *testing.T) {
+	_, _, namespaces := createRaftTestCluster(t, 3)
+
+	elem0, _ := namespaces[0].FetchOrCreateAbsolutePath("/exclusive")
+	elem1, _ := namespaces[1].FetchOrCreateAbsolutePath("/exclusive")
+
+	held, release := elem0.LockWithLease(time.Second * 5)
+	if !assert.Nil(t, held.Err(), "first lease was not granted") {
+		return
+	}
+	defer release()
+
+	contested, _ := elem1.LockWithLease(testElectionTimeout * 3)
+	<-contested.Done()
+	assert.NotNil(t, contested.Err(), "second member was granted a lease already held elsewhere")
+	assert.True(t, elem0.reslock.isLocked(), "original holder lost its lock")
+}
+
+func expiredLeaseIsAvailable(t *testing.T) {
+	_, _, namespaces := createRaftTestCluster(t, 3)
+
+	elem0, _ := namespaces[0].FetchOrCreateAbsolutePath("/expiring")
+	elem2, _ := namespaces[2].FetchOrCreateAbsolutePath("/expiring")
+
+	leaseFor := time.Millisecond * 500
+	held, _ := elem0.LockWithLease(leaseFor)
+	if !assert.Nil(t, held.Err(), "first lease was not granted") {
+		return
+	}
+
+	start := time.Now()
+	next, release := elem2.LockWithLease(time.Second * 5)
+	defer release()
+	if !assert.Nil(t, next.Err(), "lease was not granted after the previous one expired") {
+		return
+	}
+	assert.True(t, time.Since(start) >= leaseFor/2, "lease was granted before the previous one expired")
+}
+
+func prefixLeaseConflictsWithSubLease(t *testing.T) {
+	_, _, namespaces := createRaftTestCluster(t, 3)
+
+	child, _ := namespaces[0].FetchOrCreateAbsolutePath("/prefix/child")
+	parent, _ := namespaces[1].FetchOrCreateAbsolutePath("/prefix")
+
+	held, release := child.LockWithLease(time.Second * 5)
+	if !assert.Nil(t, held.Err(), "child lease was not granted") {
+		return
+	}
+	defer release()
+
+	contested, _ := parent.LockPrefixWithLease(testElectionTimeout * 3)
+	<-contested.Done()
+	assert.NotNil(t, contested.Err(), "prefix lease was granted over a held child lease")
+}
+
+func leaderFailureElectsNewLeader(t *testing.T) {
+	transport, managers, namespaces := createRaftTestCluster(t, 3)
+	leader := managers[0].RaftLeader()
+	transport.Disconnect(leader)
+
+	var remaining []*NameSpaceManager
+	var elem *PathElement
+	for i, nsm := range managers {
+		if nsm.raft.id != leader {
+			remaining = append(remaining, nsm)
+			elem, _ = namespaces[i].FetchOrCreateAbsolutePath("/failover")
+		}
+	}
+	// followers keep reporting the old leader until their election timeout passes
+	assert.Eventually(t, func() bool {
+		newLeader := awaitRaftLeader(t, remaining)
+		return newLeader != "" && newLeader != leader
+	}, testElectionTimeout*20, testElectionTimeout/5, "disconnected leader was not replaced")
+
+	lease, release := elem.LockWithLease(time.Second * 5)
+	defer release()
+	assert.Nil(t, lease.Err(), "remaining quorum did not grant a lease")
+}
+
+func singleMemberGrantsLeases(t *testing.T) {
+	nsm, err := NewNamespaceManager(WithRaftConfig{})
+	if !assert.Nil(t, err, "creating single member raft manager failed") {
+		return
+	}
+	defer nsm.Close()
+	ns := NewNamespace(testNameSpace)
+	_ = nsm.RegisterNamespace(ns)
+	elem, _ := ns.FetchOrCreateAbsolutePath("/single")
+
+	lease, release := elem.LockWithLease(time.Second)
+	defer release()
+	assert.Nil(t, lease.Err(), "single member cluster did not grant a lease")
+}
+
+func renewedLeaseStaysExclusive(t *testing.T) {
+	_, _, namespaces := createRaftTestCluster(t, 3)
+
+	elem0, _ := namespaces[0].FetchOrCreateAbsolutePath("/renewed")
+	elem2, _ := namespaces[2].FetchOrCreateAbsolutePath("/renewed")
+
+	held, release := elem0.LockWithLease(time.Millisecond * 500)
+	if !assert.Nil(t, held.Err(), "first lease was not granted") {
+		return
+	}
+	defer release()
+	if !assert.Nil(t, held.Renew(time.Second*5), "cluster did not renew the lease") {
+		return
+	}
+
+	contested, _ := elem2.LockWithLease(testElectionTimeout * 8)
+	<-contested.Done()
+	assert.NotNil(t, contested.Err(), "lease was granted elsewhere after the renewed lease's original deadline")
+	assert.Nil(t, held.Err(), "renewed lease ended at its original deadline")
+}
+
+func sharedLeasesHeldTogether(t *testing.T) {
+	_, _, namespaces := createRaftTestCluster(t, 3)
+
+	elem0, _ := namespaces[0].FetchOrCreateAbsolutePath("/shared")
+	elem1, _ := namespaces[1].FetchOrCreateAbsolutePath("/shared")
+	elem2, _ := namespaces[2].FetchOrCreateAbsolutePath("/shared")
+
+	first, releaseFirst := elem0.LockSharedWithLease(time.Second * 5)
+	defer releaseFirst()
+	second, releaseSecond := elem1.LockSharedWithLease(time.Second * 5)
+	defer releaseSecond()
+	assert.Nil(t, first.Err(), "first shared lease was not granted")
+	assert.Nil(t, second.Err(), "second shared lease was not granted alongside the first")
+
+	exclusive, _ := elem2.LockWithLease(testElectionTimeout * 8)
+	<-exclusive.Done()
+	assert.NotNil(t, exclusive.Err(), "exclusive lease was granted while shared leases were held")
+}
+
+func fencingTokensIncreaseAcrossCluster(t *testing.T) {
+	_, _, namespaces := createRaftTestCluster(t, 3)
+
+	elem0, _ := namespaces[0].FetchOrCreateAbsolutePath("/fenced")
+	elem2, _ := namespaces[2].FetchOrCreateAbsolutePath("/fenced")
+
+	first, _ := elem0.LockWithLease(time.Millisecond * 500)
+	if !assert.Nil(t, first.Err(), "first lease was not granted") {
+		return
+	}
+	stale := first.FencingToken()
+
+	// the second member has never issued a token for the element, so only the cluster can have counted the first
+	next, release := elem2.LockWithLease(time.Second * 5)
+	defer release()
+	if !assert.Nil(t, next.Err(), "lease was not granted after the previous one expired") {
+		return
+	}
+	assert.True(t, next.FencingToken() > stale, "token %d from another member was not larger than %d", next.FencingToken(), stale)
+	<-first.Done()
+	assert.Equal(t, ErrStaleFencingToken, elem0.ValidateFencingToken(stale), "token of an expired lease was still valid")
+}
+
+func retriedProposalsAppliedOnce(t *testing.T) {
+	table := newRaftLeaseTable()
+	now := time.Now()
+	acquire := func(id uint64) raftResult {
+		cmd, _ := json.Marshal(raftCommand{ID: id, Op: raftAcquire, Namespace: "ns", Path: "/retried", Holder: "a", Lease: 1, Expires: now.Add(time.Minute).UnixNano(), Now: now.UnixNano()})
+		reply, err := table.apply(cmd)
+		assert.Nil(t, err)
+		var result raftResult
+		assert.Nil(t, json.Unmarshal(reply, &result))
+		return result
+	}
+
+	first := acquire(42)
+	assert.True(t, first.Granted)
+	assert.Equal(t, first, acquire(42), "retried proposal was applied again")
+	assert.Greater(t, acquire(43).Fence, first.Fence, "a new proposal was taken for a retry")
+}
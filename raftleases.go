@@ -0,0 +1,281 @@
+package whatnot
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	raftAcquire = "acquire"
+	raftRelease = "release"
+	raftRenew   = "renew"
+
+	// results are kept this long, so a proposal retried after its entry was committed is not applied twice
+	raftProposalRetention = time.Minute * 10
+)
+
+// raftCommand is a lease change committed to the raft log
+type raftCommand struct {
+	ID        uint64     `json:"id,omitempty"` // identifies the proposal, which may be committed more than once
+	Op        string     `json:"op"`
+	Namespace string     `json:"ns"`
+	Path      PathString `json:"path"`
+	Holder    string     `json:"holder,omitempty"`
+	Lease     uint64     `json:"lease"`
+	Recursive bool       `json:"recursive,omitempty"`
+	Shared    bool       `json:"shared,omitempty"`
+	Expires   int64      `json:"expires,omitempty"` // unix nanoseconds
+	Now       int64      `json:"now"`               // proposer's clock, so every member applies identically
+}
+
+type raftResult struct {
+	Granted bool   `json:"granted"`
+	Holder  string `json:"holder,omitempty"` // the member holding a conflicting lease
+	Fence   uint64 `json:"fence,omitempty"`  // fencing token of a granted lease, counted per namespace across the cluster
+}
+
+// raftLease is a lease held somewhere in the cluster
+type raftLease struct {
+	Holder    string
+	Lease     uint64
+	Recursive bool
+	Shared    bool
+	Expires   int64
+}
+
+// raftLeaseKey identifies a lease on one element, several shared leases may be held on the same element
+type raftLeaseKey struct {
+	Path  PathString
+	Lease uint64
+}
+
+// raftLeaseTable is the state machine every raft member applies the log to
+type raftLeaseTable struct {
+	mu        *sync.Mutex
+	leases    map[string]map[raftLeaseKey]raftLease // namespace to element path and lease to lease
+	fences    map[string]uint64                     // namespace to the last fencing token issued in it
+	proposals map[uint64]raftApplied                // recently applied proposals, by ID
+}
+
+// raftApplied is the result of a proposal already applied to the table
+type raftApplied struct {
+	at     int64 // the proposer's clock when it was applied
+	result []byte
+}
+
+func newRaftLeaseTable() *raftLeaseTable {
+	return &raftLeaseTable{
+		mu:        &sync.Mutex{},
+		leases:    make(map[string]map[raftLeaseKey]raftLease),
+		fences:    make(map[string]uint64),
+		proposals: make(map[uint64]raftApplied),
+	}
+}
+
+func (t *raftLeaseTable) apply(command []byte) ([]byte, error) {
+	var cmd raftCommand
+	if err := json.Unmarshal(command, &cmd); err != nil {
+		return nil, errors.Wrap(err, "undecodable raft command")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// a proposal retried after a timeout may have been committed the first time as well
+	if applied, ok := t.proposals[cmd.ID]; ok && cmd.ID != 0 {
+		return applied.result, nil
+	}
+	result, err := t.applyCommand(cmd)
+	if err != nil || cmd.ID == 0 {
+		return result, err
+	}
+	// forgotten by the clock of the commands themselves, so every member forgets the same proposals
+	for id, applied := range t.proposals {
+		if applied.at < cmd.Now-int64(raftProposalRetention) {
+			delete(t.proposals, id)
+		}
+	}
+	t.proposals[cmd.ID] = raftApplied{at: cmd.Now, result: result}
+	return result, nil
+}
+
+// applyCommand changes the table as the command says, the caller must hold mu
+func (t *raftLeaseTable) applyCommand(cmd raftCommand) ([]byte, error) {
+	held := t.leases[cmd.Namespace]
+	if held == nil {
+		held = make(map[raftLeaseKey]raftLease)
+		t.leases[cmd.Namespace] = held
+	}
+
+	var result raftResult
+	switch cmd.Op {
+	case raftAcquire:
+		result.Granted = true
+		for key, lease := range held {
+			if lease.Lease == cmd.Lease || lease.Expires <= cmd.Now {
+				continue // our own lease, or one that has lapsed
+			}
+			if lease.Shared && cmd.Shared {
+				continue // shared leases never keep each other out
+			}
+			if leasesOverlap(key.Path, lease.Recursive, cmd.Path, cmd.Recursive) {
+				result = raftResult{Granted: false, Holder: lease.Holder}
+				break
+			}
+		}
+		if result.Granted {
+			held[raftLeaseKey{cmd.Path, cmd.Lease}] = raftLease{Holder: cmd.Holder, Lease: cmd.Lease, Recursive: cmd.Recursive, Shared: cmd.Shared, Expires: cmd.Expires}
+			t.fences[cmd.Namespace]++
+			result.Fence = t.fences[cmd.Namespace]
+		}
+	case raftRenew:
+		// only a lease that is still held can be renewed, one that lapsed may already belong to another member
+		key := raftLeaseKey{cmd.Path, cmd.Lease}
+		if lease, ok := held[key]; ok && lease.Expires > cmd.Now {
+			lease.Expires = cmd.Expires
+			held[key] = lease
+			result.Granted = true
+		}
+	case raftRelease:
+		delete(held, raftLeaseKey{cmd.Path, cmd.Lease})
+		result.Granted = true
+	default:
+		return nil, errors.Errorf("unknown raft command %q", cmd.Op)
+	}
+	return json.Marshal(result)
+}
+
+// expired lists leases that lapsed before the given time
+func (t *raftLeaseTable) expired(before int64) (cmds []raftCommand) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for ns, held := range t.leases {
+		for key, lease := range held {
+			if lease.Expires < before {
+				cmds = append(cmds, raftCommand{Op: raftRelease, Namespace: ns, Path: key.Path, Lease: lease.Lease})
+			}
+		}
+	}
+	return cmds
+}
+
+// leasesOverlap reports if two leases cover any of the same elements
+func leasesOverlap(a PathString, aRecursive bool, b PathString, bRecursive bool) bool {
+	if a == b {
+		return true
+	}
+	if aRecursive && isSubPathOf(b, a) {
+		return true
+	}
+	return bRecursive && isSubPathOf(a, b)
+}
+
+// isSubPathOf reports if path lies beneath the prefix path
+func isSubPathOf(path PathString, prefix PathString) bool {
+	return strings.HasPrefix(string(path), string(prefix)+pathDelimeter)
+}
+
+// expireLeases has the leader release leases whose holders never released them, such as crashed members
+// one election timeout of grace is allowed for the holder to release its own lease first
+func (r *raftNode) expireLeases() {
+	for _, cmd := range r.leases.expired(time.Now().Add(-r.timeout).UnixNano()) {
+		cmd.Now = time.Now().UnixNano()
+		go r.submit(r.ctx, cmd)
+	}
+}
+
+func (r *raftNode) submit(ctx context.Context, cmd raftCommand) (result raftResult, err error) {
+	cmd.ID = randid.Uint64()
+	encoded, err := json.Marshal(cmd)
+	if err != nil {
+		return result, err
+	}
+	reply, err := r.propose(ctx, encoded)
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(reply, &result)
+	return result, err
+}
+
+// acquireLease blocks until the raft cluster grants us the lease, or the context finishes
+// it returns the fencing token the cluster issued with the lease
+func (r *raftNode) acquireLease(ctx context.Context, p *PathElement, lease uint64, recursive bool, shared bool) (fence uint64, err error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, errors.New("cluster leases require a deadline")
+	}
+	cmd := raftCommand{
+		Op:        raftAcquire,
+		Namespace: p.namespace.name,
+		Path:      p.AbsolutePath().ToPathString(),
+		Holder:    r.id,
+		Lease:     lease,
+		Recursive: recursive,
+		Shared:    shared,
+		Expires:   deadline.UnixNano(),
+	}
+	for {
+		cmd.Now = time.Now().UnixNano()
+		result, err := r.submit(ctx, cmd)
+		if err != nil {
+			return 0, err
+		}
+		if result.Granted {
+			return result.Fence, nil
+		}
+		r.Debugf("lease on %s is held by %s, waiting", cmd.Path, result.Holder)
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(r.timeout / raftHeartbeatsPerTimeout):
+		}
+	}
+}
+
+// renewLease moves the cluster's record of when the lease expires
+func (r *raftNode) renewLease(ctx context.Context, p *PathElement, lease uint64, deadline time.Time) error {
+	result, err := r.submit(ctx, raftCommand{
+		Op:        raftRenew,
+		Namespace: p.namespace.name,
+		Path:      p.AbsolutePath().ToPathString(),
+		Lease:     lease,
+		Expires:   deadline.UnixNano(),
+		Now:       time.Now().UnixNano(),
+	})
+	if err != nil {
+		return err
+	}
+	if !result.Granted {
+		return errors.New("the cluster no longer records this lease as held")
+	}
+	return nil
+}
+
+// releaseLease gives the lease back to the cluster, retrying for up to an election timeout
+func (r *raftNode) releaseLease(p *PathElement, lease uint64) {
+	ctx, cancel := context.WithTimeout(r.ctx, r.timeout*2)
+	defer cancel()
+	_, err := r.submit(ctx, raftCommand{
+		Op:        raftRelease,
+		Namespace: p.namespace.name,
+		Path:      p.AbsolutePath().ToPathString(),
+		Lease:     lease,
+		Now:       time.Now().UnixNano(),
+	})
+	if err != nil {
+		r.Warnf("could not release lease on %s, it will expire by itself: %s", p.AbsolutePath().ToPathString(), err.Error())
+	}
+}
+
+// clusterLeases returns the raft member that leases on this element must be granted by, if any
+func (p *PathElement) clusterLeases() *raftNode {
+	if p.namespace == nil || p.namespace.manager == nil {
+		return nil
+	}
+	return p.namespace.manager.raft
+}
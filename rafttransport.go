@@ -0,0 +1,208 @@
+package whatnot
+
+import (
+	"context"
+	"sync"
+
+	"github.com/databeast/whatnot/peerpb"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RaftTransport carries raft messages between the members of a cluster
+// use NewInMemoryRaftTransport for members within a single process, or leave
+// WithRaftConfig.Transport empty to use the PeerSync gRPC service
+type RaftTransport interface {
+	requestVote(ctx context.Context, target string, req *peerpb.VoteRequest) (*peerpb.VoteReply, error)
+	appendEntries(ctx context.Context, target string, req *peerpb.AppendRequest) (*peerpb.AppendReply, error)
+	propose(ctx context.Context, target string, req *peerpb.ProposeRequest) (*peerpb.ProposeReply, error)
+	register(id string, node *raftNode)
+	unregister(id string)
+}
+
+var errRaftMemberUnreachable = errors.New("raft member unreachable")
+
+// InMemoryRaftTransport connects raft members running within the same process
+// members can be disconnected and reconnected to simulate network partitions
+type InMemoryRaftTransport struct {
+	mu           *sync.Mutex
+	members      map[string]*raftNode
+	disconnected map[string]bool
+}
+
+// NewInMemoryRaftTransport creates a transport to be shared by every member of an in-process raft cluster
+func NewInMemoryRaftTransport() *InMemoryRaftTransport {
+	return &InMemoryRaftTransport{
+		mu:           &sync.Mutex{},
+		members:      make(map[string]*raftNode),
+		disconnected: make(map[string]bool),
+	}
+}
+
+// Disconnect cuts the member off from every other member
+func (t *InMemoryRaftTransport) Disconnect(id string) {
+	t.mu.Lock()
+	t.disconnected[id] = true
+	t.mu.Unlock()
+}
+
+// Reconnect restores a disconnected member's connectivity
+func (t *InMemoryRaftTransport) Reconnect(id string) {
+	t.mu.Lock()
+	delete(t.disconnected, id)
+	t.mu.Unlock()
+}
+
+func (t *InMemoryRaftTransport) register(id string, node *raftNode) {
+	t.mu.Lock()
+	t.members[id] = node
+	t.mu.Unlock()
+}
+
+func (t *InMemoryRaftTransport) unregister(id string) {
+	t.mu.Lock()
+	delete(t.members, id)
+	t.mu.Unlock()
+}
+
+// route finds the target member, as long as neither end is disconnected
+func (t *InMemoryRaftTransport) route(from string, target string) (*raftNode, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	node, ok := t.members[target]
+	if !ok || t.disconnected[target] || t.disconnected[from] {
+		return nil, errRaftMemberUnreachable
+	}
+	return node, nil
+}
+
+func (t *InMemoryRaftTransport) requestVote(ctx context.Context, target string, req *peerpb.VoteRequest) (*peerpb.VoteReply, error) {
+	node, err := t.route(req.Candidate, target)
+	if err != nil {
+		return nil, err
+	}
+	return node.handleRequestVote(req), nil
+}
+
+func (t *InMemoryRaftTransport) appendEntries(ctx context.Context, target string, req *peerpb.AppendRequest) (*peerpb.AppendReply, error) {
+	node, err := t.route(req.Leader, target)
+	if err != nil {
+		return nil, err
+	}
+	return node.handleAppendEntries(req), nil
+}
+
+func (t *InMemoryRaftTransport) propose(ctx context.Context, target string, req *peerpb.ProposeRequest) (*peerpb.ProposeReply, error) {
+	node, err := t.route("", target)
+	if err != nil {
+		return nil, err
+	}
+	return node.handlePropose(ctx, req)
+}
+
+// grpcRaftTransport sends raft messages over the PeerSync service, member IDs are their PeerSync addresses
+type grpcRaftTransport struct {
+	sync  *peerSync
+	mu    *sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+func newGrpcRaftTransport(s *peerSync) *grpcRaftTransport {
+	return &grpcRaftTransport{
+		sync:  s,
+		mu:    &sync.Mutex{},
+		conns: make(map[string]*grpc.ClientConn),
+	}
+}
+
+func (t *grpcRaftTransport) client(target string) (peerpb.PeerSyncClient, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	conn, ok := t.conns[target]
+	if !ok {
+		var err error
+		conn, err = grpc.Dial(target, t.sync.dialopts...)
+		if err != nil {
+			return nil, err
+		}
+		t.conns[target] = conn
+	}
+	return peerpb.NewPeerSyncClient(conn), nil
+}
+
+func (t *grpcRaftTransport) requestVote(ctx context.Context, target string, req *peerpb.VoteRequest) (*peerpb.VoteReply, error) {
+	c, err := t.client(target)
+	if err != nil {
+		return nil, err
+	}
+	return c.RequestVote(ctx, req)
+}
+
+func (t *grpcRaftTransport) appendEntries(ctx context.Context, target string, req *peerpb.AppendRequest) (*peerpb.AppendReply, error) {
+	c, err := t.client(target)
+	if err != nil {
+		return nil, err
+	}
+	return c.AppendEntries(ctx, req)
+}
+
+func (t *grpcRaftTransport) propose(ctx context.Context, target string, req *peerpb.ProposeRequest) (*peerpb.ProposeReply, error) {
+	c, err := t.client(target)
+	if err != nil {
+		return nil, err
+	}
+	return c.Propose(ctx, req)
+}
+
+// the PeerSync server routes raft requests to the manager's raft member itself
+func (t *grpcRaftTransport) register(id string, node *raftNode) {}
+
+func (t *grpcRaftTransport) unregister(id string) {
+	t.mu.Lock()
+	for target, conn := range t.conns {
+		_ = conn.Close()
+		delete(t.conns, target)
+	}
+	t.mu.Unlock()
+}
+
+// raftMember returns the manager's raft member, for serving raft requests from peers
+func (s *peerSync) raftMember() (*raftNode, error) {
+	if s.manager.raft == nil {
+		return nil, status.Error(codes.Unavailable, "raft is not enabled on this instance")
+	}
+	return s.manager.raft, nil
+}
+
+// RequestVote implements the PeerSync gRPC service
+func (s *peerSync) RequestVote(ctx context.Context, req *peerpb.VoteRequest) (*peerpb.VoteReply, error) {
+	r, err := s.raftMember()
+	if err != nil {
+		return nil, err
+	}
+	return r.handleRequestVote(req), nil
+}
+
+// AppendEntries implements the PeerSync gRPC service
+func (s *peerSync) AppendEntries(ctx context.Context, req *peerpb.AppendRequest) (*peerpb.AppendReply, error) {
+	r, err := s.raftMember()
+	if err != nil {
+		return nil, err
+	}
+	return r.handleAppendEntries(req), nil
+}
+
+// Propose implements the PeerSync gRPC service
+func (s *peerSync) Propose(ctx context.Context, req *peerpb.ProposeRequest) (*peerpb.ProposeReply, error) {
+	r, err := s.raftMember()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := r.handlePropose(ctx, req)
+	if err == ErrNotRaftLeader {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return reply, err
+}
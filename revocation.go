@@ -0,0 +1,62 @@
+package whatnot
+
+/*
+An operator can take back an element from a holder that is stuck or gone by revoking its lease. The holder's
+LeaseContext ends with ErrLeaseRevoked, so it can tell that it lost the element rather than gave it up, and
+watchers of the element are told who revoked it before it is released
+*/
+
+import (
+	"fmt"
+
+	"github.com/databeast/whatnot/access"
+	"github.com/pkg/errors"
+)
+
+// ErrLeaseRevoked is reported by the Err of a lease ended by Namespace.RevokeLease or PathElement.ForceUnlock
+var ErrLeaseRevoked = errors.New("lease was revoked")
+
+// RevokeLease ends the lease with the given ID on behalf of the given role, releasing every element attached to it
+// it returns ErrLeaseNotFound if there is no such lease, including one that has already ended
+func (ns *Namespace) RevokeLease(id LeaseID, by access.Role) error {
+	lease := ns.leases.fetch(id)
+	if lease == nil || lease.Err() != nil {
+		return ErrLeaseNotFound
+	}
+	lease.revoke(by)
+	return nil
+}
+
+// ForceUnlock revokes every lease holding this element on behalf of the given role, including prefix leases
+// on the elements above it, which release everything they hold. Locks taken without a lease cannot be revoked,
+// so it returns ErrLeaseNotFound if no lease holds the element
+func (p *PathElement) ForceUnlock(by access.Role) error {
+	if p.namespace == nil {
+		return ErrLeaseNotFound
+	}
+	revoked := 0
+	for _, holder := range p.LockHolders() {
+		// a lease that ended since it was listed has released the element already
+		if err := p.namespace.RevokeLease(holder.Lease, by); err == nil {
+			revoked++
+		}
+	}
+	if revoked == 0 {
+		return ErrLeaseNotFound
+	}
+	return nil
+}
+
+// revoke tells the watchers of every element the lease holds who revoked it, then ends the lease
+func (l *LeaseContext) revoke(by access.Role) {
+	l.mu.Lock()
+	l.revoker = by
+	l.mu.Unlock()
+
+	note := fmt.Sprintf("lease %s held by %q revoked", l.id, l.role.Name)
+	for _, a := range l.held() {
+		a.elem.selfnotify <- elementChange{id: randid.Uint64(), elem: a.elem, change: ChangeRevoked, actor: by, note: note}
+	}
+	l.Warnf("%s by %q", note, by.Name)
+	l.cancelWith(ErrLeaseRevoked)
+}
@@ -0,0 +1,106 @@
+package whatnot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/databeast/whatnot/access"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseRevocation(t *testing.T) {
+	t.Run("Revoked leases report ErrLeaseRevoked", revokedLeasesReportErr)
+	t.Run("Watchers are told who revoked a lease", revocationIsWatched)
+	t.Run("Forced unlocks release prefix leases above", forcedUnlocksReleasePrefixes)
+	t.Run("Elements held without a lease cannot be forced", unleasedLocksNotForced)
+}
+
+var operator = access.Role{Name: "operator"}
+
+func revokedLeasesReportErr(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/revocation/err")
+
+	lease, release := elem.LockWithLease(time.Minute)
+	defer release()
+	assert.Nil(t, gns.RevokeLease(lease.ID(), operator))
+	select {
+	case <-lease.Done():
+	case <-time.After(time.Second):
+		t.Error("revoked lease did not end")
+		return
+	}
+	assert.Equal(t, ErrLeaseRevoked, lease.Err())
+	assert.NotEqual(t, context.Canceled, lease.Err(), "revoked lease looked as if it was released")
+	assert.Eventually(t, func() bool { return !elem.reslock.isLocked() }, time.Second, time.Millisecond*10, "revoked lease kept its lock")
+}
+
+func revocationIsWatched(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/revocation/watched")
+	ctx := access.WithRole(context.Background(), access.Role{Name: "worker"})
+	lease, release := elem.ContextLockWithLease(ctx, time.Minute)
+	defer release()
+
+	sub := elem.SubscribeToEvents(false)
+	defer elem.UnSubscribeFromEvents(sub)
+	// subscribers not ready to receive an event are dropped, so the loop below must already be waiting
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		gns.RevokeLease(lease.ID(), operator)
+	}()
+
+	// the lock itself may still be on its way to watchers, so look for the revocation among what follows it
+	var revoked WatchEvent
+	timeout := time.After(time.Second * 2)
+	for revoked.Change != ChangeRevoked {
+		select {
+		case revoked = <-sub.Events():
+		case <-timeout:
+			t.Error("revocation was not sent to watchers")
+			return
+		}
+	}
+	assert.Equal(t, "operator", revoked.Actor.Name, "revocation did not name who revoked the lease")
+	assert.Contains(t, revoked.Note, lease.ID().String())
+	assert.Contains(t, revoked.Note, "worker")
+}
+
+func forcedUnlocksReleasePrefixes(t *testing.T) {
+	gns := createTestNamespace(t)
+	parent, _ := gns.FetchOrCreateAbsolutePath("/revocation/prefix")
+	child, _ := gns.FetchOrCreateAbsolutePath("/revocation/prefix/child")
+	other, _ := gns.FetchOrCreateAbsolutePath("/revocation/other")
+
+	lease, release := gns.GrantLease(time.Minute)
+	defer release()
+	assert.Nil(t, lease.AttachPrefix(parent))
+	assert.Nil(t, lease.Attach(other))
+	assert.Equal(t, ErrLockUnavailable, child.TryLock())
+
+	assert.Nil(t, child.ForceUnlock(operator))
+	select {
+	case <-lease.Done():
+	case <-time.After(time.Second):
+		t.Error("prefix lease above the element was not revoked")
+		return
+	}
+	assert.Equal(t, ErrLeaseRevoked, lease.Err())
+	assert.Eventually(t, func() bool { return len(lease.Elements()) == 0 }, time.Second, time.Millisecond*10, "revoked lease kept its elements")
+	assert.Nil(t, child.TryLock(), "prefix lock was kept")
+	child.UnLock()
+	assert.Nil(t, other.TryLock(), "the rest of the revoked lease was kept")
+	other.UnLock()
+	assert.Equal(t, ErrLeaseNotFound, child.ForceUnlock(operator), "element was forced with no lease holding it")
+}
+
+func unleasedLocksNotForced(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/revocation/unleased")
+	elem.Lock()
+	defer elem.UnLock()
+
+	assert.Equal(t, ErrLeaseNotFound, elem.ForceUnlock(operator))
+	assert.True(t, elem.reslock.isLocked(), "lock taken without a lease was released")
+}
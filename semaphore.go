@@ -45,26 +45,18 @@ func (p *SemaphorePool) returnclaim(claim *SemaphoreClaim) (err error) {
 	}()
 
 	p.mu.Lock()
-
-	if p.usedslots+claim.slots > p.maxslots {
-		p.usedslots = p.maxslots
-		p.mu.Unlock()
-		return nil
-	}
-
-	if p.usedslots-claim.slots < 0 {
+	claim.returned = true
+	p.usedslots -= claim.slots
+	if p.usedslots < 0 {
 		p.usedslots = 0
-		p.mu.Unlock()
-		return nil
 	}
-
-	p.usedslots -= claim.slots
-	id := randid.Uint64()
-	p.onElement.parentnotify <- elementChange{
-		id:     id,
-		elem:   p.onElement,
-		change: ChangeReleased,
-		actor:  access.Role{},
+	if p.onElement.parentnotify != nil { // pools on detached elements have nobody to notify
+		p.onElement.parentnotify <- elementChange{
+			id:     randid.Uint64(),
+			elem:   p.onElement,
+			change: ChangeReleased,
+			actor:  access.Role{},
+		}
 	}
 
 	p.waiting.Broadcast <- WatchEvent{
@@ -84,22 +76,17 @@ func (p *SemaphorePool) Claim(ctx context.Context, slots int64) (claim *Semaphor
 	if slots > p.maxslots {
 		return nil, errors.New("cannot claim more slots than available in semaphore pool")
 	}
-	p.mu.RLock()
-	if p.usedslots+1 <= p.maxslots { // free slot, lets go
-		p.mu.RUnlock()
-
-		claim = &SemaphoreClaim{
+	p.mu.Lock()
+	if p.usedslots+slots <= p.maxslots { // free slots, lets go
+		p.usedslots += slots
+		p.mu.Unlock()
+		return &SemaphoreClaim{
 			fromPool: p,
-			slots:    1,
+			slots:    slots,
 			returned: false,
-		}
-
-		p.mu.Lock()
-		p.usedslots++
-		p.mu.Unlock()
-		return claim, nil
+		}, nil
 	}
-	p.mu.RUnlock()
+	p.mu.Unlock()
 	// and now we play the waiting game..
 	return p.waitForSlot(ctx, slots)
 
@@ -153,6 +140,11 @@ type SemaphorePoolOpts struct {
 	Prefix   bool
 }
 
+// SemaphorePool returns the semaphore pool attached to this path element, or nil if it has none
+func (p *PathElement) SemaphorePool() *SemaphorePool {
+	return p.semaphores
+}
+
 // CreateSemaphorePool instantiates a semaphore pool on this path element.
 // prefix will attach the pool to all child elements
 // purge will remove any existing semaphore pool, including from all children if prefix is true
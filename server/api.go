@@ -0,0 +1,439 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/databeast/whatnot"
+	"github.com/databeast/whatnot/access"
+	"github.com/pkg/errors"
+)
+
+/*
+The HTTP API, every route beneath /v1/ that addresses an element is followed by the namespace then the path within it
+eg /v1/keys/orders/customer/42 is the element /customer/42 of the namespace orders
+*/
+
+const (
+	routeNamespaces = "/v1/namespaces/"
+	routeKeys       = "/v1/keys/"
+	routeLocks      = "/v1/locks/"
+	routeLeases     = "/v1/leases/"
+	routeSemaphores = "/v1/semaphores/"
+	routeClaims     = "/v1/claims/"
+	routeWatch      = "/v1/watch/"
+
+	// the path of a namespace's root element, which has no value and cannot be changed
+	rootPath whatnot.PathString = "/"
+)
+
+func (s *Server) routes(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", s.handleHealth)
+	mux.HandleFunc("/v1/namespaces", s.handleNamespaces)
+	mux.HandleFunc(routeNamespaces, s.handleNamespace)
+	mux.HandleFunc(routeKeys, s.handleKey)
+	mux.HandleFunc(routeLocks, s.handleLock)
+	mux.HandleFunc(routeLeases, s.handleLease)
+	mux.HandleFunc(routeSemaphores, s.handleSemaphore)
+	mux.HandleFunc(routeClaims, s.handleClaim)
+	mux.HandleFunc(routeWatch, s.handleWatch)
+}
+
+// apiError is the body of every unsuccessful response
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiError{Error: err.Error()})
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+}
+
+// decodeBody reads a JSON request body, an empty body leaves the target untouched
+func decodeBody(r *http.Request, target interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(target)
+	if err == io.EOF {
+		return nil
+	}
+	return errors.Wrap(err, "invalid request body")
+}
+
+// elementTarget splits the namespace and element path from a request addressing an element
+// a request addressing only the namespace is for its root, rootPath
+func elementTarget(route string, r *http.Request) (namespace string, path whatnot.PathString, err error) {
+	rest := strings.TrimPrefix(r.URL.Path, route)
+	split := strings.Index(rest, "/")
+	if split < 0 {
+		split = len(rest)
+	}
+	if split == 0 {
+		return "", "", errors.Errorf("request must address an element as %s<namespace>/<path>", route)
+	}
+	return rest[:split], cleanPath(rest[split:]), nil
+}
+
+// cleanPath is the absolute path of an element however its slashes were given, rootPath if none remain
+func cleanPath(path string) whatnot.PathString {
+	return whatnot.PathString("/" + strings.Trim(path, "/"))
+}
+
+// checkNamespaceName refuses names that could not be addressed by the API
+func checkNamespaceName(name string) error {
+	if name == "" || strings.Contains(name, "/") {
+		return errors.New("namespace names cannot be empty or contain '/'")
+	}
+	return nil
+}
+
+// fetchElement resolves the element a request addresses, creating it if requested
+// requests addressing the root of a namespace are refused, use fetchElementOrRoot where they are meaningful
+func (s *Server) fetchElement(route string, r *http.Request, create bool) (elem *whatnot.PathElement, status int, err error) {
+	name, path, err := elementTarget(route, r)
+	if err == nil && path == rootPath {
+		err = errors.Errorf("request must address an element as %s<namespace>/<path>", route)
+	}
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	return s.element(name, path, create)
+}
+
+// fetchElementOrRoot resolves the element a request addresses, or the root of its namespace
+func (s *Server) fetchElementOrRoot(route string, r *http.Request) (elem *whatnot.PathElement, status int, err error) {
+	name, path, err := elementTarget(route, r)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	return s.elementOrRoot(name, path)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if !whatnot.Healthy() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]bool{"healthy": false})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"healthy": true})
+}
+
+type namespaceList struct {
+	Namespaces []string `json:"namespaces"`
+}
+
+// handleNamespaces lists every registered namespace
+func (s *Server) handleNamespaces(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	names := s.manager.Namespaces()
+	if names == nil {
+		names = []string{}
+	}
+	writeJSON(w, http.StatusOK, namespaceList{Namespaces: names})
+}
+
+type namespaceInfo struct {
+	Name string `json:"name"`
+}
+
+// handleNamespace checks for or registers a single namespace
+func (s *Server) handleNamespace(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, routeNamespaces), "/")
+	if err := checkNamespaceName(name); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if _, err := s.manager.FetchNamespace(name); err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, namespaceInfo{Name: name})
+	case http.MethodPut:
+		if err := s.manager.RegisterNamespace(whatnot.NewNamespace(name)); err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, namespaceInfo{Name: name})
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut)
+	}
+}
+
+// keyValue describes the value of an element, and the elements beneath it
+type keyValue struct {
+	Namespace string          `json:"namespace"`
+	Path      string          `json:"path"`
+	Value     interface{}     `json:"value"`
+	Version   whatnot.Version `json:"version"`
+	// Children are the names of the elements directly beneath this one
+	Children []string `json:"children"`
+	// Paths are every path beneath this one that has no children of its own, only listed for recursive requests
+	Paths []string `json:"paths,omitempty"`
+}
+
+type setValueRequest struct {
+	Value interface{} `json:"value"`
+}
+
+// handleKey reads, writes or deletes an element
+func (s *Server) handleKey(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		elem, status, err := s.fetchElementOrRoot(routeKeys, r)
+		if err != nil {
+			writeError(w, status, err)
+			return
+		}
+		described := describeValue(r, elem)
+		if r.URL.Query().Get("recursive") == "true" {
+			if described.Paths, err = describePaths(elem); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, described)
+	case http.MethodPut:
+		var req setValueRequest
+		if err := decodeBody(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		elem, status, err := s.fetchElement(routeKeys, r, true)
+		if err != nil {
+			writeError(w, status, err)
+			return
+		}
+		elem.SetValue(whatnot.ElementValue{Val: req.Value}, whatnot.ChangeEdited, access.Role{})
+		writeJSON(w, http.StatusOK, describeValue(r, elem))
+	case http.MethodDelete:
+		elem, status, err := s.fetchElement(routeKeys, r, false)
+		if err != nil {
+			writeError(w, status, err)
+			return
+		}
+		if err = elem.Delete(); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+func describeValue(r *http.Request, elem *whatnot.PathElement) keyValue {
+	name, path, _ := elementTarget(routeKeys, r)
+	return describeElement(name, path, elem)
+}
+
+// describeElement is the value and children of an element, as addressed by the client
+func describeElement(name string, path whatnot.PathString, elem *whatnot.PathElement) keyValue {
+	current := elem.GetVersionedValue()
+	described := keyValue{
+		Namespace: name,
+		Path:      string(path),
+		Value:     current.Value.Val,
+		Version:   current.Version,
+		Children:  []string{},
+	}
+	for _, c := range elem.Children() {
+		described.Children = append(described.Children, string(c.SubPath()))
+	}
+	return described
+}
+
+// describePaths lists every path beneath the element that has no children of its own, in order
+func describePaths(elem *whatnot.PathElement) (paths []string, err error) {
+	subpaths, err := elem.FetchAllSubPaths()
+	if err != nil {
+		return nil, err
+	}
+	base := strings.TrimSuffix(string(elem.AbsolutePath().ToPathString()), "/")
+	if elem.Parent() == nil {
+		base = "" // the namespace root
+	}
+	for _, sub := range subpaths {
+		paths = append(paths, base+string(whatnot.AbsolutePath(sub).ToPathString()))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+type lockRequest struct {
+	// TTL is how long the lease lasts unless released, such as "30s"
+	TTL Duration `json:"ttl"`
+	// Prefix extends the lease to every element beneath this one, as LockPrefixWithLease
+	Prefix bool `json:"prefix"`
+}
+
+// handleLock acquires a lease on an element, or on it and everything beneath it, waiting until it is available
+func (s *Server) handleLock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	var req lockRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.TTL <= 0 {
+		writeError(w, http.StatusBadRequest, errors.New("a lease requires a positive ttl"))
+		return
+	}
+	elem, status, err := s.fetchElement(routeLocks, r, true)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	name, _, _ := elementTarget(routeLocks, r)
+	held, status, err := s.acquireLease(r.Context(), name, elem, time.Duration(req.TTL), req.Prefix)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	writeJSON(w, status, held.describe())
+}
+
+type renewRequest struct {
+	// TTL is how long the lease is renewed for, defaulting to the ttl it was acquired with
+	TTL Duration `json:"ttl"`
+}
+
+// handleLease describes, renews or releases a lease acquired through the API
+func (s *Server) handleLease(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, routeLeases), "/")
+	switch r.Method {
+	case http.MethodGet:
+		held, ok := s.leases.fetch(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, errors.New("no such lease, it may have expired"))
+			return
+		}
+		writeJSON(w, http.StatusOK, held.describe())
+	case http.MethodPut:
+		var req renewRequest
+		if err := decodeBody(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		held, err := s.leases.renew(id, time.Duration(req.TTL))
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, held.describe())
+	case http.MethodDelete:
+		if !s.leases.release(id) {
+			writeError(w, http.StatusNotFound, errors.New("no such lease, it may have expired"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+type createPoolRequest struct {
+	// Size is the total number of slots in the pool
+	Size int64 `json:"size"`
+	// Prefix shares the pool with every element beneath this one
+	Prefix bool `json:"prefix"`
+	// Replace discards any existing pool on the element
+	Replace bool `json:"replace"`
+}
+
+type claimRequest struct {
+	// Slots are how many slots of the pool to claim, defaulting to one
+	Slots int64 `json:"slots"`
+	// Wait is how long to wait for slots to become free, by default as long as the request remains open
+	Wait Duration `json:"wait"`
+}
+
+// handleSemaphore creates a semaphore pool on an element, or claims slots from its pool
+func (s *Server) handleSemaphore(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut:
+		var req createPoolRequest
+		if err := decodeBody(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.Size <= 0 {
+			writeError(w, http.StatusBadRequest, errors.New("a semaphore pool requires a positive size"))
+			return
+		}
+		elem, status, err := s.fetchElement(routeSemaphores, r, true)
+		if err != nil {
+			writeError(w, status, err)
+			return
+		}
+		if err = elem.CreateSemaphorePool(req.Prefix, req.Replace, whatnot.SemaphorePoolOpts{PoolSize: req.Size, Prefix: req.Prefix}); err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, req)
+	case http.MethodPost:
+		req := claimRequest{Slots: 1}
+		if err := decodeBody(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		elem, status, err := s.fetchElement(routeSemaphores, r, false)
+		if err != nil {
+			writeError(w, status, err)
+			return
+		}
+		ctx := r.Context()
+		if req.Wait > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(req.Wait))
+			defer cancel()
+		}
+		name, _, _ := elementTarget(routeSemaphores, r)
+		held, status, err := s.claimSlots(ctx, name, elem, req.Slots)
+		if err != nil {
+			writeError(w, status, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, held)
+	default:
+		methodNotAllowed(w, http.MethodPut, http.MethodPost)
+	}
+}
+
+// handleClaim returns a semaphore claim made through the API to its pool
+func (s *Server) handleClaim(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w, http.MethodDelete)
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, routeClaims), "/")
+	ok, err := s.claims.give(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("no such semaphore claim"))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultListen          = ":8420"
+	defaultShutdownTimeout = time.Second * 10
+	defaultWatchHeartbeat  = time.Second * 15
+	defaultMaxLease        = time.Hour * 24
+	defaultGRPCListen      = ":8421"
+)
+
+// Config is everything needed to run a standalone whatnot server
+// it can be read from a JSON file, with command line flags overriding the values it sets
+type Config struct {
+	// Listen is the address the HTTP API is served on
+	Listen string `json:"listen"`
+	// GRPCListen is the address the gRPC API is served on, it is disabled if empty
+	GRPCListen string `json:"grpc_listen"`
+	// Namespaces are registered when the server starts
+	Namespaces []string `json:"namespaces"`
+	// CreateNamespaces registers namespaces the first time a request refers to them
+	CreateNamespaces bool `json:"create_namespaces"`
+	// ShutdownTimeout is how long open requests are given to finish when the server stops
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+	// WatchHeartbeat is how often idle watches are sent a heartbeat, unless the client asks otherwise
+	WatchHeartbeat Duration `json:"watch_heartbeat"`
+	// MaxLeaseLifetime is the longest a lease can be held for, however often it is renewed
+	MaxLeaseLifetime Duration `json:"max_lease_lifetime"`
+	// Cluster configures synchronization with other whatnot servers, it is disabled if no listen address is set
+	Cluster ClusterConfig `json:"cluster"`
+}
+
+// ClusterConfig configures PeerSync replication between whatnot servers
+type ClusterConfig struct {
+	// Listen is the address the PeerSync service is served on
+	Listen string `json:"listen"`
+	// NodeName identifies this server to its peers, defaulting to the listen address
+	NodeName string `json:"node_name"`
+	// Peers are the PeerSync addresses of the other servers in the cluster
+	Peers []string `json:"peers"`
+}
+
+// Duration is a time.Duration that is written as a string such as "30s" in JSON configuration
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.Wrap(err, "durations must be strings such as \"30s\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// DefaultConfig is the configuration used for anything not set by a file or flag
+func DefaultConfig() Config {
+	return Config{
+		Listen:           defaultListen,
+		ShutdownTimeout:  Duration(defaultShutdownTimeout),
+		WatchHeartbeat:   Duration(defaultWatchHeartbeat),
+		MaxLeaseLifetime: Duration(defaultMaxLease),
+		GRPCListen:       defaultGRPCListen,
+	}
+}
+
+// validate refuses settings the server cannot run with
+func (c Config) validate() error {
+	if time.Duration(c.WatchHeartbeat) < minWatchHeartbeat {
+		return errors.Errorf("watch heartbeat must be at least %s", minWatchHeartbeat)
+	}
+	if c.ShutdownTimeout < 0 {
+		return errors.New("shutdown timeout cannot be negative")
+	}
+	if c.MaxLeaseLifetime < 0 {
+		return errors.New("max lease lifetime cannot be negative")
+	}
+	return nil
+}
+
+// LoadConfig reads a JSON configuration file over the default configuration
+func LoadConfig(path string) (cfg Config, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return cfg, errors.Wrap(err, "opening configuration file")
+	}
+	defer f.Close()
+	return decodeConfig(f)
+}
+
+func decodeConfig(r io.Reader) (cfg Config, err error) {
+	cfg = DefaultConfig()
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&cfg); err != nil {
+		return cfg, errors.Wrap(err, "decoding configuration")
+	}
+	return cfg, nil
+}
+
+// ParseFlags builds the configuration from command line arguments
+// a configuration file given with -config is read first, then any other flags override it
+func ParseFlags(name string, args []string) (cfg Config, err error) {
+	// the first pass only finds the configuration file, the flags are bound to a throwaway config
+	var path string
+	first := flag.NewFlagSet(name, flag.ContinueOnError)
+	first.SetOutput(io.Discard)
+	first.StringVar(&path, "config", "", "")
+	(&Config{}).bindFlags(first)
+	_ = first.Parse(args) // any errors are reported by the second pass
+
+	cfg = DefaultConfig()
+	if path != "" {
+		if cfg, err = LoadConfig(path); err != nil {
+			return cfg, err
+		}
+	}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&path, "config", path, "JSON configuration file, overridden by any other flags")
+	cfg.bindFlags(fs)
+	err = fs.Parse(args)
+	return cfg, err
+}
+
+// bindFlags registers a flag for every setting, defaulting to the setting's current value
+func (c *Config) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Listen, "listen", c.Listen, "address to serve the HTTP API on")
+	fs.StringVar(&c.GRPCListen, "grpc-listen", c.GRPCListen, "address to serve the gRPC API on, empty to disable it")
+	fs.Var((*listFlag)(&c.Namespaces), "namespaces", "comma separated namespaces to register at startup")
+	fs.BoolVar(&c.CreateNamespaces, "create-namespaces", c.CreateNamespaces, "register namespaces the first time they are used")
+	fs.Var((*durationFlag)(&c.ShutdownTimeout), "shutdown-timeout", "time allowed for open requests to finish on shutdown")
+	fs.Var((*durationFlag)(&c.WatchHeartbeat), "watch-heartbeat", "how often idle watches are sent a heartbeat")
+	fs.Var((*durationFlag)(&c.MaxLeaseLifetime), "max-lease-lifetime", "longest a lease can be held for, however often it is renewed")
+	fs.StringVar(&c.Cluster.Listen, "cluster-listen", c.Cluster.Listen, "address to serve peer synchronization on, enabling clustering")
+	fs.StringVar(&c.Cluster.NodeName, "node-name", c.Cluster.NodeName, "name identifying this server to its peers, defaults to -cluster-listen")
+	fs.Var((*listFlag)(&c.Cluster.Peers), "peers", "comma separated peer synchronization addresses of other servers")
+}
+
+// listFlag is a comma separated list of strings
+type listFlag []string
+
+func (l *listFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = nil
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+type durationFlag Duration
+
+func (d *durationFlag) String() string {
+	if d == nil {
+		return ""
+	}
+	return time.Duration(*d).String()
+}
+
+func (d *durationFlag) Set(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = durationFlag(parsed)
+	return nil
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerConfig(t *testing.T) {
+	t.Run("Defaults apply without configuration", defaultsApply)
+	t.Run("Configuration file is read", configFileRead)
+	t.Run("Flags override the configuration file", flagsOverrideFile)
+	t.Run("Unknown configuration is refused", unknownConfigRefused)
+	t.Run("Unusable configuration is refused", unusableConfigRefused)
+}
+
+func writeConfigFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "whatnot.json")
+	if !assert.Nil(t, os.WriteFile(path, []byte(contents), 0600), "writing configuration failed") {
+		t.FailNow()
+	}
+	return path
+}
+
+func defaultsApply(t *testing.T) {
+	cfg, err := ParseFlags("whatnot-server", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultConfig(), cfg, "defaults differ")
+}
+
+func configFileRead(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"listen": ":9000",
+		"namespaces": ["orders", "jobs"],
+		"shutdown_timeout": "3s",
+		"grpc_listen": ":9001",
+		"max_lease_lifetime": "1h",
+		"cluster": {"listen": ":7000", "peers": ["b:7000", "c:7000"]}
+	}`)
+	cfg, err := LoadConfig(path)
+	if !assert.Nil(t, err, "loading configuration failed") {
+		return
+	}
+	assert.Equal(t, ":9000", cfg.Listen)
+	assert.Equal(t, []string{"orders", "jobs"}, cfg.Namespaces)
+	assert.Equal(t, Duration(time.Second*3), cfg.ShutdownTimeout)
+	assert.Equal(t, ":9001", cfg.GRPCListen)
+	assert.Equal(t, Duration(time.Hour), cfg.MaxLeaseLifetime)
+	assert.Equal(t, ":7000", cfg.Cluster.Listen)
+	assert.Equal(t, []string{"b:7000", "c:7000"}, cfg.Cluster.Peers)
+}
+
+func flagsOverrideFile(t *testing.T) {
+	path := writeConfigFile(t, `{"listen": ":9000", "namespaces": ["orders"], "create_namespaces": true}`)
+	cfg, err := ParseFlags("whatnot-server", []string{"-listen", ":9100", "-config", path, "-peers", "b:7000, c:7000"})
+	if !assert.Nil(t, err, "parsing flags failed") {
+		return
+	}
+	assert.Equal(t, ":9100", cfg.Listen, "flag did not override the file")
+	assert.Equal(t, []string{"orders"}, cfg.Namespaces, "file setting was lost")
+	assert.True(t, cfg.CreateNamespaces, "file setting was lost")
+	assert.Equal(t, []string{"b:7000", "c:7000"}, cfg.Cluster.Peers)
+}
+
+func unknownConfigRefused(t *testing.T) {
+	_, err := decodeConfig(strings.NewReader(`{"listne": ":9000"}`))
+	assert.NotNil(t, err, "misspelled setting was accepted")
+}
+
+func unusableConfigRefused(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WatchHeartbeat = 0
+	_, err := New(cfg)
+	assert.NotNil(t, err, "server was created without a watch heartbeat")
+
+	cfg = DefaultConfig()
+	cfg.MaxLeaseLifetime = Duration(-time.Minute)
+	_, err = New(cfg)
+	assert.NotNil(t, err, "server was created with a negative lease lifetime")
+}
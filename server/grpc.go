@@ -0,0 +1,345 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/databeast/whatnot"
+	"github.com/databeast/whatnot/access"
+	"github.com/databeast/whatnot/apipb"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+/*
+The gRPC API offers the same operations as the HTTP API, for the Go client package and any other language
+with generated stubs. Both APIs share the server's lease and claim tables, so a lease acquired through one
+can be renewed or released through the other.
+*/
+
+// ServeGRPC serves the gRPC API on an existing listener, until Shutdown is called
+func (s *Server) ServeGRPC(lis net.Listener) error {
+	err := s.grpc.Serve(lis)
+	if err == grpc.ErrServerStopped {
+		return nil
+	}
+	return err
+}
+
+// stopGRPC lets unary calls finish while the context allows, streams have already been ended by cancelling s.ctx
+func (s *Server) stopGRPC(ctx context.Context) {
+	stopped := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.grpc.Stop()
+	}
+}
+
+// grpcAPI implements the generated Whatnot service over the server
+type grpcAPI struct {
+	apipb.UnimplementedWhatnotServer
+	s *Server
+}
+
+// grpcError translates a failure described by an HTTP status into a gRPC status error
+func grpcError(httpStatus int, err error) error {
+	code := codes.Unknown
+	switch httpStatus {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.FailedPrecondition
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	case http.StatusInternalServerError:
+		code = codes.Internal
+	}
+	return status.Error(code, err.Error())
+}
+
+func (g *grpcAPI) ListNamespaces(context.Context, *apipb.ListNamespacesRequest) (*apipb.ListNamespacesReply, error) {
+	return &apipb.ListNamespacesReply{Namespaces: g.s.manager.Namespaces()}, nil
+}
+
+func (g *grpcAPI) RegisterNamespace(_ context.Context, req *apipb.NamespaceRequest) (*apipb.NamespaceReply, error) {
+	if err := checkNamespaceName(req.Name); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := g.s.manager.RegisterNamespace(whatnot.NewNamespace(req.Name)); err != nil {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+	return &apipb.NamespaceReply{Name: req.Name}, nil
+}
+
+func (g *grpcAPI) FetchNamespace(_ context.Context, req *apipb.NamespaceRequest) (*apipb.NamespaceReply, error) {
+	if _, err := g.s.manager.FetchNamespace(req.Name); err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &apipb.NamespaceReply{Name: req.Name}, nil
+}
+
+func (g *grpcAPI) FetchPath(_ context.Context, req *apipb.PathRequest) (*apipb.Element, error) {
+	path := cleanPath(req.Path)
+	var elem *whatnot.PathElement
+	var code int
+	var err error
+	if req.Create && path != rootPath {
+		elem, code, err = g.s.element(req.Namespace, path, true)
+	} else {
+		elem, code, err = g.s.elementOrRoot(req.Namespace, path)
+	}
+	if err != nil {
+		return nil, grpcError(code, err)
+	}
+	described := describeElement(req.Namespace, path, elem)
+	if req.Recursive {
+		if described.Paths, err = describePaths(elem); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	return encodeElement(described)
+}
+
+func (g *grpcAPI) SetValue(_ context.Context, req *apipb.SetValueRequest) (*apipb.Element, error) {
+	path := cleanPath(req.Path)
+	elem, code, err := g.s.element(req.Namespace, path, true)
+	if err != nil {
+		return nil, grpcError(code, err)
+	}
+	elem.SetValue(whatnot.ElementValue{Val: req.Value.AsInterface()}, whatnot.ChangeEdited, access.Role{})
+	return encodeElement(describeElement(req.Namespace, path, elem))
+}
+
+func (g *grpcAPI) DeletePath(_ context.Context, req *apipb.PathRequest) (*apipb.DeleteReply, error) {
+	elem, code, err := g.s.element(req.Namespace, cleanPath(req.Path), false)
+	if err != nil {
+		return nil, grpcError(code, err)
+	}
+	if err = elem.Delete(); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &apipb.DeleteReply{}, nil
+}
+
+// Lock waits for the lease for as long as the call remains open
+// a lease granted just as the caller gives up is released rather than left to expire
+func (g *grpcAPI) Lock(ctx context.Context, req *apipb.LockRequest) (*apipb.Lease, error) {
+	elem, code, err := g.s.element(req.Namespace, cleanPath(req.Path), true)
+	if err != nil {
+		return nil, grpcError(code, err)
+	}
+	held, code, err := g.s.acquireLease(ctx, req.Namespace, elem, req.Ttl.AsDuration(), req.Prefix)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		return nil, grpcError(code, err)
+	}
+	return encodeLease(held.describe()), nil
+}
+
+// KeepAlive renews each lease it is sent, until the client closes the stream or the server shuts down
+func (g *grpcAPI) KeepAlive(stream apipb.Whatnot_KeepAliveServer) error {
+	ctx, cancel := g.s.streamContext(stream.Context())
+	defer cancel()
+	requests := make(chan *apipb.KeepAliveRequest)
+	failed := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				failed <- err
+				return
+			}
+			select {
+			case requests <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case err := <-failed:
+			if err == io.EOF || status.Code(err) == codes.Canceled {
+				return nil // the client has finished renewing
+			}
+			return err
+		case req := <-requests:
+			held, err := g.s.leases.renew(req.Id, req.Ttl.AsDuration())
+			if err != nil {
+				return status.Error(codes.NotFound, err.Error())
+			}
+			if err = stream.Send(encodeLease(held.describe())); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (g *grpcAPI) FetchLease(_ context.Context, req *apipb.LeaseRequest) (*apipb.Lease, error) {
+	held, ok := g.s.leases.fetch(req.Id)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "no such lease, it may have expired")
+	}
+	return encodeLease(held.describe()), nil
+}
+
+func (g *grpcAPI) Release(_ context.Context, req *apipb.LeaseRequest) (*apipb.ReleaseReply, error) {
+	if !g.s.leases.release(req.Id) {
+		return nil, status.Error(codes.NotFound, "no such lease, it may have expired")
+	}
+	return &apipb.ReleaseReply{}, nil
+}
+
+func (g *grpcAPI) CreateSemaphorePool(_ context.Context, req *apipb.CreatePoolRequest) (*apipb.CreatePoolReply, error) {
+	if req.Size <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "a semaphore pool requires a positive size")
+	}
+	elem, code, err := g.s.element(req.Namespace, cleanPath(req.Path), true)
+	if err != nil {
+		return nil, grpcError(code, err)
+	}
+	if err = elem.CreateSemaphorePool(req.Prefix, req.Replace, whatnot.SemaphorePoolOpts{PoolSize: req.Size, Prefix: req.Prefix}); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &apipb.CreatePoolReply{}, nil
+}
+
+func (g *grpcAPI) Claim(ctx context.Context, req *apipb.ClaimRequest) (*apipb.SemaphoreClaim, error) {
+	elem, code, err := g.s.element(req.Namespace, cleanPath(req.Path), false)
+	if err != nil {
+		return nil, grpcError(code, err)
+	}
+	held, code, err := g.s.claimSlots(ctx, req.Namespace, elem, req.Slots)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		return nil, grpcError(code, err)
+	}
+	return &apipb.SemaphoreClaim{Id: held.ID, Namespace: held.Namespace, Path: held.Path, Slots: held.Slots}, nil
+}
+
+func (g *grpcAPI) ReturnClaim(_ context.Context, req *apipb.ReturnClaimRequest) (*apipb.ReturnClaimReply, error) {
+	ok, err := g.s.claims.give(req.Id)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "no such semaphore claim")
+	}
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &apipb.ReturnClaimReply{}, nil
+}
+
+// Watch sends its response headers once subscribed, so clients waiting for them cannot miss any later event
+func (g *grpcAPI) Watch(req *apipb.WatchRequest, stream apipb.Whatnot_WatchServer) error {
+	elem, code, err := g.s.elementOrRoot(req.Namespace, cleanPath(req.Path))
+	if err != nil {
+		return grpcError(code, err)
+	}
+	ctx, cancel := g.s.streamContext(stream.Context())
+	defer cancel()
+	g.s.streamEvents(ctx, elem, req.Recursive, g.s.defaultHeartbeat(), &grpcStream{stream: stream}, func() {
+		_ = stream.SendHeader(nil)
+	})
+	return nil
+}
+
+// grpcStream sends watch events as messages, heartbeats are left to gRPC keepalives
+type grpcStream struct {
+	stream apipb.Whatnot_WatchServer
+}
+
+func (g *grpcStream) event(e watchEvent) error {
+	return g.stream.Send(&apipb.WatchEvent{
+		Time:    timestamppb.New(e.Time),
+		Change:  e.Change,
+		Path:    e.Path,
+		Note:    e.Note,
+		Actor:   e.Actor,
+		Version: encodeVersion(e.Version),
+	})
+}
+
+func (g *grpcStream) heartbeat(time.Time) error {
+	return nil
+}
+
+// streamContext ends with the stream, or when the server shuts down
+func (s *Server) streamContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-s.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+func encodeVersion(v whatnot.Version) *apipb.Version {
+	return &apipb.Version{Wall: v.Wall, Logical: v.Logical, Node: v.Node}
+}
+
+func encodeLease(info leaseInfo) *apipb.Lease {
+	return &apipb.Lease{
+		Id:        info.ID,
+		Namespace: info.Namespace,
+		Path:      info.Path,
+		Prefix:    info.Prefix,
+		Ttl:       durationpb.New(time.Duration(info.TTL)),
+		Expires:   timestamppb.New(info.Expires),
+	}
+}
+
+func encodeElement(described keyValue) (*apipb.Element, error) {
+	value, err := encodeValue(described.Value)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &apipb.Element{
+		Namespace: described.Namespace,
+		Path:      described.Path,
+		Value:     value,
+		Version:   encodeVersion(described.Version),
+		Children:  described.Children,
+		Paths:     described.Paths,
+	}, nil
+}
+
+// encodeValue converts an element's value to a protobuf Value
+// values of types that protobuf cannot represent directly are converted through their JSON encoding, as the HTTP API sends them
+func encodeValue(v interface{}) (*structpb.Value, error) {
+	if value, err := structpb.NewValue(v); err == nil {
+		return value, nil
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "value cannot be encoded")
+	}
+	var decoded interface{}
+	if err = json.Unmarshal(encoded, &decoded); err != nil {
+		return nil, errors.Wrap(err, "value cannot be encoded")
+	}
+	return structpb.NewValue(decoded)
+}
@@ -0,0 +1,459 @@
+/*
+Package server hosts a whatnot NameSpaceManager as a standalone service, exposing its namespaces, paths, values,
+leases, semaphores and watches over an HTTP JSON API, so that programs not written in Go can coordinate through it,
+and over a gRPC API for the Go client package.
+Several servers can be clustered together with PeerSync, exactly as embedded instances are.
+*/
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/databeast/whatnot"
+	"github.com/databeast/whatnot/access"
+	"github.com/databeast/whatnot/apipb"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+)
+
+// Server is a standalone whatnot instance, serving the HTTP API over its own NameSpaceManager
+type Server struct {
+	cfg     Config
+	manager *whatnot.NameSpaceManager
+	http    *http.Server
+	grpc    *grpc.Server
+	leases  *leaseTable
+	claims  *claimTable
+
+	watching atomic.Int64 // open watch streams
+
+	// ctx is the base of every request context, cancelled on shutdown to end streaming requests
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// New creates a server from its configuration, joining its cluster if one is configured
+// it does not begin serving its APIs until ListenAndServe, Serve or ServeGRPC is called
+func New(cfg Config) (s *Server, err error) {
+	if err = cfg.validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid configuration")
+	}
+	var opts []whatnot.ManagerOption
+	if cfg.Cluster.Listen != "" {
+		lis, err := net.Listen("tcp", cfg.Cluster.Listen)
+		if err != nil {
+			return nil, errors.Wrap(err, "listening for peer synchronization")
+		}
+		node := cfg.Cluster.NodeName
+		if node == "" {
+			node = cfg.Cluster.Listen
+		}
+		opts = append(opts, whatnot.WithPeerSync{NodeName: node, Listener: lis})
+		if len(cfg.Cluster.Peers) > 0 {
+			opts = append(opts, whatnot.WithPeers(cfg.Cluster.Peers))
+		}
+	}
+	manager, err := whatnot.NewNamespaceManager(opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating namespace manager")
+	}
+	for _, name := range cfg.Namespaces {
+		if err = manager.RegisterNamespace(whatnot.NewNamespace(name)); err != nil {
+			_ = manager.Close()
+			return nil, errors.Wrapf(err, "registering namespace %q", name)
+		}
+	}
+
+	s = &Server{
+		cfg:     cfg,
+		manager: manager,
+		leases:  newLeaseTable(),
+		claims:  newClaimTable(),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.http = &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: time.Second * 10,
+		BaseContext:       func(net.Listener) context.Context { return s.ctx },
+	}
+	s.grpc = grpc.NewServer()
+	apipb.RegisterWhatnotServer(s.grpc, &grpcAPI{s: s})
+	return s, nil
+}
+
+// Manager is the NameSpaceManager the server hosts
+func (s *Server) Manager() *whatnot.NameSpaceManager {
+	return s.manager
+}
+
+// Handler serves the HTTP API, for mounting it into another HTTP server
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	s.routes(mux)
+	return mux
+}
+
+// ListenAndServe serves the HTTP API, and the gRPC API if it has an address, until Shutdown is called
+func (s *Server) ListenAndServe() error {
+	if s.cfg.GRPCListen == "" {
+		return s.serveHTTP()
+	}
+	lis, err := net.Listen("tcp", s.cfg.GRPCListen)
+	if err != nil {
+		return errors.Wrap(err, "listening for the gRPC API")
+	}
+	failed := make(chan error, 2)
+	go func() { failed <- s.ServeGRPC(lis) }()
+	go func() { failed <- s.serveHTTP() }()
+	if err = <-failed; err != nil {
+		return err
+	}
+	return <-failed
+}
+
+func (s *Server) serveHTTP() error {
+	err := s.http.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Serve serves the HTTP API on an existing listener, until Shutdown is called
+func (s *Server) Serve(lis net.Listener) error {
+	err := s.http.Serve(lis)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops serving, releases every lease and semaphore claim made through the API, and leaves the cluster
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.cancel()
+	err := s.http.Shutdown(ctx)
+	s.stopGRPC(ctx)
+	s.leases.releaseAll()
+	s.claims.returnAll()
+	if cerr := s.manager.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// namespace fetches a namespace by name, registering it if the server creates namespaces on demand
+func (s *Server) namespace(name string) (*whatnot.Namespace, error) {
+	ns, err := s.manager.FetchNamespace(name)
+	if err == nil || !s.cfg.CreateNamespaces {
+		return ns, err
+	}
+	if err = s.manager.RegisterNamespace(whatnot.NewNamespace(name)); err != nil {
+		// most likely registered by a concurrent request
+		return s.manager.FetchNamespace(name)
+	}
+	return s.manager.FetchNamespace(name)
+}
+
+// newID generates an identifier for a lease or claim, unguessable so that clients cannot release each other's
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// element resolves an element of a namespace, creating it if requested
+// the HTTP status describing any failure is returned with it, the gRPC API translates it to a status code
+// the root of a namespace is refused, use elementOrRoot where it is meaningful
+func (s *Server) element(name string, path whatnot.PathString, create bool) (elem *whatnot.PathElement, status int, err error) {
+	if path == rootPath {
+		return nil, http.StatusBadRequest, errors.New("the root of a namespace cannot be addressed here, a path within it is required")
+	}
+	ns, err := s.namespace(name)
+	if err != nil {
+		return nil, http.StatusNotFound, err
+	}
+	if create {
+		if elem, err = ns.FetchOrCreateAbsolutePath(path); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		return elem, http.StatusOK, nil
+	}
+	if elem = ns.FetchAbsolutePath(path); elem == nil {
+		return nil, http.StatusNotFound, errors.Errorf("no such path %q in namespace %q", path, name)
+	}
+	return elem, http.StatusOK, nil
+}
+
+// elementOrRoot resolves an element of a namespace, or the root of the namespace itself
+func (s *Server) elementOrRoot(name string, path whatnot.PathString) (elem *whatnot.PathElement, status int, err error) {
+	if path != rootPath {
+		return s.element(name, path, false)
+	}
+	ns, err := s.namespace(name)
+	if err != nil {
+		return nil, http.StatusNotFound, err
+	}
+	return ns.Root(), http.StatusOK, nil
+}
+
+// acquireLease waits for a lease on the element for as long as the context allows, held for ttl unless it is renewed
+// renewals cannot extend a lease beyond MaxLeaseLifetime after it was acquired
+func (s *Server) acquireLease(ctx context.Context, namespace string, elem *whatnot.PathElement, ttl time.Duration, prefix bool) (held *heldLease, status int, err error) {
+	if ttl <= 0 {
+		return nil, http.StatusBadRequest, errors.New("a lease requires a positive ttl")
+	}
+	lifetime := time.Duration(s.cfg.MaxLeaseLifetime)
+	if lifetime <= 0 {
+		lifetime = defaultMaxLease
+	}
+	if ttl > lifetime {
+		ttl = lifetime
+	}
+
+	// the request only bounds the wait, a granted lease outlives the request that acquired it
+	role, _ := access.RoleFromContext(ctx)
+	waitctx, abandon := context.WithCancel(access.WithRole(context.Background(), role))
+	granted := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			select {
+			case <-granted:
+			default:
+				abandon()
+			}
+		case <-granted:
+		}
+	}()
+	var lease *whatnot.LeaseContext
+	var release func()
+	if prefix {
+		lease, release = elem.ContextLockPrefixWithLease(waitctx, ttl)
+	} else {
+		lease, release = elem.ContextLockWithLease(waitctx, ttl)
+	}
+	close(granted)
+	if err = lease.Err(); err != nil || ctx.Err() != nil {
+		release()
+		abandon()
+		if ctx.Err() != nil {
+			return nil, http.StatusRequestTimeout, ctx.Err()
+		}
+		return nil, http.StatusServiceUnavailable, errors.Wrap(err, "lease was not granted")
+	}
+	expires, _ := lease.Deadline()
+	held = &heldLease{
+		info: leaseInfo{
+			Namespace: namespace,
+			Path:      string(elem.AbsolutePath().ToPathString()),
+			Prefix:    prefix,
+			TTL:       Duration(ttl),
+			Expires:   expires,
+		},
+		limit:   time.Now().Add(lifetime),
+		lease:   lease,
+		release: func() { release(); abandon() },
+	}
+	s.leases.add(held)
+	return held, http.StatusCreated, nil
+}
+
+// claimSlots waits for slots of the element's semaphore pool, for as long as the context allows
+func (s *Server) claimSlots(ctx context.Context, namespace string, elem *whatnot.PathElement, slots int64) (held *heldClaim, status int, err error) {
+	pool := elem.SemaphorePool()
+	if pool == nil {
+		return nil, http.StatusNotFound, errors.Errorf("no semaphore pool on %s", elem.AbsolutePath().ToPathString())
+	}
+	if slots <= 0 {
+		return nil, http.StatusBadRequest, errors.New("at least one slot must be claimed")
+	}
+	claim, err := pool.Claim(ctx, slots)
+	if err != nil {
+		return nil, http.StatusConflict, err
+	}
+	held = &heldClaim{
+		Namespace: namespace,
+		Path:      string(elem.AbsolutePath().ToPathString()),
+		Slots:     slots,
+		claim:     claim,
+	}
+	s.claims.add(held)
+	return held, http.StatusCreated, nil
+}
+
+// leaseInfo describes a lease acquired through the API
+type leaseInfo struct {
+	ID        string    `json:"id"`
+	Namespace string    `json:"namespace"`
+	Path      string    `json:"path"`
+	Prefix    bool      `json:"prefix"`
+	TTL       Duration  `json:"ttl"`
+	Expires   time.Time `json:"expires"`
+}
+
+// heldLease is a lease acquired through the API, held until it expires or the client releases it
+type heldLease struct {
+	mu    sync.Mutex
+	info  leaseInfo
+	limit time.Time // the lease cannot be renewed beyond this
+
+	lease   *whatnot.LeaseContext
+	release func()
+}
+
+// describe is a snapshot of the lease's current state
+func (h *heldLease) describe() leaseInfo {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.info
+}
+
+// renew pushes the lease's expiry back to ttl from now, or its original ttl if none is given, up to its lifetime limit
+func (h *heldLease) renew(ttl time.Duration) (leaseInfo, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if ttl <= 0 {
+		ttl = time.Duration(h.info.TTL)
+	}
+	if remaining := time.Until(h.limit); ttl > remaining {
+		if remaining <= 0 {
+			return h.info, errors.New("lease has reached its maximum lifetime and cannot be renewed")
+		}
+		ttl = remaining
+	}
+	if err := h.lease.Renew(ttl); err != nil {
+		return h.info, err
+	}
+	h.info.Expires, _ = h.lease.Deadline()
+	return h.info, nil
+}
+
+type leaseTable struct {
+	mu     *sync.Mutex
+	leases map[string]*heldLease
+}
+
+func newLeaseTable() *leaseTable {
+	return &leaseTable{
+		mu:     &sync.Mutex{},
+		leases: make(map[string]*heldLease),
+	}
+}
+
+// add records a granted lease, forgetting it once it finishes
+func (t *leaseTable) add(held *heldLease) {
+	held.info.ID = newID()
+	t.mu.Lock()
+	t.leases[held.info.ID] = held
+	t.mu.Unlock()
+	go func() {
+		<-held.lease.Done()
+		t.mu.Lock()
+		delete(t.leases, held.info.ID)
+		t.mu.Unlock()
+	}()
+}
+
+func (t *leaseTable) fetch(id string) (*heldLease, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	held, ok := t.leases[id]
+	return held, ok
+}
+
+// renew extends a held lease
+func (t *leaseTable) renew(id string, ttl time.Duration) (*heldLease, error) {
+	held, ok := t.fetch(id)
+	if !ok {
+		return nil, errors.New("no such lease, it may have expired")
+	}
+	if _, err := held.renew(ttl); err != nil {
+		return nil, err
+	}
+	return held, nil
+}
+
+// release ends a lease early
+func (t *leaseTable) release(id string) bool {
+	t.mu.Lock()
+	held, ok := t.leases[id]
+	delete(t.leases, id)
+	t.mu.Unlock()
+	if ok {
+		held.release()
+	}
+	return ok
+}
+
+func (t *leaseTable) releaseAll() {
+	t.mu.Lock()
+	var ids []string
+	for id := range t.leases {
+		ids = append(ids, id)
+	}
+	t.mu.Unlock()
+	for _, id := range ids {
+		t.release(id)
+	}
+}
+
+// heldClaim is a semaphore claim made through the API, held until the client returns it
+type heldClaim struct {
+	ID        string `json:"id"`
+	Namespace string `json:"namespace"`
+	Path      string `json:"path"`
+	Slots     int64  `json:"slots"`
+
+	claim *whatnot.SemaphoreClaim
+}
+
+type claimTable struct {
+	mu     *sync.Mutex
+	claims map[string]*heldClaim
+}
+
+func newClaimTable() *claimTable {
+	return &claimTable{
+		mu:     &sync.Mutex{},
+		claims: make(map[string]*heldClaim),
+	}
+}
+
+func (t *claimTable) add(held *heldClaim) {
+	held.ID = newID()
+	t.mu.Lock()
+	t.claims[held.ID] = held
+	t.mu.Unlock()
+}
+
+// give returns a claim to its pool
+func (t *claimTable) give(id string) (ok bool, err error) {
+	t.mu.Lock()
+	held, ok := t.claims[id]
+	delete(t.claims, id)
+	t.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, held.claim.Return()
+}
+
+func (t *claimTable) returnAll() {
+	t.mu.Lock()
+	var ids []string
+	for id := range t.claims {
+		ids = append(ids, id)
+	}
+	t.mu.Unlock()
+	for _, id := range ids {
+		_, _ = t.give(id)
+	}
+}
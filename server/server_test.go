@@ -0,0 +1,279 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testNamespace = "testing"
+
+func TestServerAPI(t *testing.T) {
+	t.Run("Namespaces are listed and registered", namespacesListedAndRegistered)
+	t.Run("Values are written and read", valuesWrittenAndRead)
+	t.Run("Unknown paths are not found", unknownPathsNotFound)
+	t.Run("Leases are exclusive until released", leasesExclusiveUntilReleased)
+	t.Run("Semaphore claims are limited by pool size", semaphoreClaimsLimited)
+	t.Run("Watches stream element events", watchesStreamEvents)
+	t.Run("Namespaces are created on demand", namespacesCreatedOnDemand)
+	t.Run("Children are listed", childrenListed)
+	t.Run("Elements are deleted", elementsDeleted)
+	t.Run("Prefix leases cover elements beneath", prefixLeasesCoverChildren)
+	t.Run("Leases expire unless renewed", leasesExpireUnlessRenewed)
+}
+
+// createTestServer starts a server with a single namespace, over an in-process HTTP listener
+func createTestServer(t *testing.T, cfg Config) (srv *Server, url string) {
+	cfg.Namespaces = append(cfg.Namespaces, testNamespace)
+	srv, err := New(cfg)
+	if !assert.Nil(t, err, "creating server failed") {
+		t.FailNow()
+	}
+	web := httptest.NewUnstartedServer(srv.Handler())
+	web.Config.BaseContext = srv.http.BaseContext
+	web.Start()
+	t.Cleanup(func() {
+		_ = srv.Shutdown(context.Background())
+		web.Close()
+	})
+	return srv, web.URL
+}
+
+// call makes a JSON request, decoding any response body into result
+func call(t *testing.T, method string, url string, body interface{}, result interface{}) (status int) {
+	var encoded bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&encoded).Encode(body)
+	}
+	req, _ := http.NewRequest(method, url, &encoded)
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.Nil(t, err, "request failed") {
+		return 0
+	}
+	defer resp.Body.Close()
+	if result != nil {
+		_ = json.NewDecoder(resp.Body).Decode(result)
+	}
+	return resp.StatusCode
+}
+
+func namespacesListedAndRegistered(t *testing.T) {
+	_, url := createTestServer(t, DefaultConfig())
+
+	assert.Equal(t, http.StatusCreated, call(t, http.MethodPut, url+"/v1/namespaces/second", nil, nil), "registering namespace failed")
+	assert.Equal(t, http.StatusConflict, call(t, http.MethodPut, url+"/v1/namespaces/second", nil, nil), "namespace was registered twice")
+
+	var list namespaceList
+	assert.Equal(t, http.StatusOK, call(t, http.MethodGet, url+"/v1/namespaces", nil, &list))
+	assert.ElementsMatch(t, []string{testNamespace, "second"}, list.Namespaces, "namespace listing differs")
+	assert.Equal(t, http.StatusNotFound, call(t, http.MethodGet, url+"/v1/namespaces/missing", nil, nil), "missing namespace was found")
+}
+
+func valuesWrittenAndRead(t *testing.T) {
+	_, url := createTestServer(t, DefaultConfig())
+
+	var written keyValue
+	status := call(t, http.MethodPut, url+"/v1/keys/testing/config/color", setValueRequest{Value: "blue"}, &written)
+	assert.Equal(t, http.StatusOK, status, "writing value failed")
+	assert.False(t, written.Version.IsZero(), "written value has no version")
+
+	var read keyValue
+	assert.Equal(t, http.StatusOK, call(t, http.MethodGet, url+"/v1/keys/testing/config/color", nil, &read), "reading value failed")
+	assert.Equal(t, "/config/color", read.Path)
+	assert.Equal(t, "blue", read.Value, "value differs")
+	assert.Equal(t, written.Version, read.Version, "version differs")
+}
+
+func unknownPathsNotFound(t *testing.T) {
+	_, url := createTestServer(t, DefaultConfig())
+
+	var failure apiError
+	assert.Equal(t, http.StatusNotFound, call(t, http.MethodGet, url+"/v1/keys/testing/never/written", nil, &failure), "unknown path was found")
+	assert.NotEmpty(t, failure.Error, "error response did not explain itself")
+	assert.Equal(t, http.StatusNotFound, call(t, http.MethodGet, url+"/v1/keys/missing/path", nil, nil), "unknown namespace was found")
+	assert.Equal(t, http.StatusBadRequest, call(t, http.MethodPut, url+"/v1/keys/testing", setValueRequest{Value: 1}, nil), "writing the namespace root was accepted")
+}
+
+func leasesExclusiveUntilReleased(t *testing.T) {
+	_, url := createTestServer(t, DefaultConfig())
+
+	var first leaseInfo
+	status := call(t, http.MethodPost, url+"/v1/locks/testing/jobs/nightly", lockRequest{TTL: Duration(time.Minute)}, &first)
+	if !assert.Equal(t, http.StatusCreated, status, "acquiring lease failed") {
+		return
+	}
+	assert.NotEmpty(t, first.ID, "lease has no id")
+	assert.True(t, first.Expires.After(time.Now()), "lease expiry is not in the future")
+	assert.Equal(t, http.StatusOK, call(t, http.MethodGet, url+"/v1/leases/"+first.ID, nil, nil), "held lease was not found")
+
+	acquired := make(chan leaseInfo)
+	go func() {
+		var second leaseInfo
+		call(t, http.MethodPost, url+"/v1/locks/testing/jobs/nightly", lockRequest{TTL: Duration(time.Minute)}, &second)
+		acquired <- second
+	}()
+	select {
+	case <-acquired:
+		t.Error("second lease was granted while the first was held")
+		return
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	assert.Equal(t, http.StatusNoContent, call(t, http.MethodDelete, url+"/v1/leases/"+first.ID, nil, nil), "releasing lease failed")
+	select {
+	case second := <-acquired:
+		assert.NotEqual(t, first.ID, second.ID, "second lease reused the first lease's id")
+	case <-time.After(time.Second * 5):
+		t.Error("second lease was not granted after the first was released")
+	}
+	assert.Equal(t, http.StatusNotFound, call(t, http.MethodDelete, url+"/v1/leases/"+first.ID, nil, nil), "released lease was released again")
+}
+
+func semaphoreClaimsLimited(t *testing.T) {
+	_, url := createTestServer(t, DefaultConfig())
+
+	pool := url + "/v1/semaphores/testing/workers"
+	assert.Equal(t, http.StatusCreated, call(t, http.MethodPut, pool, createPoolRequest{Size: 2}, nil), "creating pool failed")
+
+	var first, second heldClaim
+	assert.Equal(t, http.StatusCreated, call(t, http.MethodPost, pool, claimRequest{Slots: 1}, &first), "first claim failed")
+	assert.Equal(t, http.StatusCreated, call(t, http.MethodPost, pool, claimRequest{Slots: 1}, &second), "second claim failed")
+	assert.Equal(t, http.StatusConflict, call(t, http.MethodPost, pool, claimRequest{Slots: 1, Wait: Duration(time.Millisecond * 100)}, nil), "claim beyond pool size was granted")
+
+	assert.Equal(t, http.StatusNoContent, call(t, http.MethodDelete, url+"/v1/claims/"+first.ID, nil, nil), "returning claim failed")
+	assert.Equal(t, http.StatusCreated, call(t, http.MethodPost, pool, claimRequest{Slots: 1, Wait: Duration(time.Second)}, nil), "claim after return failed")
+}
+
+func watchesStreamEvents(t *testing.T) {
+	_, url := createTestServer(t, DefaultConfig())
+
+	resp, err := http.Get(url + "/v1/watch/testing/watched?recursive=true")
+	if !assert.Nil(t, err, "opening watch failed") {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	received := make(chan watchEvent)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			var e watchEvent
+			if json.Unmarshal(scanner.Bytes(), &e) == nil {
+				received <- e
+			}
+		}
+	}()
+
+	call(t, http.MethodPut, url+"/v1/keys/testing/watched/child", setValueRequest{Value: 1}, nil)
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case e := <-received:
+			if e.Path == "/watched/child" && e.Change == "edited" {
+				assert.False(t, e.Version.IsZero(), "event has no version")
+				return
+			}
+		case <-timeout:
+			t.Error("value change was not streamed to the watcher")
+			return
+		}
+	}
+}
+
+func namespacesCreatedOnDemand(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CreateNamespaces = true
+	_, url := createTestServer(t, cfg)
+
+	status := call(t, http.MethodPut, url+"/v1/keys/ondemand/some/key", setValueRequest{Value: true}, nil)
+	assert.Equal(t, http.StatusOK, status, "writing to an unregistered namespace failed")
+	assert.Equal(t, http.StatusOK, call(t, http.MethodGet, url+"/v1/namespaces/ondemand", nil, nil), "namespace was not created")
+}
+
+func childrenListed(t *testing.T) {
+	_, url := createTestServer(t, DefaultConfig())
+	for _, path := range []string{"/fleet/ships/alpha", "/fleet/ships/bravo", "/fleet/docks", "/other"} {
+		call(t, http.MethodPut, url+"/v1/keys/testing"+path, setValueRequest{Value: path}, nil)
+	}
+
+	var fleet keyValue
+	assert.Equal(t, http.StatusOK, call(t, http.MethodGet, url+"/v1/keys/testing/fleet", nil, &fleet), "reading element failed")
+	assert.Equal(t, []string{"docks", "ships"}, fleet.Children, "children differ")
+	assert.Empty(t, fleet.Paths, "paths were listed without asking")
+
+	var root keyValue
+	assert.Equal(t, http.StatusOK, call(t, http.MethodGet, url+"/v1/keys/testing?recursive=true", nil, &root), "reading namespace root failed")
+	assert.Equal(t, []string{"fleet", "other"}, root.Children, "root children differ")
+	assert.Equal(t, []string{"/fleet/docks", "/fleet/ships/alpha", "/fleet/ships/bravo", "/other"}, root.Paths, "recursive paths differ")
+
+	var ships keyValue
+	call(t, http.MethodGet, url+"/v1/keys/testing/fleet/ships?recursive=true", nil, &ships)
+	assert.Equal(t, []string{"/fleet/ships/alpha", "/fleet/ships/bravo"}, ships.Paths, "recursive paths beneath element differ")
+}
+
+func elementsDeleted(t *testing.T) {
+	_, url := createTestServer(t, DefaultConfig())
+	call(t, http.MethodPut, url+"/v1/keys/testing/doomed/child", setValueRequest{Value: 1}, nil)
+
+	assert.Equal(t, http.StatusNoContent, call(t, http.MethodDelete, url+"/v1/keys/testing/doomed", nil, nil), "deleting element failed")
+	assert.Equal(t, http.StatusNotFound, call(t, http.MethodGet, url+"/v1/keys/testing/doomed/child", nil, nil), "child of deleted element remains")
+	assert.Equal(t, http.StatusNotFound, call(t, http.MethodDelete, url+"/v1/keys/testing/doomed", nil, nil), "deleted element was deleted again")
+	assert.Equal(t, http.StatusBadRequest, call(t, http.MethodDelete, url+"/v1/keys/testing", nil, nil), "namespace root was deleted")
+}
+
+func prefixLeasesCoverChildren(t *testing.T) {
+	_, url := createTestServer(t, DefaultConfig())
+	call(t, http.MethodPut, url+"/v1/keys/testing/tree/leaf", setValueRequest{Value: 1}, nil)
+
+	var prefix leaseInfo
+	status := call(t, http.MethodPost, url+"/v1/locks/testing/tree", lockRequest{TTL: Duration(time.Minute), Prefix: true}, &prefix)
+	if !assert.Equal(t, http.StatusCreated, status, "acquiring prefix lease failed") {
+		return
+	}
+	assert.True(t, prefix.Prefix, "lease is not reported as a prefix lease")
+	assert.Equal(t, Duration(time.Minute), prefix.TTL, "lease ttl differs")
+
+	acquired := make(chan struct{})
+	go func() {
+		call(t, http.MethodPost, url+"/v1/locks/testing/tree/leaf", lockRequest{TTL: Duration(time.Minute)}, nil)
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Error("lease beneath a prefix lease was granted")
+		return
+	case <-time.After(time.Millisecond * 200):
+	}
+	call(t, http.MethodDelete, url+"/v1/leases/"+prefix.ID, nil, nil)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second * 5):
+		t.Error("lease beneath was not granted after the prefix lease was released")
+	}
+}
+
+func leasesExpireUnlessRenewed(t *testing.T) {
+	_, url := createTestServer(t, DefaultConfig())
+
+	var renewed, lapsed leaseInfo
+	call(t, http.MethodPost, url+"/v1/locks/testing/renewed", lockRequest{TTL: Duration(time.Millisecond * 300)}, &renewed)
+	call(t, http.MethodPost, url+"/v1/locks/testing/lapsed", lockRequest{TTL: Duration(time.Millisecond * 300)}, &lapsed)
+
+	var extended leaseInfo
+	assert.Equal(t, http.StatusOK, call(t, http.MethodPut, url+"/v1/leases/"+renewed.ID, renewRequest{TTL: Duration(time.Minute)}, &extended), "renewing lease failed")
+	assert.True(t, extended.Expires.After(renewed.Expires), "renewal did not extend the lease")
+
+	assert.Eventually(t, func() bool {
+		return call(t, http.MethodGet, url+"/v1/leases/"+lapsed.ID, nil, nil) == http.StatusNotFound
+	}, time.Second*5, time.Millisecond*50, "lease that was not renewed did not expire")
+	assert.Equal(t, http.StatusOK, call(t, http.MethodGet, url+"/v1/leases/"+renewed.ID, nil, nil), "renewed lease expired")
+	assert.Equal(t, http.StatusNotFound, call(t, http.MethodPut, url+"/v1/leases/"+lapsed.ID, nil, nil), "expired lease was renewed")
+}
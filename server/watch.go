@@ -0,0 +1,272 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/databeast/whatnot"
+	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
+)
+
+/*
+Watches stream the events of an element, and optionally everything beneath it, for as long as the client stays
+connected. Three formats are offered from the same route: newline delimited JSON by default, Server-Sent Events
+for clients accepting text/event-stream, and WebSocket for clients requesting an upgrade. Every format sends
+heartbeats while no events occur, so clients and proxies can tell an idle watch from a dead one.
+*/
+
+const (
+	// events buffered for a watch stream while earlier ones are written to the client
+	watchBuffer = 64
+	// the shortest heartbeat interval a client may ask for
+	minWatchHeartbeat = time.Millisecond * 10
+)
+
+// Watching is the number of watches currently streaming to clients
+func (s *Server) Watching() int64 {
+	return s.watching.Load()
+}
+
+// watchEvent is a WatchEvent as sent to API clients
+type watchEvent struct {
+	Time    time.Time       `json:"time"`
+	Change  string          `json:"change"`
+	Path    string          `json:"path"`
+	Note    string          `json:"note,omitempty"`
+	Actor   string          `json:"actor,omitempty"` // the role of whoever caused the event
+	Version whatnot.Version `json:"version"`
+}
+
+// watchHeartbeat is sent while a watch has no events, in place of one
+type watchHeartbeat struct {
+	Heartbeat time.Time `json:"heartbeat"`
+}
+
+func describeEvent(e whatnot.WatchEvent) watchEvent {
+	event := watchEvent{
+		Time:    e.TS,
+		Change:  e.Change.String(),
+		Note:    e.Note,
+		Actor:   e.Actor.Name,
+		Version: e.Version,
+	}
+	if elem := e.OnElement(); elem != nil {
+		event.Path = string(elem.AbsolutePath().ToPathString())
+	}
+	return event
+}
+
+// watchStream writes a watch to the client in one of the supported formats
+type watchStream interface {
+	event(e watchEvent) error
+	heartbeat(at time.Time) error
+}
+
+// handleWatch streams the events of an element, and of everything beneath it if recursive=true
+// the heartbeat interval can be chosen with heartbeat=<duration>, and the format with format=ndjson|sse
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	every, err := s.heartbeatInterval(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	elem, status, err := s.fetchElement(routeWatch, r, true)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	recursive := r.URL.Query().Get("recursive") == "true"
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		s.watchWebSocket(elem, recursive, every).ServeHTTP(w, r)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported by this connection"))
+		return
+	}
+	var stream watchStream
+	if r.URL.Query().Get("format") == "sse" || strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		w.Header().Set("Content-Type", "text/event-stream")
+		stream = &sseStream{w: w, flusher: flusher}
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		stream = &ndjsonStream{enc: json.NewEncoder(w), flusher: flusher}
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	s.streamEvents(r.Context(), elem, recursive, every, stream, func() {
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+	})
+}
+
+// heartbeatInterval is the interval a watch request asked for, or the configured default
+func (s *Server) heartbeatInterval(r *http.Request) (time.Duration, error) {
+	every := s.defaultHeartbeat()
+	if requested := r.URL.Query().Get("heartbeat"); requested != "" {
+		parsed, err := time.ParseDuration(requested)
+		if err != nil {
+			return 0, errors.Wrap(err, "invalid heartbeat interval")
+		}
+		every = parsed
+	}
+	if every < minWatchHeartbeat {
+		return 0, errors.Errorf("heartbeat interval must be at least %s", minWatchHeartbeat)
+	}
+	return every, nil
+}
+
+// defaultHeartbeat is the configured heartbeat interval, never shorter than minWatchHeartbeat
+func (s *Server) defaultHeartbeat() time.Duration {
+	if every := time.Duration(s.cfg.WatchHeartbeat); every > minWatchHeartbeat {
+		return every
+	}
+	return minWatchHeartbeat
+}
+
+// streamEvents subscribes to the element, writing its events to the stream until the context ends or a write fails
+// subscribed is called once events are being collected, so that nothing sent after it can be missed
+func (s *Server) streamEvents(ctx context.Context, elem *whatnot.PathElement, recursive bool, every time.Duration, stream watchStream, subscribed func()) {
+	sub := elem.SubscribeToEvents(recursive)
+	defer elem.UnSubscribeFromEvents(sub)
+	s.watching.Add(1)
+	defer s.watching.Add(-1)
+	events := pumpEvents(ctx, sub)
+	subscribed()
+
+	heartbeats := time.NewTicker(every)
+	defer heartbeats.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if stream.event(describeEvent(e)) != nil {
+				return
+			}
+			heartbeats.Reset(every) // only idle watches need them
+		case at := <-heartbeats.C:
+			if stream.heartbeat(at) != nil {
+				return
+			}
+		}
+	}
+}
+
+// pumpEvents receives a subscription's events into a buffer, as subscribers that are not ready to receive
+// an event are dropped. The returned channel closes when the context ends, or the subscription is dropped regardless
+func pumpEvents(ctx context.Context, sub *whatnot.ElementWatchSubscription) <-chan whatnot.WatchEvent {
+	buffered := make(chan whatnot.WatchEvent, watchBuffer)
+	go func() {
+		defer close(buffered)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-sub.Events():
+				if !ok {
+					return
+				}
+				select {
+				case buffered <- e:
+				default:
+					return // the client is not keeping up, end the stream so it can reconnect
+				}
+			}
+		}
+	}()
+	return buffered
+}
+
+// ndjsonStream writes one JSON object per line
+type ndjsonStream struct {
+	enc     *json.Encoder
+	flusher http.Flusher
+}
+
+func (n *ndjsonStream) event(e watchEvent) error {
+	return n.send(e)
+}
+
+func (n *ndjsonStream) heartbeat(at time.Time) error {
+	return n.send(watchHeartbeat{Heartbeat: at})
+}
+
+func (n *ndjsonStream) send(v interface{}) error {
+	if err := n.enc.Encode(v); err != nil {
+		return err
+	}
+	n.flusher.Flush()
+	return nil
+}
+
+// sseStream writes Server-Sent Events, named after their change, with heartbeats sent as comments
+type sseStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func (s *sseStream) event(e watchEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", e.Change, data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *sseStream) heartbeat(at time.Time) error {
+	if _, err := fmt.Fprintf(s.w, ": heartbeat %s\n\n", at.UTC().Format(time.RFC3339Nano)); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// wsStream writes each event or heartbeat as a WebSocket text message
+type wsStream struct {
+	conn *websocket.Conn
+}
+
+func (s *wsStream) event(e watchEvent) error {
+	return websocket.JSON.Send(s.conn, e)
+}
+
+func (s *wsStream) heartbeat(at time.Time) error {
+	return websocket.JSON.Send(s.conn, watchHeartbeat{Heartbeat: at})
+}
+
+// watchWebSocket streams the watch over a WebSocket, until either side closes it
+// any origin is accepted, as the API itself is unauthenticated
+func (s *Server) watchWebSocket(elem *whatnot.PathElement, recursive bool, every time.Duration) http.Handler {
+	return websocket.Server{
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(conn *websocket.Conn) {
+			ctx, cancel := context.WithCancel(conn.Request().Context())
+			defer cancel()
+			go func() {
+				// clients send nothing, so a failed read means the client has closed the socket or gone away
+				var discard []byte
+				for websocket.Message.Receive(conn, &discard) == nil {
+				}
+				cancel()
+			}()
+			s.streamEvents(ctx, elem, recursive, every, &wsStream{conn: conn}, func() {})
+		},
+	}
+}
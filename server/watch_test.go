@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+)
+
+func TestWatchStreams(t *testing.T) {
+	t.Run("Server-Sent Events stream element events", sseStreamsEvents)
+	t.Run("Idle watches receive heartbeats", idleWatchesReceiveHeartbeats)
+	t.Run("WebSocket streams element events", webSocketStreamsEvents)
+	t.Run("WebSocket is unsubscribed when the client closes", webSocketUnsubscribesOnClose)
+	t.Run("Invalid heartbeat intervals are refused", invalidHeartbeatRefused)
+}
+
+// readLines sends every line of the response body on the returned channel
+func readLines(resp *http.Response) <-chan string {
+	lines := make(chan string, 100)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return lines
+}
+
+func sseStreamsEvents(t *testing.T) {
+	_, url := createTestServer(t, DefaultConfig())
+
+	req, _ := http.NewRequest(http.MethodGet, url+"/v1/watch/testing/sse?recursive=true", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.Nil(t, err, "opening watch failed") {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	lines := readLines(resp)
+
+	call(t, http.MethodPut, url+"/v1/keys/testing/sse/child", setValueRequest{Value: "streamed"}, nil)
+	timeout := time.After(time.Second * 5)
+	var name string
+	for {
+		select {
+		case line := <-lines:
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: ") && name == "edited":
+				var e watchEvent
+				assert.Nil(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e), "event data is not JSON")
+				assert.Equal(t, "/sse/child", e.Path)
+				return
+			}
+		case <-timeout:
+			t.Error("value change was not streamed as a server-sent event")
+			return
+		}
+	}
+}
+
+func idleWatchesReceiveHeartbeats(t *testing.T) {
+	_, url := createTestServer(t, DefaultConfig())
+
+	resp, err := http.Get(url + "/v1/watch/testing/idle?heartbeat=50ms")
+	if !assert.Nil(t, err, "opening watch failed") {
+		return
+	}
+	defer resp.Body.Close()
+	lines := readLines(resp)
+
+	select {
+	case line := <-lines:
+		var beat watchHeartbeat
+		assert.Nil(t, json.Unmarshal([]byte(line), &beat), "heartbeat is not JSON")
+		assert.False(t, beat.Heartbeat.IsZero(), "heartbeat has no time")
+	case <-time.After(time.Second * 2):
+		t.Error("idle watch received no heartbeat")
+	}
+}
+
+// dialWatch opens a WebSocket watch on the test server
+func dialWatch(t *testing.T, url string, path string) *websocket.Conn {
+	wsURL := "ws" + strings.TrimPrefix(url, "http") + path
+	conn, err := websocket.Dial(wsURL, "", url)
+	if !assert.Nil(t, err, "opening websocket failed") {
+		t.FailNow()
+	}
+	return conn
+}
+
+func webSocketStreamsEvents(t *testing.T) {
+	_, url := createTestServer(t, DefaultConfig())
+	conn := dialWatch(t, url, "/v1/watch/testing/socket?recursive=true&heartbeat=50ms")
+	defer conn.Close()
+
+	received := make(chan map[string]interface{}, 100)
+	go func() {
+		for {
+			var msg map[string]interface{}
+			if websocket.JSON.Receive(conn, &msg) != nil {
+				close(received)
+				return
+			}
+			received <- msg
+		}
+	}()
+
+	call(t, http.MethodPut, url+"/v1/keys/testing/socket/child", setValueRequest{Value: 1}, nil)
+	var sawEvent, sawHeartbeat bool
+	timeout := time.After(time.Second * 5)
+	for !sawEvent || !sawHeartbeat {
+		select {
+		case msg := <-received:
+			if _, ok := msg["heartbeat"]; ok {
+				sawHeartbeat = true
+			} else if msg["change"] == "edited" && msg["path"] == "/socket/child" {
+				sawEvent = true
+			}
+		case <-timeout:
+			assert.True(t, sawEvent, "value change was not streamed over the websocket")
+			assert.True(t, sawHeartbeat, "no heartbeat was sent over the websocket")
+			return
+		}
+	}
+}
+
+func webSocketUnsubscribesOnClose(t *testing.T) {
+	srv, url := createTestServer(t, DefaultConfig())
+	conn := dialWatch(t, url, "/v1/watch/testing/closing")
+	assert.Eventually(t, func() bool { return srv.Watching() == 1 }, time.Second*5, time.Millisecond*10, "watch was not opened")
+
+	_ = conn.Close()
+	assert.Eventually(t, func() bool { return srv.Watching() == 0 }, time.Second*5, time.Millisecond*10, "watch was not closed with its websocket")
+}
+
+func invalidHeartbeatRefused(t *testing.T) {
+	_, url := createTestServer(t, DefaultConfig())
+	assert.Equal(t, http.StatusBadRequest, call(t, http.MethodGet, url+"/v1/watch/testing/path?heartbeat=never", nil, nil), "unparseable heartbeat was accepted")
+	assert.Equal(t, http.StatusBadRequest, call(t, http.MethodGet, url+"/v1/watch/testing/path?heartbeat=1ns", nil, nil), "tiny heartbeat was accepted")
+}
@@ -0,0 +1,112 @@
+package whatnot
+
+/*
+Shared locks let any number of holders lock an element at once, while keeping out exclusive holders.
+Readers that only need to stop writers take these, rather than queueing behind each other for the exclusive lock
+*/
+
+import (
+	"context"
+
+	"github.com/databeast/whatnot/access"
+	"github.com/databeast/whatnot/peerpb"
+)
+
+// RLock places a shared lock on this pathElement, waiting for any exclusive holder to release it
+// it also waits behind anyone already waiting for the exclusive lock, so they are not starved
+func (p *PathElement) RLock() {
+	_ = p.RLockContext(context.Background())
+}
+
+// RLockContext places a shared lock on this pathElement as RLock does, unless the context finishes first
+// in which case it returns the context's error
+func (p *PathElement) RLockContext(ctx context.Context) error {
+	if err := p.rlockContext(ctx); err != nil {
+		return err
+	}
+	p.replicate(&peerpb.Mutation{Op: peerpb.Operation_OPERATION_LOCK, Shared: true, Actor: roleOf(ctx).Name})
+	return nil
+}
+
+func (p *PathElement) rlockContext(ctx context.Context) error {
+	if err := p.lockHierarchy(ctx, false, true); err != nil {
+		return err
+	}
+	p.selfnotify <- elementChange{id: randid.Uint64(), elem: p, change: ChangeSharedLocked, actor: roleOf(ctx)}
+	return nil
+}
+
+// TryRLock places a shared lock on this pathElement, returning ErrLockUnavailable rather than waiting
+// any longer than defaultLockAttemptTimeout for an exclusive holder to release it
+func (p *PathElement) TryRLock() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultLockAttemptTimeout)
+	defer cancel()
+	if p.RLockContext(ctx) != nil {
+		return ErrLockUnavailable
+	}
+	return nil
+}
+
+// RUnLock gives up a shared lock on this pathElement
+func (p *PathElement) RUnLock() {
+	if p.runlock(access.Role{}, lockOwner{}) {
+		p.replicate(&peerpb.Mutation{Op: peerpb.Operation_OPERATION_UNLOCK, Shared: true})
+	}
+}
+
+func (p *PathElement) runlock(actor access.Role, owner lockOwner) bool {
+	if !p.unlockHierarchy(false, true, owner) {
+		return false
+	}
+	p.selfnotify <- elementChange{id: randid.Uint64(), elem: p, change: ChangeSharedUnlocked, actor: actor}
+	return true
+}
+
+// RLockSubs places a shared lock on this Path Element and every Path Element it is a parent to
+func (p *PathElement) RLockSubs() {
+	_ = p.RLockSubsContext(context.Background())
+}
+
+// RLockSubsContext places a shared lock on this Path Element and every Path Element it is a parent to,
+// unless the context finishes first, in which case it returns the context's error and none of them are left locked
+func (p *PathElement) RLockSubsContext(ctx context.Context) error {
+	if err := p.rlockSubsContext(ctx); err != nil {
+		return err
+	}
+	p.replicate(&peerpb.Mutation{Op: peerpb.Operation_OPERATION_LOCK, Recursive: true, Shared: true, Actor: roleOf(ctx).Name})
+	return nil
+}
+
+func (p *PathElement) rlockSubsContext(ctx context.Context) error {
+	if err := p.lockHierarchy(ctx, true, true); err != nil {
+		return err
+	}
+	p.selfnotify <- elementChange{id: randid.Uint64(), elem: p, change: ChangeSharedLocked, actor: roleOf(ctx)}
+	return nil
+}
+
+// TryRLockSubs places a shared lock on this Path Element and every Path Element it is a parent to,
+// returning ErrLockUnavailable rather than waiting any longer than defaultLockAttemptTimeout for any of them
+func (p *PathElement) TryRLockSubs() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultLockAttemptTimeout)
+	defer cancel()
+	if p.RLockSubsContext(ctx) != nil {
+		return ErrLockUnavailable
+	}
+	return nil
+}
+
+// RUnLockSubs gives up a shared lock on this Path Element and every Path Element it is a parent to
+func (p *PathElement) RUnLockSubs() {
+	if p.runlockSubs(access.Role{}, lockOwner{}) {
+		p.replicate(&peerpb.Mutation{Op: peerpb.Operation_OPERATION_UNLOCK, Recursive: true, Shared: true})
+	}
+}
+
+func (p *PathElement) runlockSubs(actor access.Role, owner lockOwner) bool {
+	if !p.unlockHierarchy(true, true, owner) {
+		return false
+	}
+	p.selfnotify <- elementChange{id: randid.Uint64(), elem: p, change: ChangeSharedUnlocked, actor: actor}
+	return true
+}
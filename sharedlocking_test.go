@@ -0,0 +1,134 @@
+package whatnot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedLocking(t *testing.T) {
+	t.Run("Shared leases are held at once", sharedLeasesHeldAtOnce)
+	t.Run("Shared leases keep out exclusive leases", sharedLeasesKeepOutExclusive)
+	t.Run("Shared prefix leases keep out exclusive leases beneath them", sharedPrefixKeepsOutSubtree)
+	t.Run("Waiting exclusive leases are not starved by new shared leases", exclusiveNotStarved)
+	t.Run("Shared locks are sent to watchers", sharedLocksAreWatched)
+}
+
+func sharedLeasesHeldAtOnce(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/shared/readers")
+
+	first, releaseFirst := elem.LockSharedWithLease(time.Minute)
+	defer releaseFirst()
+	second, releaseSecond := elem.LockSharedWithLease(time.Minute)
+	defer releaseSecond()
+	assert.Nil(t, first.Err(), "first shared lease was not granted")
+	assert.Nil(t, second.Err(), "second shared lease was not granted alongside the first")
+	assert.Equal(t, 2, elem.reslock.sharedBy())
+
+	mode, ok := first.Mode(elem)
+	assert.True(t, ok && mode == LeaseShared, "lease does not report holding the element shared")
+	assert.Nil(t, elem.TryRLock(), "shared lock was not given alongside shared leases")
+	elem.RUnLock()
+}
+
+func sharedLeasesKeepOutExclusive(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/shared/exclusive")
+
+	_, release := elem.LockSharedWithLease(time.Minute)
+	exclusive := make(chan *LeaseContext)
+	go func() {
+		lease, _ := elem.LockWithLease(time.Minute)
+		exclusive <- lease
+	}()
+	select {
+	case <-exclusive:
+		t.Error("exclusive lease was granted while a shared lease was held")
+		return
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	release()
+	select {
+	case lease := <-exclusive:
+		assert.Nil(t, lease.Err(), "exclusive lease failed")
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, elem.RLockContext(ctx), "shared lock was given alongside an exclusive lease")
+		lease.cancel()
+	case <-time.After(time.Second * 2):
+		t.Error("exclusive lease was not granted once the shared lease was released")
+	}
+}
+
+func sharedPrefixKeepsOutSubtree(t *testing.T) {
+	gns := createTestNamespace(t)
+	prefix, _ := gns.FetchOrCreateAbsolutePath("/catalog")
+	child, _ := gns.FetchOrCreateAbsolutePath("/catalog/items/42")
+
+	shared, release := prefix.LockPrefixSharedWithLease(time.Minute)
+	defer release()
+	assert.Nil(t, shared.Err(), "shared prefix lease was not granted")
+
+	assert.Equal(t, ErrLockUnavailable, child.TryLock(), "element beneath a shared prefix was locked exclusively")
+	assert.Nil(t, child.TryRLock(), "element beneath a shared prefix was not shared")
+	child.RUnLock()
+
+	release()
+	assert.Eventually(t, func() bool { return prefix.reslock.sharedBy() == 0 }, time.Second, time.Millisecond*10, "prefix was not released")
+	assert.Nil(t, child.TryLock(), "element was not free once the shared prefix was released")
+	child.UnLock()
+}
+
+func exclusiveNotStarved(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/shared/starved")
+
+	elem.RLock()
+	exclusive := make(chan struct{})
+	go func() {
+		elem.Lock()
+		close(exclusive)
+	}()
+	assert.Eventually(t, func() bool { return len(elem.LockWaiters()) == 1 }, time.Second, time.Millisecond*10, "exclusive lock did not start waiting")
+
+	assert.Equal(t, ErrLockUnavailable, elem.TryRLock(), "shared lock was given ahead of a waiting exclusive lock")
+	elem.RUnLock()
+	select {
+	case <-exclusive:
+		elem.UnLock()
+	case <-time.After(time.Second):
+		t.Error("exclusive lock was not given once the shared lock was released")
+	}
+}
+
+func sharedLocksAreWatched(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/shared/watched")
+	sub := elem.SubscribeToEvents(false)
+	defer elem.UnSubscribeFromEvents(sub)
+
+	// subscribers not ready to receive an event are dropped, so the loop below must already be waiting
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		elem.RLock()
+		time.Sleep(time.Millisecond * 50)
+		elem.RUnLock()
+	}()
+
+	var seen []ChangeType
+	timeout := time.After(time.Second * 2)
+	for len(seen) < 2 {
+		select {
+		case e := <-sub.Events():
+			seen = append(seen, e.Change)
+		case <-timeout:
+			t.Errorf("shared locks were not sent to watchers, saw %v", seen)
+			return
+		}
+	}
+	assert.Equal(t, []ChangeType{ChangeSharedLocked, ChangeSharedUnlocked}, seen)
+}
@@ -0,0 +1,315 @@
+package whatnot
+
+/*
+Snapshot transfer brings an instance joining a running cluster up to date.
+Every replication stream begins with the sender's full state - every namespace, path, value, lock lease and
+semaphore pool - followed by an end marker, and only then the sender's incremental changes.
+An instance that has not yet been synchronized applies the first complete snapshot it receives, and ignores
+the snapshots of every other stream, so the changes following them are all it takes from there on.
+*/
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/databeast/whatnot/peerpb"
+)
+
+// snapshotState tracks which peer's snapshot, if any, is being applied to this instance
+type snapshotState struct {
+	mu     *sync.Mutex
+	synced bool
+	source string // node whose snapshot is currently being applied
+	// locks taken from a snapshot, that the same origin's own replicated locks may follow
+	locks map[string]int
+}
+
+func newSnapshotState() *snapshotState {
+	return &snapshotState{
+		mu:    &sync.Mutex{},
+		locks: make(map[string]int),
+	}
+}
+
+// accept reports if the origin's snapshot should be applied, claiming the snapshot for it if nobody has
+func (s *snapshotState) accept(origin string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.synced {
+		return false
+	}
+	if s.source == "" {
+		s.source = origin
+	}
+	return s.source == origin
+}
+
+// complete marks the origin's snapshot as fully applied
+func (s *snapshotState) complete(origin string) {
+	s.mu.Lock()
+	if s.source == origin {
+		s.synced = true
+	}
+	s.mu.Unlock()
+}
+
+// abandon lets another peer's snapshot be applied, if the origin's stream failed before its snapshot was complete
+func (s *snapshotState) abandon(origin string) {
+	s.mu.Lock()
+	if !s.synced && s.source == origin {
+		s.source = ""
+	}
+	s.mu.Unlock()
+}
+
+func (s *snapshotState) markLock(key string) {
+	s.mu.Lock()
+	s.locks[key]++
+	s.mu.Unlock()
+}
+
+// consumeLock reports if a lock was taken from a snapshot under the key, forgetting one of them
+func (s *snapshotState) consumeLock(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locks[key] == 0 {
+		return false
+	}
+	if s.locks[key]--; s.locks[key] == 0 {
+		delete(s.locks, key)
+	}
+	return true
+}
+
+// Synchronized reports if this instance has received a complete snapshot of a peer's state
+func (m *NameSpaceManager) Synchronized() bool {
+	if m.peering == nil {
+		return false
+	}
+	m.peering.snapshots.mu.Lock()
+	defer m.peering.snapshots.mu.Unlock()
+	return m.peering.snapshots.synced
+}
+
+// receiveSnapshot handles a snapshot mutation from a peer, returning true once the peer's snapshot has been accepted
+func (s *peerSync) receiveSnapshot(m *peerpb.Mutation) (accepted bool, err error) {
+	if !s.snapshots.accept(m.Origin) {
+		return false, nil // already synchronized, or another peer's snapshot is being applied
+	}
+	if m.Op == peerpb.Operation_OPERATION_SNAPSHOT_END {
+		s.snapshots.complete(m.Origin)
+		s.Infof("synchronized with the snapshot from %s", m.Origin)
+		return true, nil
+	}
+	return true, s.applySnapshot(m)
+}
+
+// applySnapshot applies a single snapshot mutation, creating its namespace if we do not have it yet
+func (s *peerSync) applySnapshot(m *peerpb.Mutation) error {
+	ns, err := s.manager.fetchOrRegisterNamespace(m.Namespace)
+	if err != nil {
+		return err
+	}
+	path := absolutePathFromStrings(m.Path)
+
+	switch m.Op {
+	case peerpb.Operation_OPERATION_NAMESPACE:
+		return nil
+	case peerpb.Operation_OPERATION_SEMAPHORE:
+		elem, err := ns.fetchOrRegisterAbsolutePath(path)
+		if err != nil {
+			return err
+		}
+		err = elem.CreateSemaphorePool(m.Recursive, true, SemaphorePoolOpts{PoolSize: m.PoolSize, Prefix: m.Recursive})
+		if err != nil {
+			return err
+		}
+		elem.semaphores.mu.Lock()
+		elem.semaphores.usedslots = m.PoolUsed
+		elem.semaphores.mu.Unlock()
+		return nil
+	case peerpb.Operation_OPERATION_LOCK:
+		elem, err := ns.fetchOrRegisterAbsolutePath(path)
+		if err != nil {
+			return err
+		}
+		key := lockChainKey(m)
+		s.snapshots.markLock(key)
+		s.remoteLocks.then(key, func() {
+			s.lockRemote(elem, m)
+		})
+		return nil
+	default:
+		return s.apply(m)
+	}
+}
+
+// fetchOrRegisterNamespace returns the named namespace, registering a new one if it does not exist
+func (m *NameSpaceManager) fetchOrRegisterNamespace(name string) (*Namespace, error) {
+	if ns, err := m.FetchNamespace(name); err == nil {
+		return ns, nil
+	}
+	ns := NewNamespace(name)
+	ns.fromSnapshot = true
+	if err := m.RegisterNamespace(ns); err != nil {
+		return m.FetchNamespace(name) // registered by someone else in the meantime
+	}
+	return m.FetchNamespace(name)
+}
+
+// adopt takes over the contents of a namespace created from a peer's snapshot
+// before the application registered its own instance of it. The manager holds its mutex throughout,
+// so nothing else can reach either namespace through it until the tree has moved
+func (ns *Namespace) adopt(from *Namespace) {
+	from.root.setNamespace(ns)
+	ns.root = from.root
+	from.tombmu.Lock()
+	for path, deleted := range from.tombstones {
+		ns.bury(path, deleted)
+	}
+	from.tombmu.Unlock()
+	// tokens already issued on the adopted elements must stay smaller than any issued from here on
+	from.fencemu.Lock()
+	ns.fencemu.Lock()
+	if from.fence > ns.fence {
+		ns.fence = from.fence
+	}
+	ns.fencemu.Unlock()
+	from.fencemu.Unlock()
+	from.manager = nil
+}
+
+// setNamespace moves this element and everything beneath it to another namespace
+func (p *PathElement) setNamespace(ns *Namespace) {
+	p.namespace = ns
+	for _, c := range p.sortedChildren() {
+		c.setNamespace(ns)
+	}
+}
+
+// snapshot captures the full state of every namespace, as the mutations that would recreate it
+func (m *NameSpaceManager) snapshot() (entries []*peerpb.Mutation) {
+	m.mu.Lock()
+	var names []string
+	for name := range m.namespaces {
+		names = append(names, name)
+	}
+	m.mu.Unlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		ns, err := m.FetchNamespace(name)
+		if err != nil {
+			continue // unregistered since we listed it
+		}
+		tree := []*peerpb.Mutation{{Op: peerpb.Operation_OPERATION_NAMESPACE}}
+		var locks []*peerpb.Mutation
+		for _, child := range ns.root.sortedChildren() {
+			child.snapshot(&tree, &locks)
+		}
+		// locks go last, so recursive locks cover every element beneath them
+		tree = append(tree, locks...)
+		for _, e := range tree {
+			e.Namespace = name
+		}
+		entries = append(entries, tree...)
+	}
+	for _, e := range entries {
+		e.Snapshot = true
+	}
+	return append(entries, &peerpb.Mutation{Op: peerpb.Operation_OPERATION_SNAPSHOT_END})
+}
+
+// snapshot appends the mutations recreating this element and everything beneath it
+func (p *PathElement) snapshot(entries *[]*peerpb.Mutation, locks *[]*peerpb.Mutation) {
+	path := p.AbsolutePath().toStrings()
+	children := p.sortedChildren()
+
+	p.mu.Lock()
+	val, version := p.resval.Val, p.version
+	p.mu.Unlock()
+	if val != nil {
+		encoded, err := json.Marshal(val)
+		if err != nil {
+			p.Warnf("cannot include value of %s in snapshot: %s", p.AbsolutePath().ToPathString(), err.Error())
+		} else {
+			*entries = append(*entries, &peerpb.Mutation{Op: peerpb.Operation_OPERATION_SET_VALUE, Path: path, Value: encoded, Change: int32(ChangeEdited), Version: version.toProto()})
+		}
+	} else if len(children) == 0 {
+		// elements with values or children are registered along with them
+		*entries = append(*entries, &peerpb.Mutation{Op: peerpb.Operation_OPERATION_REGISTER, Path: path})
+	}
+
+	if pool := p.semaphores; pool != nil && (p.parent == nil || p.parent.semaphores != pool) {
+		shared := false
+		for _, c := range children {
+			shared = shared || c.semaphores == pool
+		}
+		pool.mu.RLock()
+		*entries = append(*entries, &peerpb.Mutation{
+			Op:        peerpb.Operation_OPERATION_SEMAPHORE,
+			Path:      path,
+			Recursive: shared,
+			PoolSize:  pool.maxslots,
+			PoolUsed:  pool.usedslots,
+		})
+		pool.mu.RUnlock()
+	}
+
+	for _, lock := range p.snapshotLocks() {
+		lock.Path = path
+		*locks = append(*locks, lock)
+	}
+
+	for _, c := range children {
+		c.snapshot(entries, locks)
+	}
+}
+
+// snapshotLocks describes the locks held on this element, either alone or over everything beneath it
+// each lease holding it is described with who it was granted to, then each hold taken without a lease
+func (p *PathElement) snapshotLocks() (locks []*peerpb.Mutation) {
+	p.reslock.selfmu.Lock()
+	held := p.reslock.held
+	holders := make([]LockHolder, 0, len(p.reslock.holders))
+	for _, holder := range p.reslock.holders {
+		holders = append(holders, holder)
+	}
+	p.reslock.selfmu.Unlock()
+	sort.Slice(holders, func(i, j int) bool { return holders[i].Token < holders[j].Token })
+
+	for _, holder := range holders {
+		shared := holder.Mode == LeaseShared
+		own, _ := lockModesFor(holder.Prefix, shared)
+		held[own[len(own)-1]]--
+		locks = append(locks, &peerpb.Mutation{
+			Op:        peerpb.Operation_OPERATION_LOCK,
+			Recursive: holder.Prefix,
+			Shared:    shared,
+			Actor:     holder.Role.Name,
+		})
+	}
+
+	if held[modeX] > 0 || held[modeSelfX] > 0 {
+		locks = append(locks, &peerpb.Mutation{Op: peerpb.Operation_OPERATION_LOCK, Recursive: held[modeX] > 0})
+	}
+	for i := 0; i < held[modeS]; i++ {
+		locks = append(locks, &peerpb.Mutation{Op: peerpb.Operation_OPERATION_LOCK, Recursive: true, Shared: true})
+	}
+	for i := 0; i < held[modeSelfS]; i++ {
+		locks = append(locks, &peerpb.Mutation{Op: peerpb.Operation_OPERATION_LOCK, Shared: true})
+	}
+	return locks
+}
+
+// sortedChildren lists the elements directly beneath this one, in path order
+func (p *PathElement) sortedChildren() (children []*PathElement) {
+	p.mu.Lock()
+	for _, c := range p.children {
+		children = append(children, c)
+	}
+	p.mu.Unlock()
+	sort.Slice(children, func(i, j int) bool { return children[i].section < children[j].section })
+	return children
+}
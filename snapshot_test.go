@@ -0,0 +1,185 @@
+package whatnot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/databeast/whatnot/access"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestSnapshotTransfer(t *testing.T) {
+	t.Run("Joining peer receives existing state", joiningPeerReceivesState)
+	t.Run("Joining peer receives held leases", joiningPeerReceivesLeases)
+	t.Run("Joining peer receives shared locks", joiningPeerReceivesSharedLocks)
+	t.Run("Snapshot locks name each leaseholder", snapshotLocksNameHolders)
+	t.Run("Joining peer receives semaphore pools", joiningPeerReceivesSemaphores)
+	t.Run("Joining peer switches to incremental replication", joiningPeerReplicatesChanges)
+	t.Run("Registering takes over a namespace created from a snapshot", registeringTakesOverSnapshotNamespace)
+}
+
+// createJoiningPair creates an instance with existing state, then a second empty instance that joins it
+func createJoiningPair(t *testing.T, populate func(nsm *NameSpaceManager, ns *Namespace)) (existing *NameSpaceManager, joining *NameSpaceManager) {
+	cluster := bufconnCluster{
+		"existing": bufconn.Listen(1024 * 1024),
+		"joining":  bufconn.Listen(1024 * 1024),
+	}
+	existing, err := NewNamespaceManager(cluster.peerSyncOption("existing"))
+	if !assert.Nil(t, err, "creating existing manager failed") {
+		t.FailNow()
+	}
+	t.Cleanup(func() { _ = existing.Close() })
+	ns := NewNamespace(testNameSpace)
+	if !assert.Nil(t, existing.RegisterNamespace(ns), "registering namespace failed") {
+		t.FailNow()
+	}
+	populate(existing, ns)
+
+	joining, err = NewNamespaceManager(cluster.peerSyncOption("joining"))
+	if !assert.Nil(t, err, "creating joining manager failed") {
+		t.FailNow()
+	}
+	t.Cleanup(func() { _ = joining.Close() })
+	assert.Nil(t, existing.AddPeer("joining"), "adding joining peer failed")
+	assert.Nil(t, joining.AddPeer("existing"), "adding existing peer failed")
+
+	assert.Eventually(t, joining.Synchronized, peerSyncTimeout, time.Millisecond*10, "joining peer never synchronized")
+	return existing, joining
+}
+
+func joiningPeerReceivesState(t *testing.T) {
+	_, joining := createJoiningPair(t, func(nsm *NameSpaceManager, ns *Namespace) {
+		_ = ns.RegisterAbsolutePath(PathString("/snapshot/empty").ToAbsolutePath())
+		elem, _ := ns.FetchOrCreateAbsolutePath("/snapshot/valued")
+		elem.SetValue(ElementValue{Val: "snapshotted"}, ChangeEdited, access.Role{})
+		_ = nsm.RegisterNamespace(NewNamespace("secondary"))
+		secondary, _ := nsm.FetchNamespace("secondary")
+		_ = secondary.RegisterAbsolutePath(PathString("/other/path").ToAbsolutePath())
+	})
+
+	ns, err := joining.FetchNamespace(testNameSpace)
+	if !assert.Nil(t, err, "namespace was not created from snapshot") {
+		return
+	}
+	assert.NotNil(t, ns.FetchAbsolutePath("/snapshot/empty"), "path without value was not transferred")
+	elem := ns.FetchAbsolutePath("/snapshot/valued")
+	if assert.NotNil(t, elem, "path with value was not transferred") {
+		assert.Equal(t, "snapshotted", elem.GetValue().Val, "value was not transferred")
+	}
+
+	secondary, err := joining.FetchNamespace("secondary")
+	if assert.Nil(t, err, "second namespace was not created from snapshot") {
+		assert.NotNil(t, secondary.FetchAbsolutePath("/other/path"), "second namespace path was not transferred")
+	}
+}
+
+func joiningPeerReceivesLeases(t *testing.T) {
+	_, joining := createJoiningPair(t, func(nsm *NameSpaceManager, ns *Namespace) {
+		elem, _ := ns.FetchOrCreateAbsolutePath("/snapshot/leased")
+		ctx := access.WithRole(context.Background(), access.Role{Name: "leaseholder"})
+		elem.ContextLockWithLease(ctx, time.Minute)
+	})
+
+	ns, _ := joining.FetchNamespace(testNameSpace)
+	var elem *PathElement
+	assert.Eventually(t, func() bool {
+		elem = ns.FetchAbsolutePath("/snapshot/leased")
+		return elem != nil && elem.reslock.isLocked()
+	}, peerSyncTimeout, time.Millisecond*10, "lease lock was not transferred")
+}
+
+func joiningPeerReceivesSharedLocks(t *testing.T) {
+	_, joining := createJoiningPair(t, func(nsm *NameSpaceManager, ns *Namespace) {
+		elem, _ := ns.FetchOrCreateAbsolutePath("/snapshot/shared")
+		elem.LockSharedWithLease(time.Minute)
+		elem.LockSharedWithLease(time.Minute)
+		prefix, _ := ns.FetchOrCreateAbsolutePath("/snapshot/sharedprefix")
+		prefix.LockPrefixSharedWithLease(time.Minute)
+	})
+
+	ns, _ := joining.FetchNamespace(testNameSpace)
+	assert.Eventually(t, func() bool {
+		elem := ns.FetchAbsolutePath("/snapshot/shared")
+		return elem != nil && elem.reslock.sharedBy() == 2
+	}, peerSyncTimeout, time.Millisecond*10, "shared locks were not transferred")
+	assert.Eventually(t, func() bool {
+		elem := ns.FetchAbsolutePath("/snapshot/sharedprefix")
+		return elem != nil && elem.reslock.sharedBy() == 1
+	}, peerSyncTimeout, time.Millisecond*10, "shared prefix lock was not transferred")
+
+	elem := ns.FetchAbsolutePath("/snapshot/shared")
+	if assert.NotNil(t, elem) {
+		assert.Equal(t, ErrLockUnavailable, elem.TryLock(), "element shared elsewhere was locked exclusively")
+	}
+}
+
+func snapshotLocksNameHolders(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/snapshot/holders")
+	for _, name := range []string{"first", "second"} {
+		ctx := access.WithRole(context.Background(), access.Role{Name: name})
+		_, release := elem.ContextLockSharedWithLease(ctx, time.Minute)
+		defer release()
+	}
+	elem.RLock()
+	defer elem.RUnLock()
+
+	var actors []string
+	for _, lock := range elem.snapshotLocks() {
+		assert.True(t, lock.Shared, "shared hold was described as exclusive")
+		actors = append(actors, lock.Actor)
+	}
+	assert.Equal(t, []string{"first", "second", ""}, actors, "shared holds were not described with their holders")
+}
+
+func joiningPeerReceivesSemaphores(t *testing.T) {
+	_, joining := createJoiningPair(t, func(nsm *NameSpaceManager, ns *Namespace) {
+		elem, _ := ns.FetchOrCreateAbsolutePath("/snapshot/pooled")
+		_ = elem.CreateSemaphorePool(false, false, SemaphorePoolOpts{PoolSize: 5})
+		_, _ = elem.semaphores.Claim(context.Background(), 1)
+	})
+
+	ns, _ := joining.FetchNamespace(testNameSpace)
+	elem := ns.FetchAbsolutePath("/snapshot/pooled")
+	if !assert.NotNil(t, elem, "pooled path was not transferred") || !assert.NotNil(t, elem.semaphores, "semaphore pool was not transferred") {
+		return
+	}
+	elem.semaphores.mu.RLock()
+	defer elem.semaphores.mu.RUnlock()
+	assert.Equal(t, int64(5), elem.semaphores.maxslots, "semaphore pool size differs")
+	assert.Equal(t, int64(1), elem.semaphores.usedslots, "claimed semaphore slots differ")
+}
+
+func joiningPeerReplicatesChanges(t *testing.T) {
+	existing, joining := createJoiningPair(t, func(nsm *NameSpaceManager, ns *Namespace) {
+		_ = ns.RegisterAbsolutePath(PathString("/snapshot/before").ToAbsolutePath())
+	})
+
+	ns, _ := existing.FetchNamespace(testNameSpace)
+	elem, _ := ns.FetchOrCreateAbsolutePath("/snapshot/after")
+	elem.SetValue(ElementValue{Val: "incremental"}, ChangeEdited, access.Role{})
+
+	joined, _ := joining.FetchNamespace(testNameSpace)
+	assert.Eventually(t, func() bool {
+		peerElem := joined.FetchAbsolutePath("/snapshot/after")
+		return peerElem != nil && peerElem.GetValue().Val == "incremental"
+	}, peerSyncTimeout, time.Millisecond*10, "change after snapshot was not replicated")
+}
+
+func registeringTakesOverSnapshotNamespace(t *testing.T) {
+	_, joining := createJoiningPair(t, func(nsm *NameSpaceManager, ns *Namespace) {
+		_ = ns.RegisterAbsolutePath(PathString("/snapshot/adopted").ToAbsolutePath())
+	})
+
+	ns := NewNamespace(testNameSpace)
+	if !assert.Nil(t, joining.RegisterNamespace(ns), "registering a namespace created from a snapshot failed") {
+		return
+	}
+	elem := ns.FetchAbsolutePath("/snapshot/adopted")
+	if assert.NotNil(t, elem, "registered namespace did not take over the snapshot contents") {
+		assert.Equal(t, ns, elem.namespace, "element still belongs to the snapshot namespace")
+	}
+	assert.NotNil(t, joining.RegisterNamespace(NewNamespace(testNameSpace)), "registering a namespace twice was allowed")
+}
@@ -0,0 +1,179 @@
+package whatnot
+
+/*
+Waiters for an element's lock queue in the order they arrived, within priority levels, and none is given the lock
+ahead of an earlier waiter it would conflict with. A steady stream of lockers can then never starve one that has
+been waiting longer, and the queue can be inspected to see who is waiting and for how long
+*/
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/databeast/whatnot/access"
+)
+
+// LockPriority orders waiters for an element's lock, higher priorities being queued ahead of lower ones
+// waiters of the same priority are queued in the order they arrived
+type LockPriority int
+
+const (
+	// LockPriorityLow waiters are only given the lock once no one of a higher priority is waiting for it
+	LockPriorityLow LockPriority = -1
+	// LockPriorityNormal is the priority of every lock taken with a context that does not set one
+	LockPriorityNormal LockPriority = 0
+	// LockPriorityHigh waiters are queued ahead of every normal and low priority waiter
+	LockPriorityHigh LockPriority = 1
+)
+
+type priorityKey struct{}
+
+// WithLockPriority returns a copy of the context that queues the locks and leases taken with it at the priority
+// a constant flow of higher priority lockers will keep lower priority ones waiting, so use it sparingly
+func WithLockPriority(ctx context.Context, priority LockPriority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// priorityOf is the priority a lock is queued at, LockPriorityNormal if the context was given none
+func priorityOf(ctx context.Context) LockPriority {
+	priority, _ := ctx.Value(priorityKey{}).(LockPriority)
+	return priority
+}
+
+// LockWaiter describes a locker queued for an element's lock
+type LockWaiter struct {
+	Path     PathString    // the element being locked, beneath the element asked about if it is waiting for an intention
+	Lease    LeaseID       // the lease the lock is for, zero if it was not requested by a lease
+	Role     access.Role   // who is waiting, empty if the lock was requested with no role
+	Mode     LeaseMode     // if the lock will hold the element alone, or share it
+	Prefix   bool          // the lock will hold everything beneath Path as well
+	Priority LockPriority  // where the waiter is queued
+	Since    time.Time     // when the locker began waiting, at the first element it queued for
+	Waited   time.Duration // how long the locker had waited when it was listed
+
+	peer string // the cluster peer the lock is replicated from, empty for a lock taken here
+}
+
+// newLockRequest describes one call to lock an element, which is queued for each element of its hierarchy in turn
+func newLockRequest(ctx context.Context, p *PathElement, recursive bool, shared bool) *LockWaiter {
+	req := &LockWaiter{
+		Path:     p.AbsolutePath().ToPathString(),
+		Role:     roleOf(ctx),
+		Mode:     LeaseExclusive,
+		Prefix:   recursive,
+		Priority: priorityOf(ctx),
+		Since:    time.Now(),
+	}
+	if shared {
+		req.Mode = LeaseShared
+	}
+	if lease, ok := ctx.(*LeaseContext); ok {
+		req.Lease = lease.id
+	}
+	req.peer, _ = ctx.Value(peerOriginKey{}).(string)
+	return req
+}
+
+// lockOwner is who an element's holds are counted for, so each can only give up its own
+// holds taken without a lease are counted together, apart from those taken for each cluster peer
+type lockOwner struct {
+	lease LeaseID
+	peer  string
+}
+
+func (o lockOwner) String() string {
+	if o.peer != "" {
+		return fmt.Sprintf("peer %s", o.peer)
+	}
+	return fmt.Sprintf("lease %s", o.lease)
+}
+
+func (w *LockWaiter) owner() lockOwner {
+	return lockOwner{lease: w.Lease, peer: w.peer}
+}
+
+// lockWaiter is a request's place in the queue for one element, and the modes it is waiting to hold there
+type lockWaiter struct {
+	req      *LockWaiter
+	modes    []lockMode
+	broken   *DeadlockError // set under selfmu when the waiter is failed to break a deadlock
+	reported bool           // a deadlock through the waiter has been logged, when deadlocks are not broken
+}
+
+// enqueue places the waiter behind everyone waiting at its priority or higher, the caller must hold selfmu
+func (r *resourceLock) enqueue(w *lockWaiter) {
+	i := len(r.queue)
+	for i > 0 && r.queue[i-1].req.Priority < w.req.Priority {
+		i--
+	}
+	r.queue = append(r.queue, nil)
+	copy(r.queue[i+1:], r.queue[i:])
+	r.queue[i] = w
+}
+
+// dequeue removes the waiter once it holds the lock or has given up, letting those behind it try again
+// the caller must hold selfmu
+func (r *resourceLock) dequeue(w *lockWaiter) {
+	for i, queued := range r.queue {
+		if queued == w {
+			r.queue = append(r.queue[:i], r.queue[i+1:]...)
+			break
+		}
+	}
+	r.wake()
+}
+
+// blockedInQueue reports if any waiter queued ahead of this one wants a mode it conflicts with
+// and that it may not overtake
+// the caller must hold selfmu
+func (r *resourceLock) blockedInQueue(w *lockWaiter) bool {
+	for _, queued := range r.queue {
+		if queued == w {
+			return false
+		}
+		for _, m := range w.modes {
+			for _, other := range queued.modes {
+				if !lockCompatible[m][other] && !r.overtakes(w, queued) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// overtakes reports if the waiter's lease may go ahead of one queued before it, which it may only when it already
+// holds the element in a mode the queued waiter conflicts with. The queued waiter is waiting for that lease anyway,
+// and queueing the lease behind it would leave each waiting for the other
+// the caller must hold selfmu
+func (r *resourceLock) overtakes(w *lockWaiter, queued *lockWaiter) bool {
+	if w.req.Lease == 0 {
+		return false // holds taken without a lease cannot be told apart, so their lockers queue like anyone else
+	}
+	for m, count := range r.owners[w.req.owner()] {
+		if count == 0 {
+			continue
+		}
+		for _, other := range queued.modes {
+			if !lockCompatible[m][other] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LockWaiters lists everyone queued for this element's lock, in the order they will be given it
+// lockers of the elements beneath it are listed while they wait here for their intention on it
+func (p *PathElement) LockWaiters() (waiters []LockWaiter) {
+	now := time.Now()
+	p.reslock.selfmu.Lock()
+	defer p.reslock.selfmu.Unlock()
+	for _, w := range p.reslock.queue {
+		waiter := *w.req
+		waiter.Waited = now.Sub(waiter.Since)
+		waiters = append(waiters, waiter)
+	}
+	return waiters
+}
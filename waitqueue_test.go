@@ -0,0 +1,201 @@
+package whatnot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/databeast/whatnot/access"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockWaitQueue(t *testing.T) {
+	t.Run("Waiters are given the lock in the order they arrived", waitersServedInArrivalOrder)
+	t.Run("Higher priority waiters are queued ahead", higherPriorityQueuedAhead)
+	t.Run("Waiters are listed with their roles and wait", waitersListed)
+	t.Run("Cancelled waiters leave the queue", cancelledWaiterLeavesQueue)
+	t.Run("Queued prefix waiters are only overtaken by the leases they wait for", queuedPrefixWaiterGranted)
+}
+
+// queueLockers starts a locker for each context, one at a time so they arrive in order, each sending its index
+// once it holds the lock and then unlocking it
+func queueLockers(t *testing.T, elem *PathElement, contexts []context.Context) chan int {
+	order := make(chan int, len(contexts))
+	for i, ctx := range contexts {
+		i, ctx := i, ctx
+		go func() {
+			if elem.LockContext(ctx) == nil {
+				order <- i
+				elem.UnLock()
+			}
+		}()
+		assert.Eventually(t, func() bool { return len(elem.LockWaiters()) == i+1 }, time.Second, time.Millisecond*5, "locker %d did not start waiting", i)
+	}
+	return order
+}
+
+func waitersServedInArrivalOrder(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/queue/fifo")
+
+	elem.Lock()
+	contexts := make([]context.Context, 5)
+	for i := range contexts {
+		contexts[i] = context.Background()
+	}
+	order := queueLockers(t, elem, contexts)
+	elem.UnLock()
+
+	for i := range contexts {
+		select {
+		case served := <-order:
+			assert.Equal(t, i, served, "waiters were not given the lock in the order they arrived")
+		case <-time.After(time.Second * 2):
+			t.Errorf("waiter %d was never given the lock", i)
+			return
+		}
+	}
+}
+
+func higherPriorityQueuedAhead(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/queue/priority")
+
+	elem.Lock()
+	order := queueLockers(t, elem, []context.Context{
+		WithLockPriority(context.Background(), LockPriorityLow),
+		context.Background(),
+		WithLockPriority(context.Background(), LockPriorityHigh),
+	})
+	waiters := elem.LockWaiters()
+	if assert.Len(t, waiters, 3) {
+		assert.Equal(t, []LockPriority{LockPriorityHigh, LockPriorityNormal, LockPriorityLow},
+			[]LockPriority{waiters[0].Priority, waiters[1].Priority, waiters[2].Priority})
+	}
+	elem.UnLock()
+
+	for _, expected := range []int{2, 1, 0} {
+		select {
+		case served := <-order:
+			assert.Equal(t, expected, served, "waiters were not given the lock by priority")
+		case <-time.After(time.Second * 2):
+			t.Errorf("waiter %d was never given the lock", expected)
+			return
+		}
+	}
+}
+
+func waitersListed(t *testing.T) {
+	gns := createTestNamespace(t)
+	prefix, _ := gns.FetchOrCreateAbsolutePath("/queue/listed")
+	child, _ := gns.FetchOrCreateAbsolutePath("/queue/listed/child")
+
+	_, release := prefix.LockPrefixWithLease(time.Minute)
+	defer release()
+
+	leases := make(chan *LeaseContext, 1)
+	go func() {
+		lease, _ := child.ContextLockWithLease(access.WithRole(context.Background(), access.Role{Name: "worker"}), time.Minute)
+		leases <- lease
+	}()
+	assert.Eventually(t, func() bool { return len(prefix.LockWaiters()) == 1 }, time.Second, time.Millisecond*5, "lease did not start waiting")
+	time.Sleep(time.Millisecond * 20)
+
+	waiters := prefix.LockWaiters()
+	if assert.Len(t, waiters, 1) {
+		assert.Equal(t, "worker", waiters[0].Role.Name)
+		assert.Equal(t, PathString("/queue/listed/child"), waiters[0].Path, "waiter for an intention was not listed with the element it is locking")
+		assert.NotZero(t, waiters[0].Lease, "waiter was not listed with its lease")
+		assert.True(t, waiters[0].Waited >= time.Millisecond*20, "waiter was listed as waiting %s", waiters[0].Waited)
+	}
+	assert.Empty(t, child.LockWaiters(), "waiter was listed on an element it has not reached")
+
+	release()
+	select {
+	case lease := <-leases:
+		assert.Nil(t, lease.Err(), "waiting lease was not granted")
+		lease.cancel()
+	case <-time.After(time.Second * 2):
+		t.Error("waiting lease was never granted")
+	}
+}
+
+func cancelledWaiterLeavesQueue(t *testing.T) {
+	gns := createTestNamespace(t)
+	elem, _ := gns.FetchOrCreateAbsolutePath("/queue/cancelled")
+
+	elem.Lock()
+	ctx, cancel := context.WithCancel(context.Background())
+	failed := make(chan error)
+	go func() { failed <- elem.LockContext(ctx) }()
+	assert.Eventually(t, func() bool { return len(elem.LockWaiters()) == 1 }, time.Second, time.Millisecond*5, "locker did not start waiting")
+
+	cancel()
+	select {
+	case err := <-failed:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Error("cancelled waiter kept waiting")
+		return
+	}
+	assert.Empty(t, elem.LockWaiters(), "cancelled waiter was left in the queue")
+
+	elem.UnLock()
+	assert.Nil(t, elem.TryLock(), "lock was held by a cancelled waiter")
+	elem.UnLock()
+}
+
+func queuedPrefixWaiterGranted(t *testing.T) {
+	gns := createTestNamespace(t)
+	prefix, _ := gns.FetchOrCreateAbsolutePath("/queue/prefix")
+	first, _ := gns.FetchOrCreateAbsolutePath("/queue/prefix/first")
+	second, _ := gns.FetchOrCreateAbsolutePath("/queue/prefix/second")
+	third, _ := gns.FetchOrCreateAbsolutePath("/queue/prefix/third")
+	fourth, _ := gns.FetchOrCreateAbsolutePath("/queue/prefix/fourth")
+
+	holder, releaseHolder := gns.GrantLease(time.Minute)
+	defer releaseHolder()
+	waiting, releaseWaiting := gns.GrantLease(time.Minute)
+	defer releaseWaiting()
+	latecomer, releaseLatecomer := gns.GrantLease(time.Minute)
+	defer releaseLatecomer()
+	assert.Nil(t, holder.Attach(first))
+	assert.Nil(t, latecomer.AttachShared(third))
+
+	prefixWaits := make(chan error, 1)
+	go func() { prefixWaits <- waiting.AttachPrefixShared(prefix) }()
+	assert.Eventually(t, func() bool { return len(prefix.LockWaiters()) == 1 }, time.Second, time.Millisecond*5, "prefix lease did not start waiting")
+
+	// the prefix waiter is waiting for the holder anyway, so the holder may go ahead of it
+	select {
+	case err := <-attachLater(holder, second):
+		assert.Nil(t, err, "lease holding beneath a queued prefix waiter could not attach another element there")
+	case <-time.After(time.Second * 2):
+		t.Error("lease holding beneath a queued prefix waiter was queued behind it")
+		return
+	}
+
+	// the latecomer's shared hold does not keep the prefix waiter out, so the latecomer waits its turn
+	latecomerWaits := attachLater(latecomer, fourth)
+	assert.Eventually(t, func() bool { return len(prefix.LockWaiters()) == 2 }, time.Second, time.Millisecond*5, "latecomer did not start waiting")
+
+	releaseHolder()
+	select {
+	case err := <-prefixWaits:
+		assert.Nil(t, err, "prefix lease was not given the prefix once the holder was released")
+	case err := <-latecomerWaits:
+		t.Errorf("latecomer went ahead of the queued prefix lease with %v", err)
+		return
+	case <-time.After(time.Second * 2):
+		t.Error("prefix lease was never given the prefix")
+		return
+	}
+
+	releaseWaiting()
+	select {
+	case err := <-latecomerWaits:
+		assert.Nil(t, err, "latecomer was not given its element once the prefix lease was released")
+	case <-time.After(time.Second * 2):
+		t.Error("latecomer was never given its element")
+	}
+}
@@ -8,6 +8,9 @@ import (
 
 type changeType int
 
+// ChangeType names the type of the Change constants, for code outside this package that stores or compares them
+type ChangeType = changeType
+
 const (
 	ChangeUnknown changeType = iota + 1
 	ChangeLocked
@@ -17,15 +20,59 @@ const (
 	ChangeDeleted
 	ChangePruned
 	ChangeReleased
+	ChangeReconciled     // the element was repaired to match a cluster peer by anti-entropy reconciliation
+	ChangeQuorumLost     // a lease on the element can no longer be trusted, as its instance lost contact with a quorum
+	ChangeRenewed        // a lease on the element was renewed, its new deadline is given in the note
+	ChangeSharedLocked   // the element was locked alongside any other shared holders, by RLock or a shared lease
+	ChangeSharedUnlocked // a shared hold on the element was given up, other shared holders may remain
+	ChangeRevoked        // a lease on the element was revoked, the actor is who revoked it
 )
 
+var changeNames = map[changeType]string{
+	ChangeUnknown:    "unknown",
+	ChangeLocked:     "locked",
+	ChangeUnlocked:   "unlocked",
+	ChangeAdded:      "added",
+	ChangeEdited:     "edited",
+	ChangeDeleted:    "deleted",
+	ChangePruned:     "pruned",
+	ChangeReleased:   "released",
+	ChangeReconciled: "reconciled",
+	ChangeQuorumLost: "quorum lost",
+	ChangeRenewed:    "renewed",
+
+	ChangeSharedLocked:   "shared locked",
+	ChangeSharedUnlocked: "shared unlocked",
+	ChangeRevoked:        "revoked",
+}
+
+// String names the change, for logging and for events sent outside the process
+func (c changeType) String() string {
+	if name, ok := changeNames[c]; ok {
+		return name
+	}
+	return changeNames[ChangeUnknown]
+}
+
+// ParseChange is the change with the given name, as returned by String, or ChangeUnknown if there is none
+func ParseChange(name string) ChangeType {
+	for change, known := range changeNames {
+		if known == name {
+			return change
+		}
+	}
+	return ChangeUnknown
+}
+
 // elementChange is a notification channel structure
 // for communicating changes to individual elements to subscribed watchers
 type elementChange struct {
-	id     uint64
-	elem   *PathElement
-	change changeType
-	actor  access.Role
+	id      uint64
+	elem    *PathElement
+	change  changeType
+	actor   access.Role
+	note    string
+	version Version
 }
 
 // ElementWatchSubscription is a contract to be notified
@@ -49,6 +96,8 @@ type WatchEvent struct {
 	Change changeType
 	Actor  access.Role
 	Note   string
+	// Version of the element's value after the change, the winning version if writes conflicted
+	Version Version
 }
 
 func (e WatchEvent) OnElement() *PathElement {
@@ -2,8 +2,27 @@ package whatnot
 
 import (
 	"math/rand"
+	"sync"
 	"time"
 )
 
 // random generator for internal IDs
-var randid = rand.New(rand.NewSource(time.Now().UnixNano()))
+var randid = &lockedRand{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// lockedRand makes the random ID generator safe for use by every element's goroutines
+type lockedRand struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func (r *lockedRand) Uint64() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rnd.Uint64()
+}
+
+func (r *lockedRand) Intn(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rnd.Intn(n)
+}